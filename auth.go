@@ -0,0 +1,123 @@
+package gotds
+
+import (
+	"errors"
+	"net"
+	"strings"
+
+	"github.com/Grovespaz/go-tds/krb5"
+	"github.com/Grovespaz/go-tds/ntlm"
+)
+
+// integratedAuth logs in with integrated security, by exchanging SSPI tokens with the server.
+type integratedAuth interface {
+	// initialToken returns the token sent in the login.
+	initialToken() ([]byte, error)
+	// nextToken returns the answer to a token the server sent.
+	nextToken(serverToken []byte) ([]byte, error)
+}
+
+// newIntegratedAuth returns how to log in with integrated security, nil when SQL Server authentication is used instead.
+// Integrated security is used when it's configured, when the user is given as domain\user, or with a keytab or credential cache for Kerberos.
+func (c *Conn) newIntegratedAuth() (integratedAuth, error) {
+	domain, user, hasDomain := splitDomainUser(c.cfg.User)
+	kerberos := c.cfg.KerberosKeytab != "" || c.cfg.KerberosCCache != ""
+	if !c.cfg.IntegratedSecurity && !hasDomain && !kerberos {
+		return nil, nil
+	}
+	if kerberos || user == "" {
+		return c.newKerberosAuth(domain, user)
+	}
+	return &ntlmAuth{domain: domain, user: user, password: c.cfg.Password, workstation: c.hostname()}, nil
+}
+
+// splitDomainUser splits a user given as domain\user.
+func splitDomainUser(s string) (domain, user string, hasDomain bool) {
+	if i := strings.IndexByte(s, '\\'); i >= 0 {
+		return s[:i], s[i+1:], true
+	}
+	return "", s, false
+}
+
+// ntlmAuth logs in with NTLMv2: the login holds the NEGOTIATE message, to which the server answers with its CHALLENGE.
+// We then send the AUTHENTICATE message in an SSPI message, after which the server completes the login.
+type ntlmAuth struct {
+	domain, user, password, workstation string
+}
+
+func (a *ntlmAuth) initialToken() ([]byte, error) {
+	return ntlm.Negotiate(), nil
+}
+
+func (a *ntlmAuth) nextToken(challenge []byte) ([]byte, error) {
+	return ntlm.Authenticate(challenge, a.domain, a.user, a.password, a.workstation)
+}
+
+// newKerberosAuth returns the Kerberos login for the user given as user@REALM, DOMAIN\user or user, with the key in the keytab.
+// Without a keytab, the tickets in the credential cache are used, the default one of kinit if none is configured.
+func (c *Conn) newKerberosAuth(domain, user string) (integratedAuth, error) {
+	cfg, err := krb5.DefaultConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	var client *krb5.Client
+	if c.cfg.KerberosKeytab != "" {
+		if user == "" {
+			return nil, errors.New("Logging in with a keytab requires a user")
+		}
+		realm := strings.ToUpper(domain)
+		if i := strings.LastIndexByte(user, '@'); i >= 0 {
+			user, realm = user[:i], user[i+1:]
+		}
+		kt, err := krb5.LoadKeytab(c.cfg.KerberosKeytab)
+		if err != nil {
+			return nil, err
+		}
+		if client, err = krb5.NewClientWithKeytab(kt, user, realm, cfg); err != nil {
+			return nil, err
+		}
+	} else {
+		path := c.cfg.KerberosCCache
+		if path == "" {
+			path = krb5.DefaultCCache()
+		}
+		cc, err := krb5.LoadCCache(path)
+		if err != nil {
+			return nil, err
+		}
+		if client, err = krb5.NewClientWithCCache(cc, cfg); err != nil {
+			return nil, err
+		}
+	}
+	return &kerberosAuth{client: client, spn: c.serverSPN()}, nil
+}
+
+// serverSPN returns the SPN of the server for Kerberos: MSSQLSvc/host:port, unless one is configured.
+func (c *Conn) serverSPN() string {
+	if c.cfg.ServerSPN != "" {
+		return c.cfg.ServerSPN
+	}
+	host, port, err := net.SplitHostPort(c.cfg.Addr)
+	if err != nil {
+		host, port = c.cfg.Addr, "1433"
+	}
+	return "MSSQLSvc/" + host + ":" + port
+}
+
+// kerberosAuth logs in with Kerberos through SPNEGO: the login holds the ticket for the server, to which the server answers with proof it could read it.
+// No answer to that is needed, it's followed by the rest of the login response.
+type kerberosAuth struct {
+	client  *krb5.Client
+	spn     string
+	context *krb5.SecContext
+}
+
+func (a *kerberosAuth) initialToken() (token []byte, err error) {
+	a.context, token, err = a.client.InitSecContext(a.spn)
+	return token, err
+}
+
+func (a *kerberosAuth) nextToken(serverToken []byte) ([]byte, error) {
+	return nil, a.context.Continue(serverToken)
+}
@@ -0,0 +1,250 @@
+package gotds
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	utf16c "github.com/Grovespaz/go-tds/utf16"
+)
+
+// makeNTLMChallenge returns a CHALLENGE_MESSAGE with an empty target info.
+func makeNTLMChallenge() []byte {
+	b := new(bytes.Buffer)
+	b.WriteString("NTLMSSP\x00")
+	binary.Write(b, binary.LittleEndian, uint32(2))
+	b.Write([]byte{0, 0, 0, 0, 48, 0, 0, 0}) // No target name
+	binary.Write(b, binary.LittleEndian, uint32(0xe2888235))
+	b.Write([]byte{1, 2, 3, 4, 5, 6, 7, 8}) // Server challenge
+	b.Write(make([]byte, 8))
+	b.Write([]byte{4, 0, 4, 0, 48, 0, 0, 0}) // Target info
+	b.Write([]byte{0, 0, 0, 0})              // MsvAvEOL
+	return b.Bytes()
+}
+
+func makeSSPIToken(data []byte) []byte {
+	return append([]byte{byte(sspi), byte(len(data)), byte(len(data) >> 8)}, data...)
+}
+
+// ntlmField returns the string field of which the length and offset are at pos in an NTLM message.
+func ntlmField(msg []byte, pos int) string {
+	length := binary.LittleEndian.Uint16(msg[pos:])
+	offset := binary.LittleEndian.Uint32(msg[pos+4:])
+	return utf16c.Decode(msg[offset : offset+uint32(length)])
+}
+
+func TestNTLMLogin(t *testing.T) {
+	addr := serveTDS(t, func(s *testServerConn) {
+		s.preLogin()
+		login := s.read(ptyLogin)
+		if login[25]&0x80 == 0 || loginField(login, 1) != "" {
+			s.t.Error("Expected a login with integrated security instead of a user, got: ", login[25], loginField(login, 1))
+		}
+		offset := binary.LittleEndian.Uint16(login[78:])
+		if !bytes.HasPrefix(login[offset:], []byte("NTLMSSP\x00\x01\x00\x00\x00")) {
+			s.t.Error("Expected the NTLM NEGOTIATE_MESSAGE in the SSPI data, got: ", login[offset:])
+		}
+		s.write(makeSSPIToken(makeNTLMChallenge()))
+
+		authenticate := s.read(ptySSPIMessage)
+		if !bytes.HasPrefix(authenticate, []byte("NTLMSSP\x00\x03\x00\x00\x00")) {
+			s.t.Error("Expected an NTLM AUTHENTICATE_MESSAGE, got: ", authenticate)
+		}
+		if domain, user := ntlmField(authenticate, 28), ntlmField(authenticate, 36); domain != "CORP" || user != "alice" {
+			s.t.Error("Expected to authenticate as CORP\\alice, got: ", domain, user)
+		}
+		s.write(makeLoginAckToken(), testDoneToken)
+	}, (*testServerConn).preLogin)
+
+	cfg, err := ParseDSN("Server=" + addr + ";User ID=CORP\\alice;Password=secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := MakeConnection(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+
+	// Without a user, the default credential cache is used for Kerberos, of which there is none:
+	t.Setenv("KRB5CCNAME", filepath.Join(t.TempDir(), "krb5cc"))
+	cfg.User = ""
+	cfg.IntegratedSecurity = true
+	if _, err = MakeConnection(cfg); err == nil {
+		t.Fatal("Expected an error for integrated security without a user or credential cache")
+	}
+}
+
+// makeCCache returns a credential cache of user, holding a ticket for the service with the given SPN.
+func makeCCache(user, realm, spn string, ticket []byte) []byte {
+	appendData := func(b []byte, data string) []byte {
+		return append(binary.BigEndian.AppendUint32(b, uint32(len(data))), data...)
+	}
+	appendPrincipal := func(b []byte, nameType uint32, name string) []byte {
+		components := strings.Split(name, "/")
+		b = binary.BigEndian.AppendUint32(b, nameType)
+		b = binary.BigEndian.AppendUint32(b, uint32(len(components)))
+		b = appendData(b, realm)
+		for _, component := range components {
+			b = appendData(b, component)
+		}
+		return b
+	}
+
+	b := []byte{5, 4, 0, 0} // Version 4, without header fields
+	b = appendPrincipal(b, 1, user)
+	b = appendPrincipal(b, 1, user)
+	b = appendPrincipal(b, 2, spn)
+	b = binary.BigEndian.AppendUint16(b, 18) // An AES256 session key
+	b = appendData(b, strings.Repeat("k", 32))
+	for _, t := range []int64{0, 0, time.Now().Add(time.Hour).Unix(), 0} {
+		b = binary.BigEndian.AppendUint32(b, uint32(t))
+	}
+	b = append(b, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0) // No flags, addresses or authorization data
+	b = appendData(b, string(ticket))
+	return appendData(b, "")
+}
+
+func TestKerberosLogin(t *testing.T) {
+	ticket := []byte("\x61\x03\x02\x01\x05") // Which the client doesn't read
+	addr := serveTDS(t, func(s *testServerConn) {
+		s.preLogin()
+		login := s.read(ptyLogin)
+		offset, length := binary.LittleEndian.Uint16(login[78:]), binary.LittleEndian.Uint16(login[80:])
+		token := login[offset : offset+length]
+		spnego := []byte{0x06, 0x06, 0x2b, 0x06, 0x01, 0x05, 0x05, 0x02}
+		if login[25]&0x80 == 0 || !bytes.Contains(token, spnego) || !bytes.Contains(token, ticket) {
+			s.t.Error("Expected the ticket for the server in a SPNEGO token, got: ", token)
+		}
+		// A NegTokenResp which rejects us:
+		s.write(makeSSPIToken([]byte{0xa1, 0x07, 0x30, 0x05, 0xa0, 0x03, 0x0a, 0x01, 0x02}))
+	})
+	_, port, _ := net.SplitHostPort(addr)
+
+	dir := t.TempDir()
+	t.Setenv("KRB5_CONFIG", filepath.Join(dir, "krb5.conf"))
+	path := filepath.Join(dir, "krb5cc")
+	if err := os.WriteFile(path, makeCCache("alice", "EXAMPLE.COM", "MSSQLSvc/127.0.0.1:"+port, ticket), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := ParseDSN("Server=" + addr + ";Kerberos CCache=FILE:" + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = MakeConnection(cfg); err == nil || !strings.Contains(err.Error(), "rejected") {
+		t.Fatal("Expected the server to reject us, got: ", err)
+	}
+}
+
+func TestServerSPN(t *testing.T) {
+	c := &Conn{cfg: Config{Addr: "db.example.com:1533"}}
+	if spn := c.serverSPN(); spn != "MSSQLSvc/db.example.com:1533" {
+		t.Fatal("Wrong SPN, got: ", spn)
+	}
+	c.cfg.ServerSPN = "MSSQLSvc/db.example.com:Sales"
+	if spn := c.serverSPN(); spn != "MSSQLSvc/db.example.com:Sales" {
+		t.Fatal("Expected the configured SPN, got: ", spn)
+	}
+}
+
+// loginPassword returns the encoded password in a LOGIN7 message of which the offset and length are at pos: 44 for the password, 86 for the new one.
+func loginPassword(login []byte, pos int) []byte {
+	offset := binary.LittleEndian.Uint16(login[pos:])
+	length := binary.LittleEndian.Uint16(login[pos+2:])
+	return login[offset : offset+2*length]
+}
+
+func TestChangePassword(t *testing.T) {
+	addr := serveTDS(t, func(s *testServerConn) {
+		s.preLogin()
+		if login := s.read(ptyLogin); login[27]&0x01 != 0 || len(loginPassword(login, 86)) != 0 {
+			t.Error("Expected a login without a new password, got: ", login[27], loginPassword(login, 86))
+		}
+		s.write(makeErrorToken(18488, 14, "Login failed for user 'app'. Reason: The password of the account must be changed."), testDoneToken)
+	}, func(s *testServerConn) {
+		s.preLogin()
+		login := s.read(ptyLogin)
+		if login[27]&0x01 == 0 || !bytes.Equal(loginPassword(login, 44), encodePassword("old")) || !bytes.Equal(loginPassword(login, 86), encodePassword("new")) {
+			t.Error("Expected a login that changes the password, got: ", login[27], loginPassword(login, 44), loginPassword(login, 86))
+		}
+		s.write(makeLoginAckToken(), testDoneToken)
+	})
+
+	connector, err := NewConnector(&Config{Addr: addr, User: "app", Password: "old"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = connector.Connect(context.Background())
+	if !errors.Is(err, ErrPasswordMustChange) || errors.Is(err, ErrPasswordExpired) {
+		t.Fatal("Expected the password to have to be changed, got: ", err)
+	}
+
+	connector.cfg.NewPassword = "new"
+	conn, err := connector.Connect(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	// From here on the new password is the password:
+	if cfg := conn.(*Conn).cfg; cfg.Password != "new" || cfg.NewPassword != "" {
+		t.Fatal("Expected the connection to use the new password, got: ", cfg.Password, cfg.NewPassword)
+	}
+	if connector.cfg.Password != "new" || connector.cfg.NewPassword != "" {
+		t.Fatal("Expected the connector to use the new password, got: ", connector.cfg.Password, connector.cfg.NewPassword)
+	}
+}
+
+func TestChangePasswordConcurrently(t *testing.T) {
+	handlers := []func(s *testServerConn){func(s *testServerConn) {
+		s.preLogin()
+		if login := s.read(ptyLogin); login[27]&0x01 == 0 || !bytes.Equal(loginPassword(login, 86), encodePassword("new")) {
+			t.Error("Expected a login that changes the password, got: ", login[27], loginPassword(login, 86))
+		}
+		s.write(makeLoginAckToken(), testDoneToken)
+	}}
+	for i := 0; i < 3; i++ {
+		// Only the first connection changes the password, the others log in with the new one:
+		handlers = append(handlers, func(s *testServerConn) {
+			s.preLogin()
+			if login := s.read(ptyLogin); login[27]&0x01 != 0 || !bytes.Equal(loginPassword(login, 44), encodePassword("new")) {
+				t.Error("Expected a login with the new password, got: ", login[27], loginPassword(login, 44))
+			}
+			s.write(makeLoginAckToken(), testDoneToken)
+		})
+	}
+	addr := serveTDS(t, handlers...)
+
+	connector, err := NewConnector(&Config{Addr: addr, User: "app", Password: "old", NewPassword: "new"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	errs := make(chan error, len(handlers))
+	for range handlers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			conn, err := connector.Connect(context.Background())
+			if err == nil {
+				conn.Close()
+			}
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,261 @@
+package gotds
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// RowIterator provides the rows for BulkCopy.
+// Next returns the values of the next row in the order of the columns, and io.EOF once there are no more rows.
+type RowIterator interface {
+	Next() ([]interface{}, error)
+}
+
+// SliceRows is a RowIterator over rows held in memory.
+type SliceRows [][]interface{}
+
+// Next implements RowIterator.
+func (s *SliceRows) Next() ([]interface{}, error) {
+	if len(*s) == 0 {
+		return nil, io.EOF
+	}
+	row := (*s)[0]
+	*s = (*s)[1:]
+	return row, nil
+}
+
+// BulkOptions are the options of the INSERT BULK statement used by BulkCopy.
+type BulkOptions struct {
+	// Check constraints on the table while loading, these are ignored by default.
+	CheckConstraints bool
+	// Run the insert triggers of the table, these aren't by default.
+	FireTriggers bool
+	// Insert NULLs as is instead of using the default values of the columns.
+	KeepNulls bool
+	// Take a table lock instead of row locks for the duration of the load.
+	TabLock bool
+	// The number of rows per batch. Each batch is a separate INSERT BULK and is committed on its own.
+	// 0 sends all rows in a single batch.
+	BatchSize int
+}
+
+var ErrNoBulkColumns = errors.New("Bulk copy requires at least one column")
+
+// BulkCopy inserts all rows from the iterator into the given columns of a table, using the bulk load protocol.
+// The types of the columns are taken from the table itself, values are converted to them where possible.
+// Text, ntext, image, xml, sql_variant and CLR UDT columns are not supported.
+// It returns the number of rows that were copied.
+//
+// Each batch is committed by itself, so an error doesn't undo the batches before it. That includes the batch of a row
+// that can't be converted: the rows before it are still copied, and their number is returned along with the error.
+//
+// BulkCopy can be reached through database/sql using sql.Conn.Raw:
+//
+//	err = conn.Raw(func(driverConn interface{}) error {
+//		copied, err = driverConn.(*gotds.Conn).BulkCopy("dbo.Users", []string{"id", "name"}, &rows, gotds.BulkOptions{TabLock: true})
+//		return err
+//	})
+func (c *Conn) BulkCopy(table string, columnNames []string, rows RowIterator, opts BulkOptions) (int64, error) {
+	if len(columnNames) == 0 {
+		return 0, ErrNoBulkColumns
+	}
+
+	columns, err := c.bulkColumns(table, columnNames)
+	if err != nil {
+		return 0, err
+	}
+
+	var copied int64
+	for {
+		n, err := c.bulkCopyBatch(table, columnNames, columns, rows, opts)
+		copied += n
+		if err == io.EOF {
+			return copied, nil
+		}
+		if err != nil {
+			return copied, err
+		}
+	}
+}
+
+// bulkColumns determines the types of the destination columns by selecting them without any rows.
+// They are converted to their nullable counterparts, which is what we send them as.
+func (c *Conn) bulkColumns(table string, columnNames []string) ([]columnInfo, error) {
+	quoted := make([]string, len(columnNames))
+	for i, name := range columnNames {
+		quoted[i] = quoteIdentifier(name)
+	}
+
+	rows, err := c.Query("SELECT TOP 0 "+strings.Join(quoted, ", ")+" FROM "+table, nil)
+	if err != nil {
+		return nil, err
+	}
+	columns := rows.(Rows).columnTypes
+	if len(columns) != len(columnNames) {
+		return nil, fmt.Errorf("Expected %v columns from %v, got %v", len(columnNames), table, len(columns))
+	}
+
+	for i, column := range columns {
+		switch column.columnType {
+		case TEXTTYPE, NTEXTTYPE, IMAGETYPE, XMLTYPE, SSVARIANTTYPE, UDTTYPE:
+			return nil, fmt.Errorf("Column %v: bulk copy of %v is not supported", columnNames[i], column.typeName())
+		}
+		columns[i] = column.nullable()
+	}
+	return columns, nil
+}
+
+// bulkCopyBatch sends a single batch: an INSERT BULK statement followed by the bulk load itself.
+// It returns io.EOF once all rows are copied.
+func (c *Conn) bulkCopyBatch(table string, columnNames []string, columns []columnInfo, rows RowIterator, opts BulkOptions) (int64, error) {
+	// Make sure there is at least one row before starting a batch:
+	row, err := rows.Next()
+	if err != nil {
+		return 0, err
+	}
+
+	if _, err = c.Exec(makeInsertBulkStatement(table, columnNames, columns, opts), nil); err != nil {
+		return 0, err
+	}
+
+	w := c.newPacketWriter(ptyBulkLoad)
+	b := new(bytes.Buffer)
+	writeBulkColMetaData(b, columnNames, columns)
+
+	var copied int64
+	for err == nil {
+		if err = writeBulkRow(b, columns, row); err != nil {
+			// The bulk load has already started, so we finish it with the rows so far and report the error afterwards.
+			// (Aborting it would require an attention, which we don't support yet.)
+			b.Reset()
+			break
+		}
+		copied++
+
+		// Send what we have so far, so we don't hold the whole batch in memory:
+		if _, err = w.Write(b.Bytes()); err != nil {
+			return copied, err
+		}
+		b.Reset()
+
+		if opts.BatchSize > 0 && copied >= int64(opts.BatchSize) {
+			break
+		}
+		row, err = rows.Next()
+	}
+
+	writeBulkDone(b, copied)
+	if _, writeErr := w.Write(b.Bytes()); writeErr != nil {
+		return copied, writeErr
+	}
+	response, finishErr := w.finish()
+	if finishErr != nil {
+		return copied, finishErr
+	}
+	var sqlerrs []SQLError
+	if _, finishErr = c.processTokens(bytes.Join(*response, nil), &sqlerrs); finishErr != nil {
+		return copied, finishErr
+	}
+	if finishErr = c.checkErrors(sqlerrs); finishErr != nil {
+		return 0, finishErr
+	}
+
+	return copied, err
+}
+
+// makeInsertBulkStatement builds the INSERT BULK statement announcing the columns and options of a bulk load.
+func makeInsertBulkStatement(table string, columnNames []string, columns []columnInfo, opts BulkOptions) string {
+	definitions := make([]string, len(columns))
+	for i, column := range columns {
+		definitions[i] = quoteIdentifier(columnNames[i]) + " " + column.typeName()
+	}
+
+	var with []string
+	if opts.CheckConstraints {
+		with = append(with, "CHECK_CONSTRAINTS")
+	}
+	if opts.FireTriggers {
+		with = append(with, "FIRE_TRIGGERS")
+	}
+	if opts.KeepNulls {
+		with = append(with, "KEEP_NULLS")
+	}
+	if opts.TabLock {
+		with = append(with, "TABLOCK")
+	}
+	if opts.BatchSize > 0 {
+		with = append(with, fmt.Sprintf("ROWS_PER_BATCH = %d", opts.BatchSize))
+	}
+
+	statement := "INSERT BULK " + table + " (" + strings.Join(definitions, ", ") + ")"
+	if len(with) > 0 {
+		statement += " WITH (" + strings.Join(with, ", ") + ")"
+	}
+	return statement
+}
+
+// writeBulkColMetaData writes the COLMETADATA token that starts a bulk load.
+func writeBulkColMetaData(b *bytes.Buffer, columnNames []string, columns []columnInfo) {
+	b.WriteByte(byte(colMetaData))
+	binary.Write(b, binary.LittleEndian, uint16(len(columns)))
+	for i, column := range columns {
+		binary.Write(b, binary.LittleEndian, uint32(0))    // UserType
+		binary.Write(b, binary.LittleEndian, uint16(0x05)) // Flags: nullable (0x01), and read/write (1 in the 2-bit usUpdateable at 0x0c)
+		writeTypeInfo(b, column)
+		writeB_VarChar(b, columnNames[i])
+	}
+}
+
+// writeBulkRow writes a ROW token with the values of a single row.
+func writeBulkRow(b *bytes.Buffer, columns []columnInfo, values []interface{}) error {
+	if len(values) != len(columns) {
+		return fmt.Errorf("Row has %v values instead of %v", len(values), len(columns))
+	}
+
+	b.WriteByte(byte(row))
+	for i, column := range columns {
+		v, err := driver.DefaultParameterConverter.ConvertValue(values[i])
+		if err != nil {
+			return err
+		}
+		if err = writeValue(b, column, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeBulkDone writes the DONE token that ends a bulk load.
+func writeBulkDone(b *bytes.Buffer, rowCount int64) {
+	b.WriteByte(byte(done))
+	binary.Write(b, binary.LittleEndian, uint16(0x10)) // Status: DONE_COUNT
+	binary.Write(b, binary.LittleEndian, uint16(0))    // CurCmd
+	binary.Write(b, binary.LittleEndian, uint64(rowCount))
+}
+
+// nullable returns the nullable counterpart of a fixed-length type, e.g. INTN for int.
+func (info columnInfo) nullable() columnInfo {
+	switch info.columnType {
+	case INT1TYPE, INT2TYPE, INT4TYPE, INT8TYPE:
+		info.columnType = INTNTYPE
+	case BITTYPE:
+		info.columnType = BITNTYPE
+	case FLT4TYPE, FLT8TYPE:
+		info.columnType = FLTNTYPE
+	case MONEY4TYPE, MONEYTYPE:
+		info.columnType = MONEYNTYPE
+	case DATETIM4TYPE, DATETIMETYPE:
+		info.columnType = DATETIMNTYPE
+	}
+	return info
+}
+
+// quoteIdentifier encloses a name in square brackets, escaping any closing brackets it contains.
+func quoteIdentifier(name string) string {
+	return "[" + strings.Replace(name, "]", "]]", -1) + "]"
+}
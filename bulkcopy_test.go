@@ -0,0 +1,105 @@
+package gotds
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/Grovespaz/go-tds/mockserver"
+	utf16c "github.com/Grovespaz/go-tds/utf16"
+)
+
+func TestBulkCopy(t *testing.T) {
+	doneToken := []byte{0xfd, 0x00, 0x00, 0xc1, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}
+	// Response to "SELECT TOP 0 [id], [name] FROM dbo.Users":
+	colMetaData := []byte{0x81, 0x02, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x08, 0x00, 0x38, 0x02, 'i', 0x00, 'd', 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x09, 0x00, 0xe7, 0x0a, 0x00, 0x09, 0x04, 0xd0, 0x00, 0x34, 0x04, 'n', 0x00, 'a', 0x00, 'm', 0x00, 'e', 0x00}
+	mockSrv := mockserver.MakeMockServer([][]byte{
+		makePacket(ptyTableResult, append(colMetaData, doneToken...), 1, true),
+		makePacket(ptyTableResult, doneToken, 1, true),
+		makePacket(ptyTableResult, doneToken, 1, true),
+	}, t)
+	c := &Conn{socket: mockSrv, tdsVersion: TDS73, cfg: Config{PacketSize: 0x1000, Placeholder: '?'}}
+
+	rows := SliceRows{{1, "ab"}, {int64(2), nil}}
+	copied, err := c.BulkCopy("dbo.Users", []string{"id", "name"}, &rows, BulkOptions{TabLock: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if copied != 2 {
+		t.Fatal("Expected 2 rows to be copied, got: ", copied)
+	}
+
+	if len(mockSrv.Written) != 3 {
+		t.Fatal("Expected 3 messages to be sent, got: ", len(mockSrv.Written))
+	}
+	if !bytes.Contains(mockSrv.Written[1], utf16c.Encode("INSERT BULK dbo.Users ([id] int, [name] nvarchar(5)) WITH (TABLOCK)")) {
+		t.Fatal("Did not send expected INSERT BULK statement")
+	}
+
+	bulkLoad := mockSrv.Written[2]
+	if packetType(bulkLoad[0]) != ptyBulkLoad {
+		t.Fatal("Expected a bulk load packet, got type: ", bulkLoad[0])
+	}
+	expected := []byte{0x81, 0x02, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x05, 0x00, 0x26, 0x04, 0x02, 'i', 0x00, 'd', 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x05, 0x00, 0xe7, 0x0a, 0x00, 0x09, 0x04, 0xd0, 0x00, 0x34, 0x04, 'n', 0x00, 'a', 0x00, 'm', 0x00, 'e', 0x00,
+		0xd1, 0x04, 0x01, 0x00, 0x00, 0x00, 0x04, 0x00, 'a', 0x00, 'b', 0x00,
+		0xd1, 0x04, 0x02, 0x00, 0x00, 0x00, 0xff, 0xff,
+		0xfd, 0x10, 0x00, 0x00, 0x00, 0x02, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}
+	if !bytes.Equal(bulkLoad[headerSize:], expected) {
+		t.Fatalf("Did not send expected bulk load, got:\n% x\nexpected:\n% x", bulkLoad[headerSize:], expected)
+	}
+}
+
+func TestBulkRowErrors(t *testing.T) {
+	columns := []columnInfo{{columnType: NVARCHARTYPE, size: 4, collation: make([]byte, 5)}}
+	if err := writeBulkRow(new(bytes.Buffer), columns, []interface{}{"abc"}); err == nil {
+		t.Fatal("Expected an error for a value that's too long")
+	}
+	if err := writeBulkRow(new(bytes.Buffer), columns, []interface{}{"a", "b"}); err == nil {
+		t.Fatal("Expected an error for a row with too many values")
+	}
+}
+
+func TestMakeInsertBulkStatement(t *testing.T) {
+	columns := []columnInfo{
+		{columnType: DECIMALNTYPE, size: 9, precision: 10, scale: 2},
+		{columnType: BIGVARBINTYPE, size: plpSize},
+	}
+	statement := makeInsertBulkStatement("dbo.T", []string{"a]b", "c"}, columns, BulkOptions{CheckConstraints: true, FireTriggers: true, BatchSize: 100})
+	expected := "INSERT BULK dbo.T ([a]]b] decimal(10,2), [c] varbinary(max)) WITH (CHECK_CONSTRAINTS, FIRE_TRIGGERS, ROWS_PER_BATCH = 100)"
+	if statement != expected {
+		t.Fatal("Did not build expected INSERT BULK statement, got: ", statement)
+	}
+}
+
+func TestBulkBit(t *testing.T) {
+	column := columnInfo{columnType: BITNTYPE, size: 1}
+	for _, v := range []interface{}{true, int64(1), int64(2), int64(-1)} {
+		if encoded, err := encodeValue(column, v); err != nil || len(encoded) != 1 || encoded[0] != 1 {
+			t.Fatal("Expected ", v, " to be encoded as true, got: ", encoded, err)
+		}
+	}
+	if encoded, err := encodeValue(column, int64(0)); err != nil || len(encoded) != 1 || encoded[0] != 0 {
+		t.Fatal("Expected 0 to be encoded as false, got: ", encoded, err)
+	}
+}
+
+func TestBulkMoneyRange(t *testing.T) {
+	smallMoney := columnInfo{columnType: MONEYNTYPE, size: 4}
+	if encoded, err := encodeValue(smallMoney, "214748.3647"); err != nil || !bytes.Equal(encoded, []byte{0xff, 0xff, 0xff, 0x7f}) {
+		t.Fatal("Expected the largest smallmoney to be encoded, got: ", encoded, err)
+	}
+	for _, v := range []interface{}{"214748.3648", "-214748.3649", int64(1000000)} {
+		if encoded, err := encodeValue(smallMoney, v); err == nil {
+			t.Fatal("Expected an error for ", v, " as smallmoney, got: ", encoded)
+		}
+	}
+	if _, err := encodeValue(columnInfo{columnType: MONEYNTYPE, size: 8}, int64(1000000)); err != nil {
+		t.Fatal(err)
+	}
+	if encoded, err := encodeValue(columnInfo{columnType: MONEYNTYPE, size: 8}, "922337203685477.5808"); err == nil {
+		t.Fatal("Expected an error for a value that's too large for money, got: ", encoded)
+	}
+}
@@ -0,0 +1,50 @@
+package gotds
+
+// Non-unicode character data (char, varchar and text) is sent in the code page of the column's collation.
+// For now we assume this to be Windows-1252, which is the code page of the default collation SQL_Latin1_General_CP1_CI_AS.
+// The collation is passed along so that other code pages can be supported later on.
+
+// cp1252 maps the bytes 0x80-0x9F of Windows-1252 to their unicode code points, where they differ from Latin-1.
+var cp1252 = [32]rune{
+	'€', '�', '‚', 'ƒ', '„', '…', '†', '‡',
+	'ˆ', '‰', 'Š', '‹', 'Œ', '�', 'Ž', '�',
+	'�', '‘', '’', '“', '”', '•', '–', '—',
+	'˜', '™', 'š', '›', 'œ', '�', 'ž', 'Ÿ',
+}
+
+// decodeCharData decodes non-unicode character data into a string.
+func decodeCharData(b []byte, collation []byte) string {
+	_ = collation // Only Windows-1252 for now
+	result := make([]rune, len(b))
+	for i, c := range b {
+		if c >= 0x80 && c <= 0x9F {
+			result[i] = cp1252[c-0x80]
+		} else {
+			result[i] = rune(c)
+		}
+	}
+	return string(result)
+}
+
+// encodeCharData encodes a string as non-unicode character data, the counterpart of decodeCharData.
+// Characters that cannot be represented are replaced by a question mark, just like SQL Server does.
+func encodeCharData(s string, collation []byte) []byte {
+	_ = collation // Only Windows-1252 for now
+	result := make([]byte, 0, len(s))
+	for _, r := range s {
+		switch {
+		case r < 0x80 || (r >= 0xA0 && r <= 0xFF):
+			result = append(result, byte(r))
+		default:
+			c := byte('?')
+			for i, mapped := range cp1252 {
+				if mapped == r && r != '�' {
+					c = byte(0x80 + i)
+					break
+				}
+			}
+			result = append(result, c)
+		}
+	}
+	return result
+}
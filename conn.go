@@ -2,13 +2,18 @@ package gotds
 
 import (
 	"bytes"
+	"context"
 	"database/sql/driver"
 	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
 	"net"
+	"strconv"
+	"strings"
 	"time"
+
+	"github.com/Grovespaz/go-tds/ssrp"
 )
 
 const (
@@ -70,6 +75,16 @@ const (
 	ptyPreLogin    packetType = 18
 )
 
+// Bits of the status byte in the packet header:
+const (
+	statusNormal = 0x00
+	// End of message, this is the last packet of the message.
+	statusEOM = 0x01
+	// Set by the client to have the server reset the session before processing the request, as if it were a new connection.
+	// This is only allowed in the first packet of a SQL batch, RPC or transaction manager request.
+	statusResetConnection = 0x08
+)
+
 type encryptionType byte
 
 const (
@@ -121,39 +136,115 @@ type Conn struct {
 
 	useOLEDB bool //Since TDS 7.2
 
-	cfg config
+	// How we log in with integrated security, nil for SQL Server authentication.
+	auth integratedAuth
+
+	// Receives the INFO messages sent by the server, see SetMessageHandler.
+	messageHandler MessageHandler
+
+	// Set by Connector when queries are to be retried, see RetryPolicy.
+	retry *RetryPolicy
+
+	// Whether the next request should reset the session, see ResetSession.
+	resetConnection bool
+
+	// Whether a transaction is open, as the server tells us in ENVCHANGE tokens. Queries in one aren't retried, see RetryPolicy.
+	inTransaction bool
+
+	// The failover partner of the server, as reported by the server itself.
+	failoverPartner string
+
+	// Where the server sent us on to during login, if anywhere.
+	routing *routing
+
+	// The state of the session to recover it with when the connection was dropped, nil unless connection resiliency is enabled and supported by the server.
+	recovery *sessionRecovery
+
+	// Logging in with an access token, nil unless Config.accessTokenProvider is set.
+	fedAuth *fedAuth
+
+	cfg Config
 }
 
-type config struct {
-	user          string
-	password      string
-	net           string
-	addr          string
-	dbname        string
-	params        map[string]string
-	timeout       time.Duration
-	verboseLog    bool
-	maxPacketSize uint32
-	appname       string //Optional: name of the application.
-	attachDB      string //Optional: filename of database to attach upon connecting.
-
-	encryption             encryptionType
-	trustServerCertificate bool
+// Config is the configuration of a connection.
+// It can be parsed from a DSN by ParseDSN, or filled in directly and used with NewConnector.
+type Config struct {
+	User     string
+	Password string
+	// The network to connect over, "tcp" by default.
+	Net string
+	// The address of the server as host:port, "127.0.0.1:1433" by default.
+	Addr string
+	// Optional: name of the instance, only used when Addr has no port.
+	// Its port is then looked up through the SQL Server Browser service on the host.
+	// That lookup is a UDP query which doesn't go through Dialer, so behind a tunnel or proxy the port has to be given in Addr instead.
+	Instance string
+	// Optional: the initial database.
+	Database string
+	// Optional: the mirror of the server, in the same format as a data source, e.g. "host\instance" or "host,1433".
+	// It's connected to when the server itself can't be, as after a failover.
+	// Once connected, the partner the server reports takes precedence over this one.
+	FailoverPartner string
+	// Dial all IP addresses of the host at once and use the first connection that is established, instead of one after the other.
+	// This speeds up connecting to an availability group listener with IP addresses in multiple subnets, of which only one is online.
+	MultiSubnetFailover bool
+	// The timeout for establishing the connection, 0 means none.
+	Timeout time.Duration
+	// Optional: dials the connection instead of a net.Dialer, e.g. to connect through a tunnel or proxy.
+	// It's only used for the connection itself: the port of a named Instance is still looked up directly over UDP.
+	// It can't be set through a DSN, only with NewConnector.
+	Dialer Dialer
+	// Log everything sent and received.
+	Verbose bool
+	// The size of the packets we send, 4096 by default.
+	PacketSize uint32
+	// Optional: name of the application.
+	AppName string
+	// Optional: filename of database to attach upon connecting.
+	AttachDBFilename string
+	// Optional: name of the client machine, the hostname by default.
+	WorkstationID string
+	// Optional: the language of the session, which determines e.g. the language of messages.
+	Language string
+
+	TrustServerCertificate bool
 
 	// If set to true, we can't connect if we can't change to the initial DB specified.
 	failIfNoDB bool
 	// Same as above, but with language
 	failIfNoLanguage bool
-	readOnly         bool //Since TDS 7.4, ignored under that
-
-	changePass bool //Since TDS 7.2
-	newPass    string
-
-	userInstance bool //Since TDS 7.2
-
-	preferredLanguage string //?Really?
-
-	integratedSecurity bool
+	// Tell the server we only read, see ApplicationIntent=ReadOnly.
+	// An availability group listener then routes the connection to a readable secondary replica.
+	ReadOnly bool //Since TDS 7.4, ignored under that
+
+	// Optional: change the password of the login to this one while logging in, as is needed once the password has expired or must be changed (ErrPasswordExpired and ErrPasswordMustChange).
+	// Once logged in, it takes the place of Password, so reconnects log in with it. Since TDS 7.2
+	NewPassword string
+
+	UserInstance bool //Since TDS 7.2
+
+	// The number of attempts to reconnect and recover the session when the server dropped an idle connection, 0 (the default) disables connection resiliency.
+	// Session recovery requires TDS 7.4 (SQL Server 2014 and up), with older servers dropped connections are not recovered.
+	// Attempts stop at the first error that isn't transient, such as a failed login, and all of them together take at most Timeout.
+	ConnectRetryCount int
+	// The time to wait in between attempts to reconnect, 10 seconds by default.
+	ConnectRetryInterval time.Duration
+
+	// Log in as a Windows user instead of with SQL Server authentication: with NTLM for a user given as domain\user and its password,
+	// or with Kerberos from a keytab or a credential cache. Without a user, the default credential cache of kinit is used.
+	IntegratedSecurity bool
+	// Optional: the keytab holding the key of the user, to log in with Kerberos.
+	// The user is then given as user@REALM, DOMAIN\user or user in the default realm of krb5.conf.
+	KerberosKeytab string
+	// Optional: the credential cache holding the tickets of the user, to log in with Kerberos.
+	KerberosCCache string
+	// Optional: the SPN of the server for Kerberos, MSSQLSvc/host:port by default.
+	ServerSPN string
+	// Provides the access token to log in with instead of a user and password, e.g. one of Azure AD / Entra ID for a managed identity.
+	// It's called on every login, including the reconnects of session recovery, so it should return a cached token until that's about to expire.
+	// Logging in with an access token requires TDS 7.4 (SQL Server 2016 or Azure SQL), and an encrypted connection.
+	// As TLS isn't implemented yet, this isn't exported: access tokens, and so managed identities, can't be used until it is.
+	accessTokenProvider func(ctx context.Context) (string, error)
 
 	// Type of SQL we are going to send to the server.
 	// 0 = DFLT (I assume default?), 1 = T-SQL
@@ -163,22 +254,113 @@ type config struct {
 	timezone int32  // TODO: Figure out format, best guess: minutes difference between UTC and local time. UTC = local time + timezone
 	lcid     uint32 //Microsoft Locale Identifier. 1033 (0x0409) == US English
 
-	placeholder rune // The placeholder in queries to be replaced with the actual value
+	// The placeholder in queries to be replaced with the actual value, '?' by default.
+	Placeholder rune
+
+	// The name of the server sent in the login, only set when the connection was routed to it.
+	serverName string
+}
+
+// Dialer dials the connection to the server, see Config.Dialer. It is implemented by *net.Dialer.
+type Dialer interface {
+	DialContext(ctx context.Context, network, addr string) (net.Conn, error)
 }
 
 // MakeConnection initiates a TCP connection with the specified configuration.
-func MakeConnection(cfg *config) (*Conn, error) {
-	tcpConn, err := net.DialTimeout(cfg.net, cfg.addr, cfg.timeout)
+func MakeConnection(cfg *Config) (*Conn, error) {
+	return makeConnectionContext(context.Background(), cfg)
+}
+
+// makeConnectionContext initiates a connection, where ctx applies to dialing.
+// If the server can't be connected to and a failover partner is configured, the partner is tried next.
+func makeConnectionContext(ctx context.Context, cfg *Config) (*Conn, error) {
+	conn, err := connectTo(ctx, cfg)
+	if err != nil && cfg.FailoverPartner != "" && ctx.Err() == nil {
+		conn, err = connectToPartner(ctx, cfg, err)
+	}
 	if err != nil {
 		return nil, err
 	}
 
-	return MakeConnectionWithSocket(cfg, tcpConn)
+	if conn.failoverPartner != "" && conn.cfg.FailoverPartner != "" {
+		// The partner the server knows of takes precedence over the configured one
+		conn.cfg.FailoverPartner = conn.failoverPartner
+	}
+	return conn, nil
+}
+
+// connectTo initiates a connection to the server of cfg, where ctx applies to dialing.
+func connectTo(ctx context.Context, cfg *Config) (*Conn, error) {
+	socket, err := dial(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := makeConnectionWithSocket(ctx, cfg, socket)
+	if err != nil {
+		socket.Close()
+		return nil, err
+	}
+	if conn.routing == nil {
+		return conn, nil
+	}
+
+	// The server, e.g. an availability group listener, sent us on to another server, e.g. a readable secondary replica.
+	// This only happens once: a routed connection that's routed again is an error.
+	socket.Close()
+	if cfg.serverName != "" {
+		return nil, fmt.Errorf("Connection to %v was routed more than once, to %v", cfg.serverName, conn.routing.server)
+	}
+	routedCfg := *cfg
+	routedCfg.Addr = net.JoinHostPort(conn.routing.host(), strconv.Itoa(int(conn.routing.port)))
+	routedCfg.Instance = ""
+	routedCfg.MultiSubnetFailover = false
+	routedCfg.serverName = conn.routing.server
+	if cfg.Verbose {
+		errLog.Printf("Routed to %v\n", routedCfg.Addr)
+	}
+	return connectTo(ctx, &routedCfg)
+}
+
+// dial opens the network connection to the server, where ctx applies to dialing.
+func dial(ctx context.Context, cfg *Config) (net.Conn, error) {
+	if cfg.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cfg.Timeout)
+		defer cancel()
+	}
+
+	addr := cfg.Addr
+	if _, _, err := net.SplitHostPort(addr); err != nil && cfg.Instance != "" {
+		// Ask the SQL Server Browser on the host which port the instance listens on:
+		instance, err := ssrp.LookupInstance(ctx, addr, cfg.Instance)
+		if err != nil {
+			return nil, fmt.Errorf("Can't find instance %v on %v: %v", cfg.Instance, addr, err)
+		}
+		if instance.TCPPort == "" {
+			return nil, fmt.Errorf("Instance %v on %v doesn't accept TCP connections", cfg.Instance, addr)
+		}
+		addr = net.JoinHostPort(addr, instance.TCPPort)
+	}
+
+	dialer := cfg.Dialer
+	if dialer == nil {
+		dialer = &net.Dialer{}
+	}
+	if cfg.MultiSubnetFailover {
+		return dialParallel(ctx, dialer, cfg.Net, addr)
+	}
+	return dialer.DialContext(ctx, cfg.Net, addr)
 }
 
 // MakeConnectionWithSocket initiates a connection using the specified ReadWriteCloser as an underlying socket.
 // This allows for the TDS connections to take place over a protocol other than TCP, which the specs allow for.
-func MakeConnectionWithSocket(cfg *config, socket io.ReadWriteCloser) (*Conn, error) {
+func MakeConnectionWithSocket(cfg *Config, socket io.ReadWriteCloser) (*Conn, error) {
+	return makeConnectionWithSocket(context.Background(), cfg, socket)
+}
+
+// makeConnectionWithSocket initiates a connection over socket, where ctx is passed to the access token provider.
+func makeConnectionWithSocket(ctx context.Context, cfg *Config, socket io.ReadWriteCloser) (*Conn, error) {
 	conn := &Conn{socket: socket, State: Initial, cfg: *cfg, tdsVersion: TDS73}
 
 	//This seems reasonable?:
@@ -190,53 +372,122 @@ func MakeConnectionWithSocket(cfg *config, socket io.ReadWriteCloser) (*Conn, er
 	conn.cfg.timezone = 0x000001e0
 	conn.cfg.lcid = 0x00000409
 
-	conn.State = PreLogin
-	conn.SubState = RequestSent
+	if cfg.ReadOnly {
+		// Both the read-only intent and routing require TDS 7.4
+		conn.tdsVersion = TDS74
+	}
+	if cfg.ConnectRetryCount > 0 {
+		// Session recovery is a feature extension of the login, which requires TDS 7.4
+		conn.tdsVersion = TDS74
+		conn.recovery = &sessionRecovery{}
+	}
+	if cfg.accessTokenProvider != nil {
+		if cfg.User != "" || cfg.Password != "" || cfg.IntegratedSecurity || cfg.KerberosKeytab != "" || cfg.KerberosCCache != "" {
+			return nil, errors.New("An access token can't be combined with a user, password or integrated security")
+		}
+		if !tlsSupported {
+			return nil, errAccessTokenUnencrypted
+		}
+		// Federated authentication is a feature extension of the login, which requires TDS 7.4
+		conn.tdsVersion = TDS74
+		conn.fedAuth = &fedAuth{}
+	}
+
+	if err := conn.connect(ctx); err != nil {
+		return nil, err
+	}
+	return conn, nil
+}
+
+// connect performs the pre-login and login over the socket, where ctx is passed to the access token provider.
+func (c *Conn) connect(ctx context.Context) error {
+	c.State = PreLogin
+	c.SubState = RequestSent
 
 	//Send pre-login:
-	response, err := conn.sendPreLogin()
+	response, err := c.sendPreLogin()
 	if err != nil {
-		return nil, err
+		return err
 	}
 
 	//Parse results:
-	conn.SubState = ParsingResponse
-	// Actually parse result here
-	// Right now it's not super-important to parse this, I'd rather get other parts functioning now.
+	c.SubState = ParsingResponse
+	// Right now we only look at what federated authentication needs, I'd rather get other parts functioning now.
 	// Eventually it will be more useful to determine the server version, encryption required, etc.
-	// So lets pretend to do something with it:
-	_ = response
+	options, err := readPreLoginOptions(response)
 	if err != nil {
-		conn.State = Error
-		return nil, err
+		c.State = Error
+		return err
+	}
+	if c.fedAuth != nil {
+		if err = c.fedAuth.start(ctx, c.cfg.accessTokenProvider, options); err != nil {
+			c.State = Error
+			return err
+		}
 	}
 
-	conn.SubState = Ready
+	c.SubState = Ready
 
-	conn.State = Login
-	conn.SubState = RequestSent
+	c.State = Login
+	c.SubState = RequestSent
 	//Send Login packet (eventually: negotiate encryption and stuff)
-	loginResult, err := conn.login()
+	loginResult, err := c.login()
 	if err != nil {
-		conn.State = Error
-		return nil, err
+		c.State = Error
+		return err
 	}
 
 	//Parse results:
-	_ = loginResult
-	conn.SubState = ParsingResponse
-	// (Actually parse result here)
-	if err != nil {
-		conn.State = Error
-		return nil, err
+	c.SubState = ParsingResponse
+	if c.recovery != nil {
+		c.recovery.acknowledged = false
+	}
+	var sqlerrs []SQLError
+	if _, err = c.processTokens(loginResult, &sqlerrs); err != nil {
+		c.State = Error
+		return err
+	}
+	if err = c.checkErrors(sqlerrs); err != nil {
+		// Logging in failed, e.g. because of the password
+		c.State = Error
+		return err
+	}
+	if c.recovery != nil && !c.recovery.acknowledged {
+		// The server doesn't support session recovery
+		c.recovery = nil
+	}
+	if c.fedAuth != nil && !c.fedAuth.acknowledged {
+		c.State = Error
+		return errors.New("The server did not acknowledge the access token")
+	}
+
+	if c.cfg.NewPassword != "" {
+		// The password is changed, log in with it from here on:
+		c.cfg.Password, c.cfg.NewPassword = c.cfg.NewPassword, ""
 	}
 
 	// For now we assume that, if no errors have occured, we're good to go!
-	conn.SubState = Ready
+	c.SubState = Ready
 
-	conn.State = PostLogin
+	c.State = PostLogin
 
-	return conn, nil
+	return nil
+}
+
+// ResetSession implements the driver.SessionResetter interface.
+// It doesn't send anything itself, instead the next request asks the server to reset the session before processing it.
+// This drops temporary tables, rolls back any open transaction and restores the SET options and database of the login.
+func (c *Conn) ResetSession(ctx context.Context) error {
+	if c.State == Error {
+		return driver.ErrBadConn
+	}
+	c.resetConnection = true
+	return nil
+}
+
+// IsValid implements the driver.Validator interface. A connection is no longer valid after a fatal error.
+func (c *Conn) IsValid() bool {
+	return c.State != Error
 }
 
 // Immediately closes the socket.
@@ -247,93 +498,129 @@ func (c *Conn) Close() error {
 
 // sendMessage sends the supplied data to the server, wrapped in the proper headers and packet(s)
 // You probably shouldn't use this directly.
-func (c *Conn) sendMessage(msgType packetType, data []byte) (*[][]byte, *[]SQLError, error) {
+func (c *Conn) sendMessage(msgType packetType, data []byte) (*[][]byte, error) {
+	w := c.newPacketWriter(msgType)
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	return w.finish()
+}
+
+// packetWriter splits a message into packets, sending each packet as soon as it is full.
+// This allows large messages, such as bulk loads, to be sent without holding them in memory as a whole.
+type packetWriter struct {
+	c       *Conn
+	msgType packetType
+	buf     []byte
+}
 
-	maxHeadlessPacketSize := int(c.cfg.maxPacketSize - headerSize)
+func (c *Conn) newPacketWriter(msgType packetType) *packetWriter {
+	return &packetWriter{c: c, msgType: msgType}
+}
 
-	//Split message into packets, send them all,
-	i := 0
+func (w *packetWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
 
-	for (len(data) - (i * maxHeadlessPacketSize)) > maxHeadlessPacketSize {
-		v := (i * maxHeadlessPacketSize)
-		view := data[v : v+maxHeadlessPacketSize]
-		packet := makePacket(msgType, view, c.packetCount, false)
-		c.packetCount++
-		i++
-		if c.cfg.verboseLog {
-			errLog.Printf("Writing: %v", packet)
+	// Only send packets while there's more data than fits in one, so the last packet of the message is never empty.
+	maxHeadlessPacketSize := int(w.c.cfg.PacketSize - headerSize)
+	sent := 0
+	for len(w.buf)-sent > maxHeadlessPacketSize {
+		if err := w.c.writePacket(w.msgType, w.buf[sent:sent+maxHeadlessPacketSize], false); err != nil {
+			return 0, err
 		}
-		(c.socket).Write(packet)
+		sent += maxHeadlessPacketSize
 	}
+	w.buf = append(w.buf[:0], w.buf[sent:]...)
+	return len(p), nil
+}
 
-	v := (i * maxHeadlessPacketSize)
-	view := data[v:]
+// finish sends the last packet of the message and reads the response.
+func (w *packetWriter) finish() (*[][]byte, error) {
+	if err := w.c.writePacket(w.msgType, w.buf, true); err != nil {
+		return nil, err
+	}
+	w.buf = nil
+	return w.c.readMessage()
+}
 
-	//errLog.Printf("Packet count: %X", c.packetCount)
-	packet := makePacket(msgType, view, c.packetCount, true)
+func (c *Conn) writePacket(msgType packetType, data []byte, lastRequest bool) error {
+	packet := makePacket(msgType, data, c.packetCount, lastRequest)
 	c.packetCount++
-	//errLog.Printf("Packet count: %X", c.packetCount)
+	if c.resetConnection && (msgType == ptySQLBatch || msgType == ptyRPC || msgType == ptyTransactionManagerRequest) {
+		packet[1] |= statusResetConnection
+		c.resetConnection = false
+		// Resetting the session rolls back any open transaction
+		c.inTransaction = false
+		if c.recovery != nil {
+			// The session will be as it was right after login
+			c.recovery.reset()
+		}
+	}
 
-	if c.cfg.verboseLog {
+	if c.cfg.Verbose {
 		errLog.Printf("Writing: % X", packet)
 	}
 
-	(c.socket).Write(packet)
-
-	return c.readMessage()
+	_, err := c.socket.Write(packet)
+	return err
 }
 
-func (c *Conn) readMessage() (*[][]byte, *[]SQLError, error) {
+// readMessage reads the packets of the response to a message, returning the data of each.
+// ERROR and INFO tokens are left in the data, to be handled in the order they were sent along with the other tokens (see processTokens).
+func (c *Conn) readMessage() (*[][]byte, error) {
 	//collect all packets sent back.
 	//Send response to caller
 	EOM := false
 	responses := make([][]byte, 0, 5)
-	SQLErrors := make([]SQLError, 0, 5)
 	for !EOM {
-		resultPacket := make([]byte, 1024, 1024)
-		bytesRead, err := c.socket.Read(resultPacket)
-		if err != nil {
+		// A read returns whatever arrived so far, which can be part of a packet or more than one,
+		// so we read the header first and then exactly the length it gives.
+		header := make([]byte, headerSize)
+		if bytesRead, err := io.ReadFull(c.socket, header); err != nil {
 			errLog.Println(err)
-			return nil, nil, err
-		}
-
-		if c.cfg.verboseLog {
-			errLog.Printf("Read %v bytes.\n", bytesRead)
-			errLog.Printf("Result: % x\n", resultPacket[0:bytesRead])
+			if bytesRead > 0 {
+				return nil, c.protocolError(0, bytesRead, err)
+			}
+			return nil, err
 		}
 
-		if resultPacket[0] != byte(ptyTableResult) {
+		if header[0] != byte(ptyTableResult) {
 			//Server always returns type 4 in packet header
-			err = errors.New("Incorrect data, was expecting 0x04.")
+			err := errors.New("Incorrect data, was expecting 0x04.")
 			errLog.Println(err)
-			return nil, nil, err
+			return nil, err
 		}
-		if resultPacket[1] == 1 {
+		if header[1] == 1 {
 			//Byte 1 in the packet header denotes status, 1 is EOM
 			//This means that there are no more responses to be collected and we can give the response back to the caller
 			EOM = true
 		}
-		if resultPacket[1] > 1 {
+		if header[1] > 1 {
 			//This should not happen in server->client communication.
-			return nil, nil, err
+			return nil, c.protocolError(0, 1, ErrInvalidData)
 		}
 
-		switch resultPacket[8] {
-		case 0xAA: //Error, TODO(gv): Do something with these...
-			if c.cfg.verboseLog {
-				errLog.Printf("Received error.\n")
-			}
-			sqlerr := c.makeError(resultPacket[8:bytesRead])
-			SQLErrors = append(SQLErrors, sqlerr)
-		default:
-			if c.cfg.verboseLog {
-				errLog.Printf("Received non-error.\n")
-			}
-			responses = append(responses, resultPacket[8:bytesRead])
+		//Bytes 2 and 3 are the length of the packet, including the header
+		length := int(binary.BigEndian.Uint16(header[2:4]))
+		if length < headerSize {
+			return nil, c.protocolError(0, 2, ErrInvalidData)
+		}
+		data := make([]byte, length-headerSize)
+		if bytesRead, err := io.ReadFull(c.socket, data); err != nil {
+			return nil, c.protocolError(0, headerSize+bytesRead, err)
+		}
+
+		if c.cfg.Verbose {
+			errLog.Printf("Read %v bytes.\n", length)
+			errLog.Printf("Result: % x % x\n", header, data)
+		}
+
+		if len(data) > 0 {
+			responses = append(responses, data)
 		}
 	}
 
-	return &responses, &SQLErrors, nil
+	return &responses, nil
 }
 
 /*
@@ -356,9 +643,9 @@ func makePacket(pktType packetType, data []byte, packetID byte, lastRequest bool
 	result[0] = byte(pktType)
 
 	if lastRequest {
-		result[1] = 1
+		result[1] = statusEOM
 	} else {
-		result[1] = 0
+		result[1] = statusNormal
 	}
 
 	length := uint16(8 + len(data))
@@ -442,7 +729,10 @@ func parseTokenStream(data []byte) ([]token, error) {
 			case 0xc:
 				byteCount = 8
 			}
-			tokenData := buf.Next(byteCount)
+			tokenData, err := readBytes(buf, byteCount)
+			if err != nil {
+				return nil, ProtocolError{Offset: len(data) - buf.Len(), Token: nextToken, Err: io.ErrUnexpectedEOF}
+			}
 			newToken.data = tokenData
 			newToken.length = byteCount
 			break
@@ -453,15 +743,17 @@ func parseTokenStream(data []byte) ([]token, error) {
 			err := binary.Read(buf, binary.BigEndian, &length)
 			if err != nil {
 				errLog.Println("binary.Read failed:", err)
-				return nil, err
+				return nil, ProtocolError{Offset: len(data) - buf.Len(), Token: nextToken, Err: io.ErrUnexpectedEOF}
 			}
 			newToken.length = int(length)
-			newToken.data = buf.Next(newToken.length)
+			if newToken.data, err = readBytes(buf, newToken.length); err != nil {
+				return nil, ProtocolError{Offset: len(data) - buf.Len(), Token: nextToken, Err: io.ErrUnexpectedEOF}
+			}
 			break
 		case variableCount:
 			//newToken.definition = variableCount
-			panic("I haven't coded this part yet!")
-			break
+			// I haven't coded this part yet!
+			return nil, ProtocolError{Offset: len(data) - buf.Len() - 1, Token: nextToken, Err: ErrInvalidData}
 		default:
 			err = errors.New(fmt.Sprintf("Unknown Token-length Definition: %v", tokenLengthDefinition(nextToken&0x30)))
 			errLog.Println(err)
@@ -542,3 +834,201 @@ func makeTokenStream(tokens []token) ([]byte, error) {
 
 	return buf.Bytes(), nil
 }
+
+// Types of ENVCHANGE tokens, of which only those we act upon are listed:
+const (
+	envDatabase   = 1
+	envLanguage   = 2
+	envPacketSize = 4
+	envCollation  = 7
+	// The server began, committed or rolled back a transaction
+	envBeginTransaction    = 8
+	envCommitTransaction   = 9
+	envRollbackTransaction = 10
+	// A transaction that was enlisted in a distributed one ended
+	envTransactionEnded = 17
+	// The failover partner of a mirrored database
+	envDatabaseMirroringPartner = 13
+	// The server to connect to instead, only sent in the response to a login
+	envRouting = 20
+)
+
+// routing is where the server sends us on to in the routing ENVCHANGE.
+type routing struct {
+	// Only TCP is supported
+	protocol byte
+	port     uint16
+	// The name of the server, which may include an instance name as in host\instance.
+	server string
+}
+
+// host returns the host of the server to connect to.
+func (r *routing) host() string {
+	if i := strings.IndexByte(r.server, '\\'); i >= 0 {
+		return r.server[:i]
+	}
+	return r.server
+}
+
+// processTokens handles the tokens at the start of data which change the state of the connection or session, such as ENVCHANGE and SESSIONSTATE.
+// INFO tokens are passed on to the message handler, ERROR tokens are added to sqlerrs, DONE and RETURNSTATUS tokens are skipped.
+// It stops at the first token that is none of these, e.g. COLMETADATA, and returns the data from there on.
+func (c *Conn) processTokens(data []byte, sqlerrs *[]SQLError) ([]byte, error) {
+	buf := bytes.NewBuffer(data)
+	for buf.Len() > 0 {
+		start := len(data) - buf.Len()
+		token := tokenDefinition(buf.Bytes()[0])
+		fail := func(err error) ([]byte, error) {
+			return nil, c.protocolError(token, start, err)
+		}
+
+		var length int
+		switch token {
+		case done, doneProc, doneInProc:
+			// Status, CurCmd and DoneRowCount, which is a ULONGLONG since TDS 7.2
+			length = 8
+			if c.tdsVersion >= TDS72 {
+				length = 12
+			}
+			buf.Next(1)
+			if _, err := readBytes(buf, length); err != nil {
+				return fail(err)
+			}
+			continue
+		case featureExtAck:
+			buf.Next(1)
+			if err := c.readFeatureExtAck(buf); err != nil {
+				return fail(err)
+			}
+			continue
+		case returnStatus:
+			// The return value of a stored procedure, a LONG
+			buf.Next(1)
+			if _, err := readBytes(buf, 4); err != nil {
+				return fail(err)
+			}
+			continue
+		case sessionState:
+			buf.Next(1)
+			var stateLength uint32
+			if err := binary.Read(buf, binary.LittleEndian, &stateLength); err != nil {
+				return fail(err)
+			}
+			tokenData, err := readBytes(buf, int(stateLength))
+			if err != nil {
+				return fail(err)
+			}
+			if c.recovery != nil {
+				if err = c.recovery.readSessionState(tokenData); err != nil {
+					return fail(err)
+				}
+			}
+			continue
+		case envChange, info, errorToken, loginAck:
+			if buf.Len() < 3 {
+				return fail(io.ErrUnexpectedEOF)
+			}
+			length = 3 + int(binary.LittleEndian.Uint16(buf.Bytes()[1:3]))
+		default:
+			return buf.Bytes(), nil
+		}
+
+		tokenData, err := readBytes(buf, length)
+		if err != nil {
+			return fail(err)
+		}
+		switch token {
+		case envChange:
+			err = c.readEnvChange(tokenData[3:])
+		case info:
+			var msg SQLError
+			if msg, err = c.makeError(tokenData); err == nil {
+				c.handleMessage(msg)
+			}
+		case errorToken:
+			var sqlerr SQLError
+			if sqlerr, err = c.makeError(tokenData); err == nil {
+				*sqlerrs = append(*sqlerrs, sqlerr)
+			}
+		case loginAck:
+			err = c.readLoginAck(tokenData[3:])
+		}
+		if err != nil {
+			return fail(err)
+		}
+	}
+	return nil, nil
+}
+
+// readEnvChange handles the data of an ENVCHANGE token.
+func (c *Conn) readEnvChange(data []byte) error {
+	buf := bytes.NewBuffer(data)
+	envType, err := buf.ReadByte()
+	if err != nil {
+		return err
+	}
+	switch envType {
+	case envDatabase, envLanguage:
+		value, err := readB_VarChar(buf)
+		if err != nil {
+			return err
+		}
+		if c.recovery != nil {
+			c.recovery.setEnv(envType, value, nil, c.State == Login)
+		}
+	case envRouting:
+		// The new value is prefixed with its length as an USHORT, which we don't need.
+		if _, err = readBytes(buf, 2); err != nil {
+			return err
+		}
+		r := new(routing)
+		if r.protocol, err = buf.ReadByte(); err != nil {
+			return err
+		}
+		if err = binary.Read(buf, binary.LittleEndian, &r.port); err != nil {
+			return err
+		}
+		if r.server, err = readUS_VarChar(buf); err != nil {
+			return err
+		}
+		if r.protocol != 0 {
+			return fmt.Errorf("Routed to %v over unsupported protocol %v", r.server, r.protocol)
+		}
+		c.routing = r
+	case envBeginTransaction:
+		c.inTransaction = true
+	case envCommitTransaction, envRollbackTransaction, envTransactionEnded:
+		c.inTransaction = false
+	case envDatabaseMirroringPartner:
+		if c.failoverPartner, err = readB_VarChar(buf); err != nil {
+			return err
+		}
+	case envCollation:
+		length, err := buf.ReadByte()
+		if err != nil {
+			return err
+		}
+		collation, err := readBytes(buf, int(length))
+		if err != nil {
+			return err
+		}
+		if c.recovery != nil {
+			c.recovery.setEnv(envType, "", append([]byte(nil), collation...), c.State == Login)
+		}
+	}
+	return nil
+}
+
+// readLoginAck handles the data of a LOGINACK token, which tells which TDS version the server speaks.
+func (c *Conn) readLoginAck(data []byte) error {
+	if len(data) < 5 {
+		return io.ErrUnexpectedEOF
+	}
+	// The server sends the version in reversed byte order, see TDS74 etc.
+	version := binary.LittleEndian.Uint32(data[1:5])
+	switch version {
+	case TDS71, TDS72, TDS73, TDS73a, TDS74:
+		c.tdsVersion = version
+	}
+	return nil
+}
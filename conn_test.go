@@ -3,10 +3,12 @@ package gotds
 import (
 	"bytes"
 	_ "encoding/binary"
-	//"github.com/grovespaz/go-tds/mockserver"
+	"errors"
+	"io"
 	"reflect"
 	"testing"
 
+	"github.com/Grovespaz/go-tds/mockserver"
 	utf16c "github.com/Grovespaz/go-tds/utf16"
 	utf16 "unicode/utf16"
 
@@ -16,7 +18,7 @@ import (
 /*
 func TestMockPreLogin(t *testing.T) {
 	mockSrv := mockserver.MakeMockServer([][]byte{[]byte{4, 1, 0, 32, 0, 0, 1, 0, 0, 0, 16, 0, 6, 1, 0, 22, 0, 1, 4, 0, 23, 0, 1, 255, 10, 50, 9, 196}}, t)
-	config, _ := parseDSN("/")
+	config, _ := ParseDSN("/")
 
 	c, err := MakeConnectionWithSocket(config, mockSrv)
 	if err != nil {
@@ -328,3 +330,34 @@ func TestPasswordEncode(t *testing.T) {
 		t.Fatalf("encoded and expected don't match, % x vs. % x", original, encoded)
 	}
 }
+
+func TestReadMessage(t *testing.T) {
+	first := bytes.Repeat([]byte{0xab}, 3000)
+	last := bytes.Repeat([]byte{0xcd}, 100)
+	stream := append(makePacket(ptyTableResult, first, 1, false), makePacket(ptyTableResult, last, 2, true)...)
+	// Reads return the packets in pieces that don't line up with them, as TCP does:
+	mockSrv := mockserver.MakeMockServer([][]byte{stream[:5], stream[5:1500], stream[1500:3010], stream[3010:]}, t)
+	c := &Conn{socket: mockSrv}
+	data, err := c.readMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(*data) != 2 || !bytes.Equal((*data)[0], first) || !bytes.Equal((*data)[1], last) {
+		t.Fatal("Did not read the expected packets, got: ", len(*data))
+	}
+
+	// A packet that is cut off:
+	mockSrv = mockserver.MakeMockServer([][]byte{stream[:1500]}, t)
+	c = &Conn{socket: mockSrv}
+	var protocolErr ProtocolError
+	if _, err := c.readMessage(); !errors.As(err, &protocolErr) || protocolErr.Err != io.ErrUnexpectedEOF {
+		t.Fatal("Expected a protocol error for a packet that is cut off, got: ", err)
+	}
+}
+
+func FuzzParseTokenStream(f *testing.F) {
+	f.Add([]byte{0x30, 0x01, 0x34, 0x01, 0x02, 0x20, 0x00, 0x01, 0xff})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		parseTokenStream(data)
+	})
+}
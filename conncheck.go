@@ -0,0 +1,42 @@
+//go:build linux || darwin || dragonfly || freebsd || netbsd || openbsd || solaris || illumos
+
+package gotds
+
+import (
+	"io"
+	"syscall"
+)
+
+// isDropped reports whether the server closed the connection while it was idle, without blocking.
+// Data waiting to be read counts as well, as it means we're out of sync with the server.
+// Sockets other than network connections are never considered dropped.
+func isDropped(socket io.ReadWriteCloser) bool {
+	sc, ok := socket.(syscall.Conn)
+	if !ok {
+		return false
+	}
+	rawConn, err := sc.SyscallConn()
+	if err != nil {
+		return false
+	}
+
+	dropped := false
+	err = rawConn.Read(func(fd uintptr) bool {
+		var buf [1]byte
+		n, err := syscall.Read(int(fd), buf[:])
+		switch {
+		case n == 0 && err == nil:
+			// EOF
+			dropped = true
+		case n > 0:
+			dropped = true
+		case err == syscall.EAGAIN || err == syscall.EWOULDBLOCK:
+			// Nothing to read, as it should be
+		default:
+			dropped = true
+		}
+		// Never wait for the socket to become readable
+		return true
+	})
+	return err != nil || dropped
+}
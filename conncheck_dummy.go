@@ -0,0 +1,10 @@
+//go:build !(linux || darwin || dragonfly || freebsd || netbsd || openbsd || solaris || illumos)
+
+package gotds
+
+import "io"
+
+// isDropped can't check the connection on this platform, so dropped connections are never recovered.
+func isDropped(socket io.ReadWriteCloser) bool {
+	return false
+}
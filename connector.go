@@ -0,0 +1,102 @@
+package gotds
+
+import (
+	"context"
+	"database/sql/driver"
+	"sync"
+)
+
+// Connector implements driver.Connector, which lets a pool be opened without building a DSN, and with options that don't fit in one:
+//
+//	connector, err := gotds.NewConnector(&gotds.Config{Addr: "db.example.com:1433", User: "app", Password: password})
+//	if err != nil {
+//		return err
+//	}
+//	connector.RetryPolicy = &gotds.RetryPolicy{MaxRetries: 3, RetryQueries: true}
+//	db := sql.OpenDB(connector)
+type Connector struct {
+	// Guards cfg, of which the server and failover partner are updated after a failover.
+	mu  sync.Mutex
+	cfg Config
+	// Held while a connection changes the password, so that the others wait for it and log in with the new one instead of changing it again.
+	passwordMu sync.Mutex
+
+	// If set, connecting and (optionally) queries are retried when they fail with a transient error.
+	RetryPolicy *RetryPolicy
+}
+
+// NewConnector returns a Connector for the given configuration, to be used with sql.OpenDB.
+// Defaults are filled in for the options that aren't set, the configuration itself is left untouched.
+func NewConnector(cfg *Config) (*Connector, error) {
+	c := &Connector{cfg: *cfg}
+	if err := c.cfg.setDefaults(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// OpenConnector implements the driver.DriverContext interface.
+func (d *Driver) OpenConnector(dsn string) (driver.Connector, error) {
+	cfg, err := ParseDSN(dsn)
+	if err != nil {
+		return nil, err
+	}
+	return NewConnector(cfg)
+}
+
+// Connect implements the driver.Connector interface.
+func (c *Connector) Connect(ctx context.Context) (driver.Conn, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	cfg := c.cfg
+	c.mu.Unlock()
+	if cfg.NewPassword != "" {
+		c.passwordMu.Lock()
+		// Another connection may have changed the password while we waited.
+		c.mu.Lock()
+		cfg = c.cfg
+		c.mu.Unlock()
+		if cfg.NewPassword != "" {
+			defer c.passwordMu.Unlock()
+		} else {
+			c.passwordMu.Unlock()
+		}
+	}
+
+	var conn *Conn
+	var err error
+	if c.RetryPolicy == nil {
+		conn, err = makeConnectionContext(ctx, &cfg)
+	} else {
+		err = c.RetryPolicy.do(ctx, func() error {
+			var connErr error
+			conn, connErr = makeConnectionContext(ctx, &cfg)
+			return connErr
+		}, IsTransient)
+	}
+	if err != nil {
+		return nil, err
+	}
+	conn.retry = c.RetryPolicy
+
+	if cfg.NewPassword != "" {
+		// The password is changed, so the next connection can't change it again.
+		c.mu.Lock()
+		c.cfg.Password, c.cfg.NewPassword = conn.cfg.Password, ""
+		c.mu.Unlock()
+	}
+	if cfg.FailoverPartner != "" {
+		// Connect to whichever server is the principal now first from here on, and to the partner it knows of next.
+		c.mu.Lock()
+		c.cfg.Addr, c.cfg.Instance, c.cfg.FailoverPartner = conn.cfg.Addr, conn.cfg.Instance, conn.cfg.FailoverPartner
+		c.mu.Unlock()
+	}
+	return conn, nil
+}
+
+// Driver implements the driver.Connector interface.
+func (c *Connector) Driver() driver.Driver {
+	return &Driver{}
+}
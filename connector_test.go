@@ -0,0 +1,85 @@
+package gotds
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/Grovespaz/go-tds/mockserver"
+)
+
+func TestNewConnector(t *testing.T) {
+	cfg := &Config{Addr: "host", User: "app"}
+	connector, err := NewConnector(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if connector.cfg.Addr != "host:1433" || connector.cfg.PacketSize != 0x1000 || cfg.PacketSize != 0 {
+		t.Fatal("Expected the connector to fill in defaults on its own copy of the config, got: ", connector.cfg, cfg)
+	}
+
+	if _, err = NewConnector(&Config{Addr: `host\a`, Instance: "b"}); err == nil {
+		t.Fatal("Expected an error for conflicting instance names")
+	}
+}
+
+type testDialer struct {
+	network, addr string
+	deadline      bool
+}
+
+func (d *testDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	d.network, d.addr = network, addr
+	_, d.deadline = ctx.Deadline()
+	return nil, errors.New("dial refused by test")
+}
+
+func TestConnectorDialer(t *testing.T) {
+	dialer := &testDialer{}
+	connector, err := NewConnector(&Config{Addr: "db:1444", Timeout: time.Minute, Dialer: dialer})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = connector.Connect(context.Background()); err == nil || err.Error() != "dial refused by test" {
+		t.Fatal("Expected the error of the dialer, got: ", err)
+	}
+	if dialer.network != "tcp" || dialer.addr != "db:1444" || !dialer.deadline {
+		t.Fatal("Expected the dialer to be called with the address and a deadline, got: ", dialer)
+	}
+}
+
+func TestResetSession(t *testing.T) {
+	doneToken := []byte{0xfd, 0x00, 0x00, 0xc1, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}
+	mockSrv := mockserver.MakeMockServer([][]byte{
+		makePacket(ptyTableResult, doneToken, 1, true),
+		makePacket(ptyTableResult, doneToken, 1, true),
+		makePacket(ptyTableResult, makeErrorToken(0, 20, "A severe error occurred on the current command."), 1, true),
+	}, t)
+	c := &Conn{socket: mockSrv, tdsVersion: TDS73, cfg: Config{PacketSize: 0x1000, Placeholder: '?'}}
+
+	if err := c.ResetSession(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := c.Exec("SELECT 1", nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if mockSrv.Written[0][1] != statusEOM|statusResetConnection {
+		t.Fatal("Expected the first request to reset the connection, got status: ", mockSrv.Written[0][1])
+	}
+	if mockSrv.Written[1][1] != statusEOM {
+		t.Fatal("Expected only the first request to reset the connection, got status: ", mockSrv.Written[1][1])
+	}
+
+	if !c.IsValid() {
+		t.Fatal("Expected the connection to be valid")
+	}
+	c.Exec("SELECT 1", nil)
+	if c.IsValid() || c.ResetSession(context.Background()) != driver.ErrBadConn {
+		t.Fatal("Expected the connection to be invalid after a fatal error")
+	}
+}
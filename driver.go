@@ -14,7 +14,7 @@ type Driver struct {
 }
 
 func (driver *Driver) Open(dsn string) (driver.Conn, error) {
-	config, err := parseDSN(dsn)
+	config, err := ParseDSN(dsn)
 	if err != nil {
 		return nil, err
 	}
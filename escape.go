@@ -48,7 +48,7 @@ func escapeParameters(query string, args []driver.Value, placeholder rune) (stri
 	}
 	q := make([]string, 2*len(args)+1)
 	n := 0
-	for _, a := range args {
+	for argIndex, a := range args {
 		i := strings.IndexRune(query, placeholder)
 		if i == -1 {
 			return "", errors.New("number of parameters doesn't match number of placeholders")
@@ -59,6 +59,11 @@ func escapeParameters(query string, args []driver.Value, placeholder rune) (stri
 			s = "NULL"
 		case string:
 			s = "'" + escapeString(v) + "'"
+		case XML:
+			s = xmlLiteral(v)
+		case TVP:
+			// Sent as a separate RPC parameter, see makeRPCPacket
+			s = rpcParamName(argIndex)
 		case []byte:
 			// TODO(gv): Find out if this works:
 			s = "'" + escapeString(string(v)) + "'"
@@ -0,0 +1,79 @@
+package gotds
+
+import (
+	"context"
+	"errors"
+	"net"
+)
+
+// lookupHost resolves the IP addresses of a host for MultiSubnetFailover, it's a variable so tests can replace it.
+var lookupHost = net.DefaultResolver.LookupHost
+
+// connectToPartner connects to the failover partner of cfg, after connecting to the server itself failed with err.
+// The configuration of the connection then has the server as its failover partner, so a failover back to it works as well.
+func connectToPartner(ctx context.Context, cfg *Config, err error) (*Conn, error) {
+	partnerCfg := *cfg
+	partnerCfg.Addr = cfg.FailoverPartner
+	partnerCfg.Instance = ""
+	partnerCfg.FailoverPartner = cfg.dataSource()
+	if partnerErr := partnerCfg.setDefaults(); partnerErr != nil {
+		return nil, errors.Join(err, partnerErr)
+	}
+
+	errLog.Printf("Connecting to %v failed, trying failover partner %v: %v\n", cfg.dataSource(), cfg.FailoverPartner, err)
+	conn, partnerErr := connectTo(ctx, &partnerCfg)
+	if partnerErr != nil {
+		return nil, errors.Join(err, partnerErr)
+	}
+	return conn, nil
+}
+
+// dialParallel dials all IP addresses the host of addr resolves to at once, and returns the first connection that is established.
+// The other attempts are then canceled, or closed if they already succeeded.
+func dialParallel(ctx context.Context, dialer Dialer, network, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	ips, err := lookupHost(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	if len(ips) == 0 {
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		conn net.Conn
+		err  error
+	}
+	results := make(chan result, len(ips))
+	for _, ip := range ips {
+		go func(ip string) {
+			conn, err := dialer.DialContext(ctx, network, net.JoinHostPort(ip, port))
+			results <- result{conn, err}
+		}(ip)
+	}
+
+	var errs []error
+	for pending := len(ips); pending > 0; pending-- {
+		r := <-results
+		if r.err != nil {
+			errs = append(errs, r.err)
+			continue
+		}
+		// Close any connections of the other attempts that finish after all:
+		go func(pending int) {
+			for ; pending > 0; pending-- {
+				if r := <-results; r.conn != nil {
+					r.conn.Close()
+				}
+			}
+		}(pending - 1)
+		return r.conn, nil
+	}
+	return nil, errors.Join(errs...)
+}
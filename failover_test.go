@@ -0,0 +1,179 @@
+package gotds
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	utf16c "github.com/Grovespaz/go-tds/utf16"
+)
+
+// refusedAddr returns a local address on which connections are refused.
+func refusedAddr(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+	return addr
+}
+
+// loginAs handles the pre-login and login of a client, and reports the given failover partner.
+func loginAs(partner string) func(s *testServerConn) {
+	return func(s *testServerConn) {
+		s.preLogin()
+		s.read(ptyLogin)
+		s.write(makeLoginAckToken(), makeEnvChangeToken(envDatabaseMirroringPartner, partner, ""), testDoneToken)
+	}
+}
+
+func TestFailoverPartner(t *testing.T) {
+	primary := refusedAddr(t)
+	partner := serveTDS(t, loginAs("mirror\\SQL2012"))
+
+	connector, err := NewConnector(&Config{Addr: primary, FailoverPartner: partner})
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := connector.Connect(context.Background())
+	if err != nil {
+		t.Fatal("Expected to connect to the failover partner, got: ", err)
+	}
+	defer conn.Close()
+	if addr := conn.(*Conn).cfg.Addr; addr != partner {
+		t.Fatal("Expected to be connected to the failover partner, got: ", addr)
+	}
+
+	// Next time the partner is tried first, and the partner it reported after that:
+	if connector.cfg.Addr != partner || connector.cfg.FailoverPartner != "mirror\\SQL2012" {
+		t.Fatal("Expected the connector to fail over to the partner, got: ", connector.cfg.Addr, connector.cfg.FailoverPartner)
+	}
+
+	if _, err = MakeConnection(&Config{Net: "tcp", Addr: primary, PacketSize: 0x1000}); err == nil {
+		t.Fatal("Expected an error without a failover partner")
+	}
+}
+
+func TestParseFailoverPartner(t *testing.T) {
+	cfg, err := ParseDSN("Server=db1;Failover Partner=db2\\Mirror;MultiSubnetFailover=true")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.FailoverPartner != "db2\\Mirror" || !cfg.MultiSubnetFailover {
+		t.Fatal("Did not parse expected values, got: ", cfg.FailoverPartner, cfg.MultiSubnetFailover)
+	}
+}
+
+// subnetDialer never connects to unreachable, it waits until the dial is canceled instead. Other addresses are dialed as usual.
+type subnetDialer struct {
+	unreachable string
+	canceled    chan bool
+}
+
+func (d *subnetDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	if host, _, _ := net.SplitHostPort(addr); host == d.unreachable {
+		<-ctx.Done()
+		d.canceled <- true
+		return nil, ctx.Err()
+	}
+	return (&net.Dialer{}).DialContext(ctx, network, addr)
+}
+
+func TestMultiSubnetFailover(t *testing.T) {
+	addr := serveTDS(t, loginAs(""))
+	_, port, _ := net.SplitHostPort(addr)
+
+	defer func(original func(ctx context.Context, host string) ([]string, error)) {
+		lookupHost = original
+	}(lookupHost)
+	lookupHost = func(ctx context.Context, host string) ([]string, error) {
+		if host != "listener.example.com" {
+			t.Error("Expected the host to be resolved, got: ", host)
+		}
+		// The first subnet is offline
+		return []string{"192.0.2.1", "127.0.0.1"}, nil
+	}
+
+	dialer := &subnetDialer{unreachable: "192.0.2.1", canceled: make(chan bool, 1)}
+	conn, err := MakeConnection(&Config{Net: "tcp", Addr: "listener.example.com:" + port, PacketSize: 0x1000, Dialer: dialer, MultiSubnetFailover: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	select {
+	case <-dialer.canceled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the dial to the offline subnet to be canceled")
+	}
+}
+
+func makeRoutingToken(server string, port uint16) []byte {
+	value := new(bytes.Buffer)
+	value.WriteByte(0) // TCP
+	binary.Write(value, binary.LittleEndian, port)
+	writeUS_VarChar(value, server)
+
+	b := new(bytes.Buffer)
+	b.WriteByte(envRouting)
+	binary.Write(b, binary.LittleEndian, uint16(value.Len()))
+	b.Write(value.Bytes())
+	b.Write([]byte{0, 0}) // No old value
+	return append([]byte{byte(envChange), byte(b.Len()), byte(b.Len() >> 8)}, b.Bytes()...)
+}
+
+// loginField returns the i-th of the variable length strings in a LOGIN7 message, e.g. 4 for the server name.
+func loginField(login []byte, i int) string {
+	offset := binary.LittleEndian.Uint16(login[36+4*i:])
+	length := binary.LittleEndian.Uint16(login[38+4*i:])
+	return utf16c.Decode(login[offset : offset+2*length])
+}
+
+// routeTo handles the pre-login and login of a client, and routes it to addr.
+func routeTo(addr string) func(s *testServerConn) {
+	return func(s *testServerConn) {
+		s.preLogin()
+		login := s.read(ptyLogin)
+		if login[26]&0x20 == 0 || !bytes.Equal(login[4:8], []byte{0x04, 0x00, 0x00, 0x74}) {
+			s.t.Error("Expected a TDS 7.4 login with read-only intent, got: ", login[4:8], login[26])
+		}
+		_, port, _ := net.SplitHostPort(addr)
+		p, _ := strconv.Atoi(port)
+		s.write(makeLoginAckToken(), makeRoutingToken("127.0.0.1\\Replica", uint16(p)), testDoneToken)
+	}
+}
+
+func TestReadOnlyRouting(t *testing.T) {
+	var serverName string
+	secondary := serveTDS(t, func(s *testServerConn) {
+		s.preLogin()
+		serverName = loginField(s.read(ptyLogin), 4)
+		s.write(makeLoginAckToken(), testDoneToken)
+	})
+	listener := serveTDS(t, routeTo(secondary))
+
+	cfg, err := ParseDSN("Server=" + listener + ";ApplicationIntent=ReadOnly")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := MakeConnection(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if conn.cfg.Addr != secondary || serverName != "127.0.0.1\\Replica" {
+		t.Fatal("Expected to be routed to the secondary, got: ", conn.cfg.Addr, serverName)
+	}
+
+	// Routing to yet another server is not allowed:
+	listener = serveTDS(t, routeTo(serveTDS(t, routeTo(secondary))))
+	cfg.Addr = listener
+	if _, err = MakeConnection(cfg); err == nil {
+		t.Fatal("Expected an error for a connection that is routed twice")
+	}
+}
@@ -0,0 +1,70 @@
+package gotds
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	utf16c "github.com/Grovespaz/go-tds/utf16"
+)
+
+// The library with which the client obtained the token of the FEDAUTH feature extension: we're handed the token, so for us it's a security token.
+const fedAuthLibrarySecurityToken = 0x01
+
+// The length of the nonce a server may send in its pre-login response.
+const fedAuthNonceSize = 32
+
+// Whether connections can be encrypted with TLS, which isn't implemented yet.
+// An access token is a bearer token: anyone who reads it on the way can log in with it, so we only send it once TLS is negotiated.
+const tlsSupported = false
+
+var errAccessTokenUnencrypted = errors.New("An access token can only be sent over an encrypted connection, which is not supported yet")
+
+// fedAuth is logging in with an access token, see Config.accessTokenProvider.
+type fedAuth struct {
+	// Whether the server asked for federated authentication in its pre-login response, which the login echoes.
+	echo bool
+	// The nonce the server sent in its pre-login response, if any, which the login returns.
+	nonce []byte
+	token string
+	// Whether the server acknowledged the feature extension in its login response.
+	acknowledged bool
+}
+
+// start gets a new token for the login, and reads what the server sent in its pre-login response.
+func (f *fedAuth) start(ctx context.Context, provider func(ctx context.Context) (string, error), options map[pl_option_token][]byte) error {
+	required := options[FEDAUTHREQUIRED]
+	f.echo = len(required) == 1 && required[0] == 0x01
+	f.nonce = options[NONCEOPT]
+	if f.nonce != nil && len(f.nonce) != fedAuthNonceSize {
+		return errors.New("Invalid nonce in the preLogin response")
+	}
+	f.acknowledged = false
+
+	token, err := provider(ctx)
+	if err != nil {
+		return fmt.Errorf("Could not get an access token: %w", err)
+	}
+	if token == "" {
+		return errors.New("The access token provider returned an empty token")
+	}
+	f.token = token
+	return nil
+}
+
+// featureData returns the data of the FEDAUTH feature extension: the options, followed by the token in UTF-16 and the nonce.
+func (f *fedAuth) featureData() []byte {
+	b := new(bytes.Buffer)
+	options := byte(fedAuthLibrarySecurityToken << 1)
+	if f.echo {
+		options |= 0x01
+	}
+	b.WriteByte(options)
+	token := utf16c.Encode(f.token)
+	binary.Write(b, binary.LittleEndian, uint32(len(token)))
+	b.Write(token)
+	b.Write(f.nonce)
+	return b.Bytes()
+}
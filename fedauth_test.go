@@ -0,0 +1,113 @@
+package gotds
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"testing"
+
+	"github.com/Grovespaz/go-tds/mockserver"
+	utf16c "github.com/Grovespaz/go-tds/utf16"
+)
+
+var testNonce = bytes.Repeat([]byte{0x5a}, fedAuthNonceSize)
+
+// fedAuthPreLoginOptions returns the options of the pre-login response of a server that requires federated authentication with a nonce.
+func fedAuthPreLoginOptions(t *testing.T, nonce []byte) map[pl_option_token][]byte {
+	options, err := readPreLoginOptions(preLoginOptions{
+		preLoginOption{option: VERSION, data: []byte{0x0d, 0x0, 0x0, 0x0, 0x0, 0x0}},
+		preLoginOption{option: ENCRYPTION, data: []byte{byte(encryptRequired)}},
+		preLoginOption{option: FEDAUTHREQUIRED, data: []byte{0x01}},
+		preLoginOption{option: NONCEOPT, data: nonce},
+	}.marshal())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return options
+}
+
+func makeFedAuthData(token string) []byte {
+	encoded := utf16c.Encode(token)
+	b := new(bytes.Buffer)
+	b.WriteByte(fedAuthLibrarySecurityToken<<1 | 0x01) // Echoing that the server requires it
+	binary.Write(b, binary.LittleEndian, uint32(len(encoded)))
+	b.Write(encoded)
+	b.Write(testNonce)
+	return b.Bytes()
+}
+
+func TestAccessTokenUnencrypted(t *testing.T) {
+	called := false
+	provider := func(ctx context.Context) (string, error) {
+		called = true
+		return "token-1", nil
+	}
+	if _, err := MakeConnectionWithSocket(&Config{User: "sa", accessTokenProvider: provider}, nil); err == nil {
+		t.Fatal("Expected an error for an access token with a user")
+	}
+
+	// Without TLS the token would be sent in plaintext, so we don't even connect:
+	mockSrv := mockserver.MakeMockServer(nil, t)
+	if _, err := MakeConnectionWithSocket(&Config{PacketSize: 0x1000, accessTokenProvider: provider}, mockSrv); err != errAccessTokenUnencrypted {
+		t.Fatal("Expected an error for an access token over an unencrypted connection, got: ", err)
+	}
+	if called || len(mockSrv.Written) != 0 {
+		t.Fatal("Expected nothing to be sent, got: ", mockSrv.Written)
+	}
+}
+
+func TestFedAuthFeature(t *testing.T) {
+	tokens := 0
+	provider := func(ctx context.Context) (string, error) {
+		tokens++
+		return "token-" + string(rune('0'+tokens)), nil
+	}
+	f := &fedAuth{}
+	if err := f.start(context.Background(), provider, fedAuthPreLoginOptions(t, testNonce)); err != nil {
+		t.Fatal(err)
+	}
+	c := &Conn{tdsVersion: TDS74, fedAuth: f}
+	expected := append([]byte{featureFedAuth, 0, 0, 0, 0}, makeFedAuthData("token-1")...)
+	binary.LittleEndian.PutUint32(expected[1:], uint32(len(expected)-5))
+	expected = append(expected, featureTerminator)
+	if ext := c.makeFeatureExt(); !bytes.Equal(ext, expected) {
+		t.Fatalf("Did not make expected FEDAUTH feature, got:\n% x\nexpected:\n% x", ext, expected)
+	}
+
+	if _, err := c.processTokens(makeFeatureExtAckToken(featureFedAuth, nil), nil); err != nil || !f.acknowledged {
+		t.Fatal("Expected the feature to be acknowledged, got: ", err)
+	}
+
+	// Every login, such as the reconnect of session recovery, gets a new token:
+	if err := f.start(context.Background(), provider, fedAuthPreLoginOptions(t, testNonce)); err != nil {
+		t.Fatal(err)
+	}
+	if f.acknowledged || !bytes.Equal(f.featureData(), makeFedAuthData("token-2")) {
+		t.Fatal("Expected a new token for the next login, got: ", f.token, f.acknowledged)
+	}
+}
+
+func TestFedAuthErrors(t *testing.T) {
+	failure := errors.New("no token")
+	err := (&fedAuth{}).start(context.Background(), func(ctx context.Context) (string, error) {
+		return "", failure
+	}, fedAuthPreLoginOptions(t, testNonce))
+	if !errors.Is(err, failure) {
+		t.Fatal("Expected the error of the provider, got: ", err)
+	}
+
+	err = (&fedAuth{}).start(context.Background(), func(ctx context.Context) (string, error) {
+		return "", nil
+	}, fedAuthPreLoginOptions(t, testNonce))
+	if err == nil {
+		t.Fatal("Expected an error for an empty token")
+	}
+
+	err = (&fedAuth{}).start(context.Background(), func(ctx context.Context) (string, error) {
+		return "token-1", nil
+	}, fedAuthPreLoginOptions(t, testNonce[:8]))
+	if err == nil {
+		t.Fatal("Expected an error for a nonce of the wrong length")
+	}
+}
@@ -0,0 +1,104 @@
+package krb5
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+var errInvalidCCache = errors.New("krb5: invalid credential cache")
+
+// The realm of the entries in which MIT Kerberos stores configuration instead of tickets.
+const ccacheConfigRealm = "X-CACHECONF:"
+
+// CCache is a credential cache as written by kinit: the tickets a user obtained.
+type CCache struct {
+	realm       string
+	principal   principalName
+	credentials []*credential
+}
+
+// DefaultCCache returns the path of the default credential cache: KRB5CCNAME, or /tmp/krb5cc_ followed by the ID of the user.
+func DefaultCCache() string {
+	if path := os.Getenv("KRB5CCNAME"); path != "" {
+		return path
+	}
+	return fmt.Sprintf("/tmp/krb5cc_%d", os.Getuid())
+}
+
+// LoadCCache reads a credential cache file, of which the path may be prefixed with FILE: as in KRB5CCNAME.
+// Only caches of the FILE type are supported.
+func LoadCCache(path string) (*CCache, error) {
+	if i := strings.IndexByte(path, ':'); i >= 0 {
+		if path[:i] != "FILE" {
+			return nil, fmt.Errorf("krb5: unsupported type of credential cache: %v", path[:i])
+		}
+		path = path[i+1:]
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseCCache(data)
+}
+
+// ParseCCache reads a credential cache in the file format of MIT Kerberos, version 3 or 4.
+func ParseCCache(data []byte) (*CCache, error) {
+	if len(data) < 2 || data[0] != 5 || data[1] < 3 || data[1] > 4 {
+		return nil, errInvalidCCache
+	}
+	r := &reader{data: data[2:], order: binary.BigEndian}
+	if data[1] == 4 {
+		// Header fields, e.g. the offset of the clock of the KDC, which we don't use
+		r.bytes(int(r.uint16()))
+	}
+
+	cc := &CCache{}
+	cc.realm, cc.principal = r.principal()
+	for r.err == nil && len(r.data) > 0 {
+		cred := &credential{}
+		r.principal() // The client, which is the default principal
+		cred.realm, cred.server = r.principal()
+		cred.key.KeyType = int32(r.uint16())
+		cred.key.KeyValue = r.bytes(int(r.uint32()))
+		r.uint32() // Auth time
+		r.uint32() // Start time
+		cred.endTime = time.Unix(int64(r.uint32()), 0)
+		r.uint32() // Renew till
+		r.uint8()  // Whether the ticket is encrypted in the session key of another
+		r.uint32() // Ticket flags
+		for i := r.uint32(); i > 0 && r.err == nil; i-- {
+			// Addresses
+			r.uint16()
+			r.bytes(int(r.uint32()))
+		}
+		for i := r.uint32(); i > 0 && r.err == nil; i-- {
+			// Authorization data
+			r.uint16()
+			r.bytes(int(r.uint32()))
+		}
+		cred.ticket = r.bytes(int(r.uint32()))
+		r.bytes(int(r.uint32())) // Second ticket
+		if cred.realm != ccacheConfigRealm {
+			cc.credentials = append(cc.credentials, cred)
+		}
+	}
+	if r.err != nil {
+		return nil, errInvalidCCache
+	}
+	return cc, nil
+}
+
+// principal reads a principal of a credential cache, along with its realm.
+func (r *reader) principal() (string, principalName) {
+	name := principalName{NameType: int32(r.uint32())}
+	components := r.uint32()
+	realm := string(r.bytes(int(r.uint32())))
+	for i := uint32(0); i < components && r.err == nil; i++ {
+		name.NameString = append(name.NameString, string(r.bytes(int(r.uint32()))))
+	}
+	return realm, name
+}
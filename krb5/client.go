@@ -0,0 +1,259 @@
+// Package krb5 implements the client side of Kerberos 5 (RFC 4120) through SPNEGO, as used for integrated security by SQL Server.
+// A client logs in with the key of a keytab or with the tickets in a credential cache, of which only AES encryption is supported.
+// KDCs are contacted over TCP.
+package krb5
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+// The time to connect to a KDC and get its reply.
+const kdcTimeout = 10 * time.Second
+
+// The lifetime of tickets we ask for, KDCs usually limit it to 10 hours.
+const ticketLifetime = 24 * time.Hour
+
+// Client gets tickets for services, on behalf of the user of a keytab or a credential cache.
+type Client struct {
+	cfg   *Config
+	realm string
+	cname principalName
+	// The key of the user from a keytab, nil with a credential cache.
+	key *encryptionKey
+	// The ticket granting ticket, with which service tickets are requested.
+	tgt *credential
+	// The service tickets we have.
+	tickets []*credential
+}
+
+// credential is a ticket, along with its session key.
+type credential struct {
+	// Of the service
+	realm   string
+	server  principalName
+	key     encryptionKey
+	ticket  []byte // The DER of the Ticket, which only the service can read
+	endTime time.Time
+}
+
+// NewClientWithKeytab returns a client for the given user, as user or service/host, which logs in with the key of the user in kt.
+// Without a realm, the default realm of cfg is used.
+func NewClientWithKeytab(kt *Keytab, user, realm string, cfg *Config) (*Client, error) {
+	if cfg == nil {
+		cfg = &Config{}
+	}
+	if realm == "" {
+		realm = cfg.DefaultRealm
+	}
+	if realm == "" {
+		return nil, errors.New("krb5: no realm given, and no default realm configured")
+	}
+	cname := principalName{NameType: nameTypePrincipal, NameString: strings.Split(user, "/")}
+	key, err := kt.key(realm, cname)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{cfg: cfg, realm: realm, cname: cname, key: key}, nil
+}
+
+// NewClientWithCCache returns a client for the default principal of cc, which uses its tickets.
+// Once the ticket granting ticket has expired, kinit has to be run again.
+func NewClientWithCCache(cc *CCache, cfg *Config) (*Client, error) {
+	if cfg == nil {
+		cfg = &Config{}
+	}
+	c := &Client{cfg: cfg, realm: cc.realm, cname: cc.principal}
+	for _, cred := range cc.credentials {
+		if cred.key.check() != nil {
+			continue
+		}
+		if cred.realm == cc.realm && cred.server.equal(tgsName(cc.realm)) {
+			c.tgt = cred
+		} else {
+			c.tickets = append(c.tickets, cred)
+		}
+	}
+	if c.tgt == nil && len(c.tickets) == 0 {
+		return nil, errors.New("krb5: no tickets with AES session keys in the credential cache")
+	}
+	return c, nil
+}
+
+// tgsName returns the name of the ticket granting service of realm.
+func tgsName(realm string) principalName {
+	return principalName{NameType: nameTypeSrvInst, NameString: []string{"krbtgt", realm}}
+}
+
+// parseSPN splits an SPN as service/host:port@REALM into the name and the realm, which is defaultRealm if it's left out.
+func parseSPN(spn, defaultRealm string) (principalName, string) {
+	realm := defaultRealm
+	if i := strings.LastIndexByte(spn, '@'); i >= 0 {
+		spn, realm = spn[:i], spn[i+1:]
+	}
+	return principalName{NameType: nameTypeSrvInst, NameString: strings.Split(spn, "/")}, realm
+}
+
+// serviceTicket returns a ticket for the service with the given SPN, requesting one if we have none yet.
+func (c *Client) serviceTicket(spn string) (*credential, error) {
+	sname, realm := parseSPN(spn, c.realm)
+	for _, cred := range c.tickets {
+		if cred.realm == realm && cred.server.equal(sname) && time.Now().Before(cred.endTime) {
+			return cred, nil
+		}
+	}
+
+	tgt, err := c.ticketGrantingTicket()
+	if err != nil {
+		return nil, err
+	}
+	cred, err := c.tgsExchange(tgt, sname, realm)
+	if err != nil {
+		return nil, err
+	}
+	// The KDC may have canonicalized the name, but we look it up by the one we asked for:
+	cred.realm, cred.server = realm, sname
+	c.tickets = append(c.tickets, cred)
+	return cred, nil
+}
+
+// ticketGrantingTicket returns the ticket granting ticket, logging in with the key of the user if we have none yet.
+func (c *Client) ticketGrantingTicket() (*credential, error) {
+	if c.tgt != nil && time.Now().Before(c.tgt.endTime) {
+		return c.tgt, nil
+	}
+	if c.key == nil {
+		return nil, errors.New("krb5: no valid ticket granting ticket in the credential cache, it can be renewed with kinit")
+	}
+
+	// Pre-authenticate with the current time, encrypted in the key of the user:
+	now := time.Now().UTC()
+	timestamp, err := c.key.encrypt(usageASReqTimestamp, marshalTimestamp(now))
+	if err != nil {
+		return nil, err
+	}
+	paData := marshalPAData(paEncTimestamp, encryptedData{EType: c.key.KeyType, Cipher: timestamp}.marshal())
+
+	nonce, err := newNonce()
+	if err != nil {
+		return nil, err
+	}
+	body := marshalReqBody(&c.cname, c.realm, tgsName(c.realm), now.Add(ticketLifetime), nonce, []int32{c.key.KeyType})
+	tgt, err := c.kdcExchange(c.realm, marshalKDCReq(msgASReq, [][]byte{paData}, body), msgASRep, *c.key, usageASRepEncPart, nonce)
+	if err != nil {
+		return nil, err
+	}
+	c.tgt = tgt
+	return tgt, nil
+}
+
+// tgsExchange requests a ticket for a service with the ticket granting ticket.
+func (c *Client) tgsExchange(tgt *credential, sname principalName, realm string) (*credential, error) {
+	nonce, err := newNonce()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	body := marshalReqBody(nil, realm, sname, now.Add(ticketLifetime), nonce, supportedETypes)
+
+	// The authenticator proves we hold the session key of the ticket granting ticket, and protects the request with a checksum:
+	authenticator, err := tgt.key.encrypt(usageTGSReqAuthenticator,
+		marshalAuthenticator(c.realm, c.cname, tgt.key.checksumType(), tgt.key.checksum(usageTGSReqChecksum, body), now, 0))
+	if err != nil {
+		return nil, err
+	}
+	apReq := marshalAPReq(0, tgt.ticket, encryptedData{EType: tgt.key.KeyType, Cipher: authenticator})
+	return c.kdcExchange(c.realm, marshalKDCReq(msgTGSReq, [][]byte{marshalPAData(paTGSReq, apReq)}, body), msgTGSRep, tgt.key, usageTGSRepEncPart, nonce)
+}
+
+// kdcExchange sends an AS-REQ or TGS-REQ to a KDC of realm, and returns the ticket of the reply.
+// The encrypted part of the reply is decrypted with key.
+func (c *Client) kdcExchange(realm string, req []byte, msgType int, key encryptionKey, usage uint32, nonce uint32) (*credential, error) {
+	reply, err := c.send(realm, req)
+	if err != nil {
+		return nil, err
+	}
+	var rep kdcRep
+	if err = unmarshalApplication(reply, &rep, msgType); err != nil {
+		return nil, err
+	}
+	if rep.EncPart.EType != key.KeyType {
+		return nil, fmt.Errorf("krb5: the KDC replied with encryption type %d instead of %d", rep.EncPart.EType, key.KeyType)
+	}
+	plaintext, err := key.decrypt(usage, rep.EncPart.Cipher)
+	if err != nil {
+		return nil, err
+	}
+	// Some KDCs use the tag of an AS-REP for a TGS-REP, as MIT Kerberos accepts either:
+	var part encKDCRepPart
+	if err = unmarshalApplication(plaintext, &part, msgEncASRepPart, msgEncTGSRepPart); err != nil {
+		return nil, err
+	}
+	if part.Nonce != int64(nonce) {
+		return nil, errors.New("krb5: the reply of the KDC does not match the request")
+	}
+	if err = part.Key.check(); err != nil {
+		return nil, err
+	}
+	return &credential{realm: part.SRealm, server: part.SName, key: part.Key, ticket: rep.Ticket.Bytes, endTime: part.EndTime}, nil
+}
+
+// send sends a request to the KDCs of realm, until one of them replies.
+func (c *Client) send(realm string, req []byte) ([]byte, error) {
+	addrs, err := c.cfg.kdcs(realm)
+	if err != nil {
+		return nil, err
+	}
+	var errs []error
+	for _, addr := range addrs {
+		reply, err := sendTCP(addr, req)
+		if err == nil {
+			return reply, nil
+		}
+		errs = append(errs, err)
+	}
+	return nil, errors.Join(errs...)
+}
+
+// sendTCP sends a request to the KDC at addr. Over TCP, messages are preceded by their length.
+func sendTCP(addr string, req []byte) ([]byte, error) {
+	conn, err := net.DialTimeout("tcp", addr, kdcTimeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(kdcTimeout))
+
+	if _, err = conn.Write(append(binary.BigEndian.AppendUint32(nil, uint32(len(req))), req...)); err != nil {
+		return nil, err
+	}
+	var length [4]byte
+	if _, err = io.ReadFull(conn, length[:]); err != nil {
+		return nil, err
+	}
+	// The high bit is reserved for extensions, which we never ask for
+	n := binary.BigEndian.Uint32(length[:])
+	if n >= 1<<24 {
+		return nil, fmt.Errorf("krb5: invalid reply of %d bytes from KDC %v", n, addr)
+	}
+	reply := make([]byte, n)
+	if _, err = io.ReadFull(conn, reply); err != nil {
+		return nil, err
+	}
+	return reply, nil
+}
+
+// newNonce returns a random number, which KDCs expect to fit in 31 bits.
+func newNonce() (uint32, error) {
+	var b [4]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint32(b[:]) & 0x7fffffff, nil
+}
@@ -0,0 +1,585 @@
+package krb5
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/asn1"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// The messages the stand-ins for the KDC and the service read, which the client only writes.
+type testKDCReq struct {
+	PVNO    int           `asn1:"explicit,tag:1"`
+	MsgType int           `asn1:"explicit,tag:2"`
+	PAData  []testPAData  `asn1:"explicit,optional,tag:3"`
+	ReqBody asn1.RawValue `asn1:"explicit,tag:4"`
+}
+
+type testPAData struct {
+	Type  int    `asn1:"explicit,tag:1"`
+	Value []byte `asn1:"explicit,tag:2"`
+}
+
+type testReqBody struct {
+	Options asn1.BitString `asn1:"explicit,tag:0"`
+	CName   principalName  `asn1:"explicit,optional,tag:1"`
+	Realm   string         `asn1:"explicit,tag:2"`
+	SName   principalName  `asn1:"explicit,optional,tag:3"`
+	Till    time.Time      `asn1:"generalized,explicit,tag:5"`
+	Nonce   int64          `asn1:"explicit,tag:7"`
+	ETypes  []int32        `asn1:"explicit,tag:8"`
+}
+
+type testTimestamp struct {
+	Time time.Time `asn1:"generalized,explicit,tag:0"`
+	USec int       `asn1:"explicit,optional,tag:1"`
+}
+
+type testAPReq struct {
+	PVNO          int            `asn1:"explicit,tag:0"`
+	MsgType       int            `asn1:"explicit,tag:1"`
+	Options       asn1.BitString `asn1:"explicit,tag:2"`
+	Ticket        asn1.RawValue  `asn1:"explicit,tag:3"`
+	Authenticator encryptedData  `asn1:"explicit,tag:4"`
+}
+
+type testTicket struct {
+	TktVNO  int           `asn1:"explicit,tag:0"`
+	Realm   string        `asn1:"explicit,tag:1"`
+	SName   principalName `asn1:"explicit,tag:2"`
+	EncPart encryptedData `asn1:"explicit,tag:3"`
+}
+
+// testEncTicketPart is the part of an EncTicketPart the stand-ins use.
+type testEncTicketPart struct {
+	Key    encryptionKey `asn1:"explicit,tag:1"`
+	CRealm string        `asn1:"explicit,tag:2"`
+	CName  principalName `asn1:"explicit,tag:3"`
+}
+
+type testAuthenticator struct {
+	VNO       int           `asn1:"explicit,tag:0"`
+	CRealm    string        `asn1:"explicit,tag:1"`
+	CName     principalName `asn1:"explicit,tag:2"`
+	Checksum  testChecksum  `asn1:"explicit,tag:3"`
+	CUSec     int           `asn1:"explicit,tag:4"`
+	CTime     time.Time     `asn1:"generalized,explicit,tag:5"`
+	SeqNumber int64         `asn1:"explicit,optional,tag:7"`
+}
+
+type testChecksum struct {
+	Type     int32  `asn1:"explicit,tag:0"`
+	Checksum []byte `asn1:"explicit,tag:1"`
+}
+
+type testNegTokenInit struct {
+	MechTypes []asn1.ObjectIdentifier `asn1:"explicit,tag:0"`
+	MechToken []byte                  `asn1:"explicit,optional,tag:2"`
+}
+
+func randomKey(t *testing.T) encryptionKey {
+	key := encryptionKey{KeyType: ETypeAES256, KeyValue: make([]byte, 32)}
+	if _, err := rand.Read(key.KeyValue); err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// testKDC is a stand-in for the KDC of a realm, which knows the keys of its principals.
+type testKDC struct {
+	t     *testing.T
+	realm string
+	addr  string
+
+	mu       sync.Mutex
+	keys     map[string]encryptionKey
+	requests int
+}
+
+func newTestKDC(t *testing.T, realm string) *testKDC {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	kdc := &testKDC{t: t, realm: realm, addr: listener.Addr().String(), keys: make(map[string]encryptionKey)}
+	kdc.keys[tgsName(realm).String()] = randomKey(t)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			kdc.serve(conn)
+		}
+	}()
+	return kdc
+}
+
+// addPrincipal adds a principal with a random key, which it returns.
+func (kdc *testKDC) addPrincipal(name string) encryptionKey {
+	kdc.mu.Lock()
+	defer kdc.mu.Unlock()
+	key := randomKey(kdc.t)
+	kdc.keys[name] = key
+	return key
+}
+
+func (kdc *testKDC) key(name principalName) (encryptionKey, bool) {
+	kdc.mu.Lock()
+	defer kdc.mu.Unlock()
+	key, ok := kdc.keys[name.String()]
+	return key, ok
+}
+
+func (kdc *testKDC) serve(conn net.Conn) {
+	defer conn.Close()
+	var length [4]byte
+	if _, err := io.ReadFull(conn, length[:]); err != nil {
+		kdc.t.Error("KDC could not read: ", err)
+		return
+	}
+	req := make([]byte, binary.BigEndian.Uint32(length[:]))
+	if _, err := io.ReadFull(conn, req); err != nil {
+		kdc.t.Error("KDC could not read: ", err)
+		return
+	}
+	reply := kdc.handle(req)
+	conn.Write(append(binary.BigEndian.AppendUint32(nil, uint32(len(reply))), reply...))
+}
+
+func (kdc *testKDC) handle(req []byte) []byte {
+	kdc.mu.Lock()
+	kdc.requests++
+	kdc.mu.Unlock()
+
+	var raw asn1.RawValue
+	var kdcReq testKDCReq
+	var body testReqBody
+	if _, err := asn1.Unmarshal(req, &raw); err != nil {
+		kdc.t.Error("KDC could not read the request: ", err)
+	} else if _, err = asn1.Unmarshal(raw.Bytes, &kdcReq); err != nil {
+		kdc.t.Error("KDC could not read the request: ", err)
+	} else if _, err = asn1.Unmarshal(kdcReq.ReqBody.Bytes, &body); err != nil {
+		kdc.t.Error("KDC could not read the request body: ", err)
+	}
+	if kdcReq.PVNO != pvno || kdcReq.MsgType != raw.Tag || body.Realm != kdc.realm || body.Nonce == 0 || body.Till.IsZero() {
+		kdc.t.Error("KDC got an invalid request: ", kdcReq, body)
+	}
+
+	switch raw.Tag {
+	case msgASReq:
+		// Pre-authentication with the key of the user is required:
+		userKey, ok := kdc.key(body.CName)
+		if !ok {
+			return kdc.error(6)
+		}
+		if len(kdcReq.PAData) != 1 || kdcReq.PAData[0].Type != paEncTimestamp {
+			return kdc.error(25)
+		}
+		var encTimestamp encryptedData
+		var timestamp testTimestamp
+		if _, err := asn1.Unmarshal(kdcReq.PAData[0].Value, &encTimestamp); err != nil {
+			kdc.t.Error("KDC could not read the timestamp: ", err)
+		}
+		plaintext, err := userKey.decrypt(usageASReqTimestamp, encTimestamp.Cipher)
+		if err != nil {
+			return kdc.error(24)
+		}
+		if _, err = asn1.Unmarshal(plaintext, &timestamp); err != nil || time.Since(timestamp.Time) > time.Minute {
+			kdc.t.Error("KDC got an invalid timestamp: ", timestamp, err)
+		}
+		return kdc.issue(body, body.CName, userKey, usageASRepEncPart, msgASRep, msgEncASRepPart)
+
+	case msgTGSReq:
+		// With the ticket granting ticket, and an authenticator with a checksum of the body:
+		var apReq testAPReq
+		if len(kdcReq.PAData) != 1 || kdcReq.PAData[0].Type != paTGSReq {
+			kdc.t.Error("KDC expected PA-TGS-REQ, got: ", kdcReq.PAData)
+			return kdc.error(25)
+		}
+		if err := unmarshalApplication(kdcReq.PAData[0].Value, &apReq, msgAPReq); err != nil {
+			kdc.t.Error("KDC could not read the AP-REQ: ", err)
+		}
+		tgsKey, _ := kdc.key(tgsName(kdc.realm))
+		ticket, auth := readAPReq(kdc.t, apReq, tgsKey, usageTGSReqAuthenticator)
+		if !bytes.Equal(auth.Checksum.Checksum, ticket.Key.checksum(usageTGSReqChecksum, kdcReq.ReqBody.Bytes)) {
+			return kdc.error(41)
+		}
+		return kdc.issue(body, ticket.CName, ticket.Key, usageTGSRepEncPart, msgTGSRep, msgEncTGSRepPart)
+	}
+	kdc.t.Error("KDC got an unexpected message: ", raw.Tag)
+	return kdc.error(1)
+}
+
+// issue returns the reply with a ticket for the service in body, of which the encrypted part is encrypted with replyKey.
+func (kdc *testKDC) issue(body testReqBody, cname principalName, replyKey encryptionKey, usage uint32, msgType, encPartType int) []byte {
+	serviceKey, ok := kdc.key(body.SName)
+	if !ok {
+		return kdc.error(7)
+	}
+	sessionKey := randomKey(kdc.t)
+	encTicketPart, _ := serviceKey.encrypt(usageTicket, derApplication(msgEncTicketPart, derSequence(
+		derContext(1, sessionKey.marshal()),
+		derContext(2, derGeneralString(kdc.realm)),
+		derContext(3, cname.marshal()))))
+	ticket := derApplication(msgTicket, derSequence(
+		derContext(0, derInteger(pvno)),
+		derContext(1, derGeneralString(kdc.realm)),
+		derContext(2, body.SName.marshal()),
+		derContext(3, encryptedData{EType: serviceKey.KeyType, KVNO: 1, Cipher: encTicketPart}.marshal())))
+
+	now := time.Now()
+	encPart, _ := replyKey.encrypt(usage, derApplication(encPartType, derSequence(
+		derContext(0, sessionKey.marshal()),
+		derContext(1, derSequence()),
+		derContext(2, derInteger(body.Nonce)),
+		derContext(4, derFlags(0)),
+		derContext(5, derTime(now)),
+		derContext(7, derTime(now.Add(time.Hour))),
+		derContext(9, derGeneralString(kdc.realm)),
+		derContext(10, body.SName.marshal()))))
+	return derApplication(msgType, derSequence(
+		derContext(0, derInteger(pvno)),
+		derContext(1, derInteger(int64(msgType))),
+		derContext(3, derGeneralString(kdc.realm)),
+		derContext(4, cname.marshal()),
+		derContext(5, ticket),
+		derContext(6, encryptedData{EType: replyKey.KeyType, Cipher: encPart}.marshal())))
+}
+
+func (kdc *testKDC) error(code int) []byte {
+	return derApplication(msgError, derSequence(
+		derContext(0, derInteger(pvno)),
+		derContext(1, derInteger(msgError)),
+		derContext(4, derTime(time.Now())),
+		derContext(5, derInteger(0)),
+		derContext(6, derInteger(int64(code))),
+		derContext(9, derGeneralString(kdc.realm)),
+		derContext(10, tgsName(kdc.realm).marshal())))
+}
+
+// readAPReq decrypts the ticket in an AP-REQ with the key of the service, and then the authenticator with the session key in it.
+func readAPReq(t *testing.T, apReq testAPReq, serviceKey encryptionKey, usage uint32) (testEncTicketPart, testAuthenticator) {
+	var ticket testTicket
+	var encTicketPart testEncTicketPart
+	var auth testAuthenticator
+	if err := unmarshalApplication(apReq.Ticket.Bytes, &ticket, msgTicket); err != nil {
+		t.Error("Could not read the ticket: ", err)
+	}
+	plaintext, err := serviceKey.decrypt(usageTicket, ticket.EncPart.Cipher)
+	if err != nil {
+		t.Error("Could not decrypt the ticket: ", err)
+	}
+	if err = unmarshalApplication(plaintext, &encTicketPart, msgEncTicketPart); err != nil {
+		t.Error("Could not read the ticket: ", err)
+	}
+	if plaintext, err = encTicketPart.Key.decrypt(usage, apReq.Authenticator.Cipher); err != nil {
+		t.Error("Could not decrypt the authenticator: ", err)
+	}
+	if err = unmarshalApplication(plaintext, &auth, msgAuthenticator); err != nil {
+		t.Error("Could not read the authenticator: ", err)
+	}
+	if !auth.CName.equal(encTicketPart.CName) || auth.CRealm != encTicketPart.CRealm {
+		t.Error("The authenticator is not of the client of the ticket, got: ", auth.CName, encTicketPart.CName)
+	}
+	return encTicketPart, auth
+}
+
+// acceptSecContext is the service's side of InitSecContext: it checks the token of the client, and returns its answer along with the client.
+func acceptSecContext(t *testing.T, serviceKey encryptionKey, token []byte) ([]byte, string) {
+	var raw, init asn1.RawValue
+	var oid asn1.ObjectIdentifier
+	var negTokenInit testNegTokenInit
+	var apReq testAPReq
+	if _, err := asn1.Unmarshal(token, &raw); err != nil || raw.Tag != 0 {
+		t.Fatal("Expected a GSS-API token, got: ", raw.Tag, err)
+	}
+	rest, err := asn1.Unmarshal(raw.Bytes, &oid)
+	if err != nil || !oid.Equal(oidSPNEGO) {
+		t.Fatal("Expected SPNEGO, got: ", oid, err)
+	}
+	if _, err = asn1.Unmarshal(rest, &init); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = asn1.Unmarshal(init.Bytes, &negTokenInit); err != nil || len(negTokenInit.MechTypes) != 1 || !negTokenInit.MechTypes[0].Equal(oidKRB5) {
+		t.Fatal("Expected a NegTokenInit for Kerberos, got: ", negTokenInit, err)
+	}
+	tokenID, message, err := gssUnwrap(negTokenInit.MechToken)
+	if err != nil || tokenID != tokenAPReq {
+		t.Fatal("Expected an AP-REQ, got: ", tokenID, err)
+	}
+	if err = unmarshalApplication(message, &apReq, msgAPReq); err != nil {
+		t.Fatal(err)
+	}
+	ticket, auth := readAPReq(t, apReq, serviceKey, usageAPReqAuthenticator)
+	if apReq.Options.At(2) != 1 || auth.Checksum.Type != gssChecksumType || len(auth.Checksum.Checksum) != 24 || auth.Checksum.Checksum[20]&gssMutual == 0 {
+		t.Fatal("Expected mutual authentication to be requested, got: ", apReq.Options, auth.Checksum)
+	}
+
+	encPart, _ := ticket.Key.encrypt(usageAPRepEncPart, derApplication(msgEncAPRepPart, derSequence(
+		derContext(0, derTime(auth.CTime)),
+		derContext(1, derInteger(int64(auth.CUSec))))))
+	apRep := derApplication(msgAPRep, derSequence(
+		derContext(0, derInteger(pvno)),
+		derContext(1, derInteger(msgAPRep)),
+		derContext(2, encryptedData{EType: ticket.Key.KeyType, Cipher: encPart}.marshal())))
+	resp := derContext(1, derSequence(
+		derContext(0, derElement(0x0a, []byte{0})), // accept-completed
+		derContext(1, derOID(oidKRB5)),
+		derContext(2, derOctetString(gssWrap(tokenAPRep, apRep)))))
+	return resp, auth.CName.String() + "@" + auth.CRealm
+}
+
+type testKeytabEntry struct {
+	kvno uint8
+	key  encryptionKey
+}
+
+// makeKeytab returns a keytab of version 2 with keys of a principal, starting with a hole left by a deleted entry.
+func makeKeytab(realm string, principal principalName, entries ...testKeytabEntry) []byte {
+	appendString := func(b []byte, s []byte) []byte {
+		return append(binary.BigEndian.AppendUint16(b, uint16(len(s))), s...)
+	}
+	b := []byte{5, 2, 0xff, 0xff, 0xff, 0xfd, 0, 0, 0}
+	for _, entry := range entries {
+		e := binary.BigEndian.AppendUint16(nil, uint16(len(principal.NameString)))
+		e = appendString(e, []byte(realm))
+		for _, name := range principal.NameString {
+			e = appendString(e, []byte(name))
+		}
+		e = binary.BigEndian.AppendUint32(e, uint32(principal.NameType))
+		e = binary.BigEndian.AppendUint32(e, 0) // Timestamp
+		e = append(e, entry.kvno)
+		e = binary.BigEndian.AppendUint16(e, uint16(entry.key.KeyType))
+		e = appendString(e, entry.key.KeyValue)
+		b = binary.BigEndian.AppendUint32(b, uint32(len(e)))
+		b = append(b, e...)
+	}
+	return b
+}
+
+// makeCCache returns a credential cache of version 4 with the given credentials of a principal, and a configuration entry.
+func makeCCache(realm string, principal principalName, creds ...*credential) []byte {
+	appendData := func(b []byte, data []byte) []byte {
+		return append(binary.BigEndian.AppendUint32(b, uint32(len(data))), data...)
+	}
+	appendPrincipal := func(b []byte, realm string, name principalName) []byte {
+		b = binary.BigEndian.AppendUint32(b, uint32(name.NameType))
+		b = binary.BigEndian.AppendUint32(b, uint32(len(name.NameString)))
+		b = appendData(b, []byte(realm))
+		for _, s := range name.NameString {
+			b = appendData(b, []byte(s))
+		}
+		return b
+	}
+
+	b := []byte{5, 4, 0, 12, 0, 1, 0, 8, 0, 0, 0, 0, 0, 0, 0, 0} // A header with the offset of the KDC's clock
+	b = appendPrincipal(b, realm, principal)
+	creds = append(creds, &credential{realm: ccacheConfigRealm, server: principalName{NameString: []string{"krb5_ccache_conf_data", "pa_type"}}, ticket: []byte("2")})
+	for _, cred := range creds {
+		b = appendPrincipal(b, realm, principal)
+		b = appendPrincipal(b, cred.realm, cred.server)
+		b = binary.BigEndian.AppendUint16(b, uint16(cred.key.KeyType))
+		b = appendData(b, cred.key.KeyValue)
+		for _, t := range []int64{0, 0, cred.endTime.Unix(), 0} {
+			b = binary.BigEndian.AppendUint32(b, uint32(t))
+		}
+		b = append(b, 0)                        // Not encrypted in a session key
+		b = binary.BigEndian.AppendUint32(b, 0) // Flags
+		b = binary.BigEndian.AppendUint32(b, 1) // One address
+		b = binary.BigEndian.AppendUint16(b, 2) // IPv4
+		b = appendData(b, []byte{127, 0, 0, 1}) // 127.0.0.1
+		b = binary.BigEndian.AppendUint32(b, 0) // No authorization data
+		b = appendData(b, cred.ticket)
+		b = appendData(b, nil) // No second ticket
+	}
+	return b
+}
+
+func TestKeytab(t *testing.T) {
+	alice := principalName{NameType: nameTypePrincipal, NameString: []string{"alice"}}
+	old, aes128, aes256 := randomKey(t), randomKey(t), randomKey(t)
+	aes128.KeyType, aes128.KeyValue = ETypeAES128, aes128.KeyValue[:16]
+	rc4 := encryptionKey{KeyType: 23, KeyValue: make([]byte, 16)}
+	kt, err := ParseKeytab(makeKeytab("EXAMPLE.COM", alice, testKeytabEntry{1, old}, testKeytabEntry{2, aes128}, testKeytabEntry{2, aes256}, testKeytabEntry{3, rc4}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The newest key we support, of the strongest type:
+	if key, err := kt.key("EXAMPLE.COM", alice); err != nil || !bytes.Equal(key.KeyValue, aes256.KeyValue) {
+		t.Fatal("Expected the AES256 key of version 2, got: ", key, err)
+	}
+	if _, err := kt.key("EXAMPLE.ORG", alice); err == nil {
+		t.Fatal("Expected an error for a principal that isn't in the keytab")
+	}
+	if _, err := ParseKeytab([]byte{5, 2, 0, 0, 0, 9, 0}); err != errInvalidKeytab {
+		t.Fatal("Expected an invalid keytab, got: ", err)
+	}
+}
+
+func TestParseConfig(t *testing.T) {
+	cfg, err := ParseConfig(strings.NewReader(`
+# Comment
+[libdefaults]
+	default_realm = EXAMPLE.COM
+	dns_lookup_kdc = false
+
+[realms]
+	EXAMPLE.COM = {
+		kdc = dc1.example.com
+		kdc = tcp/dc2.example.com:750
+		admin_server = dc1.example.com
+	}
+	EXAMPLE.ORG = {
+		auth_to_local = {
+			kdc = not.a.kdc
+		}
+	}
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.DefaultRealm != "EXAMPLE.COM" {
+		t.Fatal("Wrong default realm, got: ", cfg.DefaultRealm)
+	}
+	if kdcs, err := cfg.kdcs("EXAMPLE.COM"); err != nil || strings.Join(kdcs, ",") != "dc1.example.com:88,dc2.example.com:750" {
+		t.Fatal("Wrong KDCs, got: ", kdcs, err)
+	}
+
+	// Other realms are looked up in DNS:
+	defer func(original func(service, proto, name string) (string, []*net.SRV, error)) {
+		lookupSRV = original
+	}(lookupSRV)
+	lookupSRV = func(service, proto, name string) (string, []*net.SRV, error) {
+		if service != "kerberos" || proto != "tcp" || name != "EXAMPLE.ORG" {
+			t.Error("Unexpected lookup: ", service, proto, name)
+		}
+		return "", []*net.SRV{{Target: "dc.example.org.", Port: 88}}, nil
+	}
+	if kdcs, err := cfg.kdcs("EXAMPLE.ORG"); err != nil || strings.Join(kdcs, ",") != "dc.example.org:88" {
+		t.Fatal("Wrong KDCs from DNS, got: ", kdcs, err)
+	}
+}
+
+func TestInitSecContext(t *testing.T) {
+	kdc := newTestKDC(t, "EXAMPLE.COM")
+	userKey := kdc.addPrincipal("alice")
+	serviceKey := kdc.addPrincipal("MSSQLSvc/db.example.com:1433")
+	cfg := &Config{DefaultRealm: "EXAMPLE.COM", KDCs: map[string][]string{"EXAMPLE.COM": {kdc.addr}}}
+
+	kt, err := ParseKeytab(makeKeytab("EXAMPLE.COM", principalName{NameType: nameTypePrincipal, NameString: []string{"alice"}}, testKeytabEntry{1, userKey}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := NewClientWithKeytab(kt, "alice", "", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		context, token, err := client.InitSecContext("MSSQLSvc/db.example.com:1433")
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, user := acceptSecContext(t, serviceKey, token)
+		if user != "alice@EXAMPLE.COM" {
+			t.Fatal("Authenticated as the wrong user, got: ", user)
+		}
+		if err = context.Continue(resp); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The ticket is used again:
+	if kdc.requests != 2 {
+		t.Fatal("Expected an AS-REQ and a TGS-REQ, got: ", kdc.requests)
+	}
+
+	// An impostor can't answer:
+	context, _, err := client.InitSecContext("MSSQLSvc/db.example.com:1433")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, token, err := client.InitSecContext("MSSQLSvc/db.example.com:1433")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, _ := acceptSecContext(t, serviceKey, token)
+	if err = context.Continue(resp); err == nil {
+		t.Fatal("Expected an error for the answer to another authenticator")
+	}
+
+	// Errors of the KDC:
+	var krbErr *Error
+	if _, _, err = client.InitSecContext("MSSQLSvc/unknown.example.com:1433"); !errors.As(err, &krbErr) || krbErr.Code != 7 {
+		t.Fatal("Expected an error for an unknown service, got: ", err)
+	}
+	kdc.addPrincipal("alice")
+	client, _ = NewClientWithKeytab(kt, "alice", "EXAMPLE.COM", cfg)
+	if _, _, err = client.InitSecContext("MSSQLSvc/db.example.com:1433"); !errors.As(err, &krbErr) || krbErr.Code != 24 {
+		t.Fatal("Expected an error for an outdated key, got: ", err)
+	}
+}
+
+func TestCCache(t *testing.T) {
+	kdc := newTestKDC(t, "EXAMPLE.COM")
+	userKey := kdc.addPrincipal("alice")
+	serviceKey := kdc.addPrincipal("MSSQLSvc/db.example.com:1433")
+	cfg := &Config{KDCs: map[string][]string{"EXAMPLE.COM": {kdc.addr}}}
+
+	// Log in as kinit would:
+	alice := principalName{NameType: nameTypePrincipal, NameString: []string{"alice"}}
+	kt, _ := ParseKeytab(makeKeytab("EXAMPLE.COM", alice, testKeytabEntry{1, userKey}))
+	kinit, err := NewClientWithKeytab(kt, "alice", "EXAMPLE.COM", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tgt, err := kinit.ticketGrantingTicket()
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "krb5cc")
+	if err = os.WriteFile(path, makeCCache("EXAMPLE.COM", alice, tgt), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	cc, err := LoadCCache("FILE:" + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := NewClientWithCCache(cc, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	context, token, err := client.InitSecContext("MSSQLSvc/db.example.com:1433@EXAMPLE.COM")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, user := acceptSecContext(t, serviceKey, token)
+	if user != "alice@EXAMPLE.COM" {
+		t.Fatal("Authenticated as the wrong user, got: ", user)
+	}
+	if err = context.Continue(resp); err != nil {
+		t.Fatal(err)
+	}
+
+	// Without a key we can't log in again once the ticket granting ticket expires:
+	tgt.endTime = time.Now().Add(-time.Minute)
+	cc, _ = ParseCCache(makeCCache("EXAMPLE.COM", alice, tgt))
+	client, _ = NewClientWithCCache(cc, cfg)
+	if _, _, err = client.InitSecContext("MSSQLSvc/db.example.com:1433"); err == nil {
+		t.Fatal("Expected an error for an expired ticket granting ticket")
+	}
+	if _, err = LoadCCache("KEYRING:persistent:1000"); err == nil {
+		t.Fatal("Expected an error for an unsupported type of credential cache")
+	}
+}
@@ -0,0 +1,104 @@
+package krb5
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// lookupSRV looks up the KDCs of realms that aren't configured, it's a variable so tests can replace it.
+var lookupSRV = net.LookupSRV
+
+// Config is what we use of krb5.conf: the default realm, and the KDCs of realms.
+type Config struct {
+	DefaultRealm string
+	// The KDCs by realm, as host or host:port. The KDCs of other realms are looked up through the SRV records of DNS, as Active Directory publishes them.
+	KDCs map[string][]string
+}
+
+// DefaultConfig loads the configuration from KRB5_CONFIG or /etc/krb5.conf, when there is no such file the configuration is empty.
+func DefaultConfig() (*Config, error) {
+	path := os.Getenv("KRB5_CONFIG")
+	if path == "" {
+		path = "/etc/krb5.conf"
+	}
+	// KRB5_CONFIG may hold a list of files, of which we only read the first
+	path, _, _ = strings.Cut(path, ":")
+
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return &Config{KDCs: make(map[string][]string)}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseConfig(f)
+}
+
+// ParseConfig reads a configuration in the format of krb5.conf, of which only default_realm and the kdc entries of realms are used.
+func ParseConfig(r io.Reader) (*Config, error) {
+	cfg := &Config{KDCs: make(map[string][]string)}
+	var section, realm string
+	depth := 0
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "" || line[0] == '#' || line[0] == ';':
+			continue
+		case line[0] == '[' && depth == 0:
+			section = strings.TrimSpace(strings.Trim(line, "[]"))
+			continue
+		case line == "}":
+			depth--
+			continue
+		}
+
+		key, value, _ := strings.Cut(line, "=")
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+		if value == "{" {
+			depth++
+			if depth == 1 {
+				realm = key
+			}
+			continue
+		}
+		switch {
+		case section == "libdefaults" && depth == 0 && key == "default_realm":
+			cfg.DefaultRealm = value
+		case section == "realms" && depth == 1 && key == "kdc":
+			cfg.KDCs[realm] = append(cfg.KDCs[realm], value)
+		}
+	}
+	return cfg, scanner.Err()
+}
+
+// kdcs returns the addresses of the KDCs of realm.
+func (c *Config) kdcs(realm string) ([]string, error) {
+	var addrs []string
+	for _, kdc := range c.KDCs[realm] {
+		// We only talk TCP, which every KDC supports
+		kdc = strings.TrimPrefix(kdc, "tcp/")
+		if _, _, err := net.SplitHostPort(kdc); err != nil {
+			kdc = net.JoinHostPort(kdc, "88")
+		}
+		addrs = append(addrs, kdc)
+	}
+	if len(addrs) > 0 {
+		return addrs, nil
+	}
+
+	_, records, err := lookupSRV("kerberos", "tcp", realm)
+	if err != nil {
+		return nil, fmt.Errorf("krb5: no KDC found for realm %v: %v", realm, err)
+	}
+	for _, record := range records {
+		addrs = append(addrs, net.JoinHostPort(strings.TrimSuffix(record.Target, "."), strconv.Itoa(int(record.Port))))
+	}
+	return addrs, nil
+}
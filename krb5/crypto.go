@@ -0,0 +1,235 @@
+package krb5
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// The encryption types we support: those of RFC 3962, which are used by Active Directory since Windows Server 2008.
+// DES is long broken, and RC4 is being phased out.
+const (
+	ETypeAES128 = 17 // aes128-cts-hmac-sha1-96
+	ETypeAES256 = 18 // aes256-cts-hmac-sha1-96
+)
+
+// The checksum types that go with them:
+const (
+	checksumAES128 = 15 // hmac-sha1-96-aes128
+	checksumAES256 = 16 // hmac-sha1-96-aes256
+)
+
+// The encryption types we ask the KDC for, in order of preference.
+var supportedETypes = []int32{ETypeAES256, ETypeAES128}
+
+// The length of the HMAC appended to encrypted data.
+const macSize = 12
+
+var errIntegrity = errors.New("krb5: integrity check of encrypted data failed")
+
+// encryptionKey is an EncryptionKey: a key along with its encryption type.
+type encryptionKey struct {
+	KeyType  int32  `asn1:"explicit,tag:0"`
+	KeyValue []byte `asn1:"explicit,tag:1"`
+}
+
+func (k encryptionKey) marshal() []byte {
+	return derSequence(derContext(0, derInteger(int64(k.KeyType))), derContext(1, derOctetString(k.KeyValue)))
+}
+
+// check returns an error if the key isn't of an encryption type we support.
+func (k encryptionKey) check() error {
+	switch {
+	case k.KeyType == ETypeAES128 && len(k.KeyValue) == 16, k.KeyType == ETypeAES256 && len(k.KeyValue) == 32:
+		return nil
+	case k.KeyType == ETypeAES128 || k.KeyType == ETypeAES256:
+		return fmt.Errorf("krb5: invalid key of %d bytes for encryption type %d", len(k.KeyValue), k.KeyType)
+	}
+	return fmt.Errorf("krb5: unsupported encryption type %d", k.KeyType)
+}
+
+func (k encryptionKey) checksumType() int32 {
+	if k.KeyType == ETypeAES128 {
+		return checksumAES128
+	}
+	return checksumAES256
+}
+
+// usageKey derives the key for a key usage from k: 0x99 for checksums, 0xAA for encryption or 0x55 for integrity.
+func (k encryptionKey) usageKey(usage uint32, kind byte) []byte {
+	constant := binary.BigEndian.AppendUint32(nil, usage)
+	return deriveKey(k.KeyValue, append(constant, kind))
+}
+
+// encrypt encrypts plaintext for a key usage, prefixed with a random confounder and followed by an HMAC.
+func (k encryptionKey) encrypt(usage uint32, plaintext []byte) ([]byte, error) {
+	if err := k.check(); err != nil {
+		return nil, err
+	}
+	data := make([]byte, aes.BlockSize, aes.BlockSize+len(plaintext))
+	if _, err := rand.Read(data); err != nil {
+		return nil, err
+	}
+	data = append(data, plaintext...)
+
+	ciphertext, err := encryptCTS(k.usageKey(usage, 0xaa), data)
+	if err != nil {
+		return nil, err
+	}
+	return append(ciphertext, hmacSHA1(k.usageKey(usage, 0x55), data)[:macSize]...), nil
+}
+
+// decrypt is the reverse of encrypt.
+func (k encryptionKey) decrypt(usage uint32, ciphertext []byte) ([]byte, error) {
+	if err := k.check(); err != nil {
+		return nil, err
+	}
+	if len(ciphertext) < aes.BlockSize+macSize {
+		return nil, errIntegrity
+	}
+	mac := ciphertext[len(ciphertext)-macSize:]
+	data, err := decryptCTS(k.usageKey(usage, 0xaa), ciphertext[:len(ciphertext)-macSize])
+	if err != nil {
+		return nil, err
+	}
+	if !hmac.Equal(mac, hmacSHA1(k.usageKey(usage, 0x55), data)[:macSize]) {
+		return nil, errIntegrity
+	}
+	return data[aes.BlockSize:], nil
+}
+
+// checksum returns the keyed checksum of data for a key usage.
+func (k encryptionKey) checksum(usage uint32, data []byte) []byte {
+	return hmacSHA1(k.usageKey(usage, 0x99), data)[:macSize]
+}
+
+func hmacSHA1(key, data []byte) []byte {
+	h := hmac.New(sha1.New, key)
+	h.Write(data)
+	return h.Sum(nil)
+}
+
+// deriveKey is DK of RFC 3961: the constant is n-folded to the block size, and then encrypted repeatedly until we have enough bits for a key.
+// For AES, random-to-key is the identity function.
+func deriveKey(key, constant []byte) []byte {
+	block, _ := aes.NewCipher(key)
+	folded := nfold(constant, aes.BlockSize)
+	derived := make([]byte, 0, len(key)+aes.BlockSize)
+	for len(derived) < len(key) {
+		block.Encrypt(folded, folded)
+		derived = append(derived, folded...)
+	}
+	return derived[:len(key)]
+}
+
+// nfold stretches or shrinks data to n bytes, as in RFC 3961 section 5.1:
+// copies of data, each rotated 13 bits further to the right, are added together in n byte chunks, with one's complement addition.
+func nfold(data []byte, n int) []byte {
+	l := len(data)
+	lcm := n * l / gcd(n, l)
+
+	stretched := make([]byte, 0, lcm)
+	for i := 0; i < lcm/l; i++ {
+		stretched = append(stretched, rotateRight(data, 13*i)...)
+	}
+
+	result := make([]byte, n)
+	for pos := 0; pos < lcm; pos += n {
+		carry := 0
+		for i := n - 1; i >= 0; i-- {
+			sum := int(result[i]) + int(stretched[pos+i]) + carry
+			result[i], carry = byte(sum), sum>>8
+		}
+		// The carry wraps around:
+		for i := n - 1; carry != 0; i = (i + n - 1) % n {
+			sum := int(result[i]) + carry
+			result[i], carry = byte(sum), sum>>8
+		}
+	}
+	return result
+}
+
+// rotateRight rotates data to the right by the given number of bits.
+func rotateRight(data []byte, bits int) []byte {
+	n := len(data) * 8
+	rotated := make([]byte, len(data))
+	for i := 0; i < n; i++ {
+		src := ((i-bits)%n + n) % n
+		if data[src/8]&(0x80>>(src%8)) != 0 {
+			rotated[i/8] |= 0x80 >> (i % 8)
+		}
+	}
+	return rotated
+}
+
+func gcd(a, b int) int {
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
+
+// encryptCTS encrypts with AES in CBC mode with ciphertext stealing (RFC 3962), with a zero IV:
+// the plaintext is padded with zeros to a whole number of blocks and encrypted in CBC mode, after which the last two blocks are swapped and the result is truncated to the length of the plaintext.
+func encryptCTS(key, plaintext []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(plaintext) < aes.BlockSize {
+		return nil, errors.New("krb5: data to encrypt is shorter than a block")
+	}
+	n := (len(plaintext) + aes.BlockSize - 1) / aes.BlockSize * aes.BlockSize
+	padded := make([]byte, n)
+	copy(padded, plaintext)
+	cipher.NewCBCEncrypter(block, make([]byte, aes.BlockSize)).CryptBlocks(padded, padded)
+	if n == aes.BlockSize {
+		return padded, nil
+	}
+
+	ciphertext := make([]byte, 0, len(plaintext))
+	ciphertext = append(ciphertext, padded[:n-2*aes.BlockSize]...)
+	ciphertext = append(ciphertext, padded[n-aes.BlockSize:]...)
+	return append(ciphertext, padded[n-2*aes.BlockSize:len(plaintext)-aes.BlockSize]...), nil
+}
+
+// decryptCTS is the reverse of encryptCTS.
+func decryptCTS(key, ciphertext []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(ciphertext) < aes.BlockSize {
+		return nil, errIntegrity
+	}
+	iv := make([]byte, aes.BlockSize)
+	if len(ciphertext) == aes.BlockSize {
+		plaintext := make([]byte, aes.BlockSize)
+		cipher.NewCBCDecrypter(block, iv).CryptBlocks(plaintext, ciphertext)
+		return plaintext, nil
+	}
+
+	n := (len(ciphertext) + aes.BlockSize - 1) / aes.BlockSize * aes.BlockSize
+	last := ciphertext[n-2*aes.BlockSize : n-aes.BlockSize] // The last block of the CBC encryption
+	partial := ciphertext[n-aes.BlockSize:]                 // The start of the block before it
+
+	// Decrypting the last block gives the zero padded last plaintext, XORed with the block before it, of which that reveals the stolen end:
+	decrypted := make([]byte, aes.BlockSize)
+	block.Decrypt(decrypted, last)
+	lastPlaintext := make([]byte, len(partial))
+	for i := range lastPlaintext {
+		lastPlaintext[i] = decrypted[i] ^ partial[i]
+	}
+
+	cbc := make([]byte, 0, n-aes.BlockSize)
+	cbc = append(cbc, ciphertext[:n-2*aes.BlockSize]...)
+	cbc = append(cbc, partial...)
+	cbc = append(cbc, decrypted[len(partial):]...)
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(cbc, cbc)
+	return append(cbc, lastPlaintext...), nil
+}
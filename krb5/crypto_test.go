@@ -0,0 +1,117 @@
+package krb5
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"testing"
+)
+
+func TestNFold(t *testing.T) {
+	// From RFC 3961, appendix A.1
+	for _, test := range []struct {
+		data     string
+		n        int
+		expected string
+	}{
+		{"012345", 8, "be072631276b1955"},
+		{"password", 7, "78a07b6caf85fa"},
+		{"Rough Consensus, and Running Code", 8, "bb6ed30870b7f0e0"},
+		{"kerberos", 8, "6b65726265726f73"},
+		{"kerberos", 16, "6b65726265726f737b9b5b2b93132b93"},
+		{"kerberos", 32, "6b65726265726f737b9b5b2b93132b935c9bdcdad95c9899c4cae4dee6d6cae4"},
+	} {
+		if result := hex.EncodeToString(nfold([]byte(test.data), test.n)); result != test.expected {
+			t.Fatal("Wrong n-fold of ", test.data, ", got: ", result)
+		}
+	}
+}
+
+func TestStringToKey(t *testing.T) {
+	// From RFC 3962, appendix B: the key derived from a password, which exercises DK.
+	for _, test := range []struct {
+		iterations int
+		eType      int32
+		expected   string
+	}{
+		{1, ETypeAES128, "42263c6e89f4fc28b8df68ee09799f15"},
+		{1, ETypeAES256, "fe697b52bc0d3ce14432ba036a92e65bbb52280990a2fa27883998d72af30161"},
+		{1200, ETypeAES256, "55a6ac740ad17b4846941051e1e8b0a7548d93b0ab30a8bc3ff16280382b8c2a"},
+	} {
+		size := 16
+		if test.eType == ETypeAES256 {
+			size = 32
+		}
+		tkey := pbkdf2([]byte("password"), []byte("ATHENA.MIT.EDUraeburn"), test.iterations, size)
+		if key := hex.EncodeToString(deriveKey(tkey, []byte("kerberos"))); key != test.expected {
+			t.Fatal("Wrong key for ", test.iterations, " iterations, got: ", key)
+		}
+	}
+}
+
+// pbkdf2 derives a key from a password with HMAC-SHA1, as in RFC 2898.
+func pbkdf2(password, salt []byte, iterations, size int) []byte {
+	var key []byte
+	for block := uint32(1); len(key) < size; block++ {
+		u := hmacSHA1(password, binary.BigEndian.AppendUint32(append([]byte(nil), salt...), block))
+		t := append([]byte(nil), u...)
+		for i := 1; i < iterations; i++ {
+			u = hmacSHA1(password, u)
+			for j := range t {
+				t[j] ^= u[j]
+			}
+		}
+		key = append(key, t...)
+	}
+	return key[:size]
+}
+
+func TestCTS(t *testing.T) {
+	// From RFC 3962, appendix B
+	key := []byte("chicken teriyaki")
+	input := []byte("I would like the General Gau's Chicken, please, and wonton soup.")
+	for _, test := range []struct {
+		length   int
+		expected string
+	}{
+		{16, ""}, // A single block is plain AES, of which we only check the roundtrip
+		{17, "c6353568f2bf8cb4d8a580362da7ff7f97"},
+		{31, "fc00783e0efdb2c1d445d4c8eff7ed2297687268d6ecccc0c07b25e25ecfe5"},
+		{32, "39312523a78662d5be7fcbcc98ebf5a897687268d6ecccc0c07b25e25ecfe584"},
+		{47, "97687268d6ecccc0c07b25e25ecfe584b3fffd940c16a18c1b5549d2f838029e39312523a78662d5be7fcbcc98ebf5"},
+		{64, "97687268d6ecccc0c07b25e25ecfe58439312523a78662d5be7fcbcc98ebf5a84807efe836ee89a526730dbc2f7bc8409dad8bbb96c4cdc03bc103e1a194bbd8"},
+	} {
+		ciphertext, err := encryptCTS(key, input[:test.length])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if test.expected != "" && hex.EncodeToString(ciphertext) != test.expected {
+			t.Fatal("Wrong ciphertext for ", test.length, " bytes, got: ", hex.EncodeToString(ciphertext))
+		}
+		plaintext, err := decryptCTS(key, ciphertext)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(plaintext, input[:test.length]) {
+			t.Fatal("Wrong plaintext for ", test.length, " bytes, got: ", plaintext)
+		}
+	}
+}
+
+func TestEncrypt(t *testing.T) {
+	key := encryptionKey{KeyType: ETypeAES256, KeyValue: bytes.Repeat([]byte{7}, 32)}
+	ciphertext, err := key.encrypt(usageAPReqAuthenticator, []byte("authenticator"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plaintext, err := key.decrypt(usageAPReqAuthenticator, ciphertext); err != nil || string(plaintext) != "authenticator" {
+		t.Fatal("Could not decrypt, got: ", plaintext, err)
+	}
+	if _, err := key.decrypt(usageAPRepEncPart, ciphertext); err != errIntegrity {
+		t.Fatal("Expected an integrity error for the wrong key usage, got: ", err)
+	}
+	ciphertext[3] ^= 1
+	if _, err := key.decrypt(usageAPReqAuthenticator, ciphertext); err != errIntegrity {
+		t.Fatal("Expected an integrity error for modified data, got: ", err)
+	}
+}
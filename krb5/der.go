@@ -0,0 +1,70 @@
+package krb5
+
+import (
+	"bytes"
+	"encoding/asn1"
+	"encoding/binary"
+	"time"
+)
+
+// Kerberos messages are DER encoded, with strings as GeneralString: encoding/asn1 reads those, but can't write them.
+// The messages we send are therefore built with these helpers, while the ones we receive are read with encoding/asn1.
+
+func derLength(n int) []byte {
+	if n < 0x80 {
+		return []byte{byte(n)}
+	}
+	var b []byte
+	for ; n > 0; n >>= 8 {
+		b = append([]byte{byte(n)}, b...)
+	}
+	return append([]byte{0x80 | byte(len(b))}, b...)
+}
+
+func derElement(tag byte, content []byte) []byte {
+	return append(append([]byte{tag}, derLength(len(content))...), content...)
+}
+
+func derSequence(elements ...[]byte) []byte {
+	return derElement(0x30, bytes.Join(elements, nil))
+}
+
+// derContext wraps an element in the explicit context-specific tag [n].
+func derContext(n int, element []byte) []byte {
+	return derElement(0xa0|byte(n), element)
+}
+
+// derApplication wraps content in the constructed tag [APPLICATION n], as used for the types of messages.
+func derApplication(n int, content ...[]byte) []byte {
+	return derElement(0x60|byte(n), bytes.Join(content, nil))
+}
+
+func derInteger(v int64) []byte {
+	b, _ := asn1.Marshal(v)
+	return b
+}
+
+func derOctetString(b []byte) []byte {
+	return derElement(0x04, b)
+}
+
+func derGeneralString(s string) []byte {
+	return derElement(asn1.TagGeneralString, []byte(s))
+}
+
+// derTime encodes a KerberosTime, which is a GeneralizedTime without fractions of seconds.
+func derTime(t time.Time) []byte {
+	return derElement(asn1.TagGeneralizedTime, []byte(t.UTC().Format("20060102150405Z")))
+}
+
+// derFlags encodes the 32 bit flags of e.g. KDCOptions as a BIT STRING.
+func derFlags(flags uint32) []byte {
+	b := make([]byte, 5) // No unused bits
+	binary.BigEndian.PutUint32(b[1:], flags)
+	return derElement(0x03, b)
+}
+
+func derOID(oid asn1.ObjectIdentifier) []byte {
+	b, _ := asn1.Marshal(oid)
+	return b
+}
@@ -0,0 +1,146 @@
+package krb5
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+var errInvalidKeytab = errors.New("krb5: invalid keytab")
+
+// Keytab holds the keys of principals, as written by ktutil or ktpass, with which a client logs in without a password.
+type Keytab struct {
+	entries []keytabEntry
+}
+
+type keytabEntry struct {
+	realm     string
+	principal principalName
+	kvno      uint32
+	key       encryptionKey
+}
+
+// LoadKeytab reads a keytab file, of which the path may be prefixed with FILE: as in KRB5_KTNAME.
+func LoadKeytab(path string) (*Keytab, error) {
+	data, err := os.ReadFile(strings.TrimPrefix(path, "FILE:"))
+	if err != nil {
+		return nil, err
+	}
+	return ParseKeytab(data)
+}
+
+// ParseKeytab reads a keytab in the file format of MIT Kerberos, which is also the one ktpass writes.
+func ParseKeytab(data []byte) (*Keytab, error) {
+	if len(data) < 2 || data[0] != 5 || data[1] < 1 || data[1] > 2 {
+		return nil, errInvalidKeytab
+	}
+	version := data[1]
+	// Version 1 was written in the byte order of the machine, which is little endian for nearly all of them.
+	var order binary.ByteOrder = binary.BigEndian
+	if version == 1 {
+		order = binary.LittleEndian
+	}
+
+	kt := &Keytab{}
+	r := &reader{data: data[2:], order: order}
+	for len(r.data) >= 4 {
+		size := int32(r.uint32())
+		if size < 0 {
+			// A hole left by a deleted entry
+			r.bytes(int(-size))
+			continue
+		}
+		e := &reader{data: r.bytes(int(size)), order: order}
+		var entry keytabEntry
+		components := int(e.uint16())
+		if version == 1 {
+			// Which included the realm
+			components--
+		}
+		entry.realm = string(e.bytes(int(e.uint16())))
+		for i := 0; i < components && e.err == nil; i++ {
+			entry.principal.NameString = append(entry.principal.NameString, string(e.bytes(int(e.uint16()))))
+		}
+		entry.principal.NameType = nameTypePrincipal
+		if version == 2 {
+			entry.principal.NameType = int32(e.uint32())
+		}
+		e.uint32() // Timestamp
+		entry.kvno = uint32(e.uint8())
+		entry.key.KeyType = int32(e.uint16())
+		entry.key.KeyValue = e.bytes(int(e.uint16()))
+		// Newer versions of MIT Kerberos add the full 32 bit version number:
+		if len(e.data) >= 4 {
+			if kvno := e.uint32(); kvno != 0 {
+				entry.kvno = kvno
+			}
+		}
+		if e.err != nil {
+			return nil, errInvalidKeytab
+		}
+		kt.entries = append(kt.entries, entry)
+	}
+	if r.err != nil || len(r.data) > 0 {
+		return nil, errInvalidKeytab
+	}
+	return kt, nil
+}
+
+// key returns the key of a principal to log in with: the one with the highest version, and of those the strongest encryption type we support.
+func (kt *Keytab) key(realm string, principal principalName) (*encryptionKey, error) {
+	var best *keytabEntry
+	for i := range kt.entries {
+		entry := &kt.entries[i]
+		if entry.realm != realm || !entry.principal.equal(principal) || entry.key.check() != nil {
+			continue
+		}
+		if best == nil || entry.kvno > best.kvno || entry.kvno == best.kvno && entry.key.KeyType == ETypeAES256 {
+			best = entry
+		}
+	}
+	if best == nil {
+		return nil, fmt.Errorf("krb5: no AES key for %v@%v in the keytab", principal, realm)
+	}
+	return &best.key, nil
+}
+
+// reader reads the fields of keytabs and credential caches, after an error it only returns zero values.
+type reader struct {
+	data  []byte
+	order binary.ByteOrder
+	err   error
+}
+
+func (r *reader) bytes(n int) []byte {
+	if r.err != nil || n < 0 || n > len(r.data) {
+		r.err = io.ErrUnexpectedEOF
+		return nil
+	}
+	b := r.data[:n]
+	r.data = r.data[n:]
+	return b
+}
+
+func (r *reader) uint8() uint8 {
+	if b := r.bytes(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (r *reader) uint16() uint16 {
+	if b := r.bytes(2); b != nil {
+		return r.order.Uint16(b)
+	}
+	return 0
+}
+
+func (r *reader) uint32() uint32 {
+	if b := r.bytes(4); b != nil {
+		return r.order.Uint32(b)
+	}
+	return 0
+}
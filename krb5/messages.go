@@ -0,0 +1,275 @@
+package krb5
+
+import (
+	"encoding/asn1"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// The messages of RFC 4120, of which the message type is also the application tag.
+const (
+	pvno = 5
+
+	msgTicket        = 1
+	msgAuthenticator = 2
+	msgEncTicketPart = 3
+	msgASReq         = 10
+	msgASRep         = 11
+	msgTGSReq        = 12
+	msgTGSRep        = 13
+	msgAPReq         = 14
+	msgAPRep         = 15
+	msgEncASRepPart  = 25
+	msgEncTGSRepPart = 26
+	msgEncAPRepPart  = 27
+	msgError         = 30
+)
+
+// Pre-authentication data types:
+const (
+	paTGSReq       = 1
+	paEncTimestamp = 2
+)
+
+// Key usages, see RFC 4120 section 7.5.1:
+const (
+	usageASReqTimestamp      = 1
+	usageTicket              = 2
+	usageASRepEncPart        = 3
+	usageTGSReqChecksum      = 6
+	usageTGSReqAuthenticator = 7
+	usageTGSRepEncPart       = 8
+	usageAPReqAuthenticator  = 11
+	usageAPRepEncPart        = 12
+)
+
+// Name types:
+const (
+	nameTypePrincipal = 1
+	nameTypeSrvInst   = 2
+)
+
+// The mutual-required flag of APOptions.
+const apOptionMutualRequired = 0x20000000
+
+// principalName is a PrincipalName: the components of a name, without the realm.
+type principalName struct {
+	NameType   int32    `asn1:"explicit,tag:0"`
+	NameString []string `asn1:"explicit,tag:1"`
+}
+
+func (p principalName) marshal() []byte {
+	var names [][]byte
+	for _, name := range p.NameString {
+		names = append(names, derGeneralString(name))
+	}
+	return derSequence(derContext(0, derInteger(int64(p.NameType))), derContext(1, derSequence(names...)))
+}
+
+func (p principalName) equal(other principalName) bool {
+	if len(p.NameString) != len(other.NameString) {
+		return false
+	}
+	for i := range p.NameString {
+		if p.NameString[i] != other.NameString[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func (p principalName) String() string {
+	return strings.Join(p.NameString, "/")
+}
+
+// encryptedData is EncryptedData: the ciphertext, along with the encryption type and version of the key.
+type encryptedData struct {
+	EType  int32  `asn1:"explicit,tag:0"`
+	KVNO   int    `asn1:"explicit,optional,tag:1"`
+	Cipher []byte `asn1:"explicit,tag:2"`
+}
+
+func (e encryptedData) marshal() []byte {
+	fields := [][]byte{derContext(0, derInteger(int64(e.EType)))}
+	if e.KVNO != 0 {
+		fields = append(fields, derContext(1, derInteger(int64(e.KVNO))))
+	}
+	return derSequence(append(fields, derContext(2, derOctetString(e.Cipher)))...)
+}
+
+// kdcRep is the KDC-REP of AS-REP and TGS-REP.
+type kdcRep struct {
+	PVNO    int           `asn1:"explicit,tag:0"`
+	MsgType int           `asn1:"explicit,tag:1"`
+	PAData  asn1.RawValue `asn1:"explicit,optional,tag:2"`
+	CRealm  string        `asn1:"explicit,tag:3"`
+	CName   principalName `asn1:"explicit,tag:4"`
+	Ticket  asn1.RawValue `asn1:"explicit,tag:5"`
+	EncPart encryptedData `asn1:"explicit,tag:6"`
+}
+
+// encKDCRepPart is the encrypted part of a KDC-REP, which holds the session key of the ticket.
+type encKDCRepPart struct {
+	Key           encryptionKey  `asn1:"explicit,tag:0"`
+	LastReq       asn1.RawValue  `asn1:"explicit,tag:1"`
+	Nonce         int64          `asn1:"explicit,tag:2"`
+	KeyExpiration time.Time      `asn1:"generalized,explicit,optional,tag:3"`
+	Flags         asn1.BitString `asn1:"explicit,tag:4"`
+	AuthTime      time.Time      `asn1:"generalized,explicit,tag:5"`
+	StartTime     time.Time      `asn1:"generalized,explicit,optional,tag:6"`
+	EndTime       time.Time      `asn1:"generalized,explicit,tag:7"`
+	RenewTill     time.Time      `asn1:"generalized,explicit,optional,tag:8"`
+	SRealm        string         `asn1:"explicit,tag:9"`
+	SName         principalName  `asn1:"explicit,tag:10"`
+	CAddr         asn1.RawValue  `asn1:"explicit,optional,tag:11"`
+	EncPAData     asn1.RawValue  `asn1:"explicit,optional,tag:12"`
+}
+
+type apRep struct {
+	PVNO    int           `asn1:"explicit,tag:0"`
+	MsgType int           `asn1:"explicit,tag:1"`
+	EncPart encryptedData `asn1:"explicit,tag:2"`
+}
+
+type encAPRepPart struct {
+	CTime     time.Time     `asn1:"generalized,explicit,tag:0"`
+	CUSec     int           `asn1:"explicit,tag:1"`
+	Subkey    asn1.RawValue `asn1:"explicit,optional,tag:2"`
+	SeqNumber int64         `asn1:"explicit,optional,tag:3"`
+}
+
+type krbError struct {
+	PVNO      int           `asn1:"explicit,tag:0"`
+	MsgType   int           `asn1:"explicit,tag:1"`
+	CTime     time.Time     `asn1:"generalized,explicit,optional,tag:2"`
+	CUSec     int           `asn1:"explicit,optional,tag:3"`
+	STime     time.Time     `asn1:"generalized,explicit,tag:4"`
+	SUSec     int           `asn1:"explicit,tag:5"`
+	ErrorCode int32         `asn1:"explicit,tag:6"`
+	CRealm    string        `asn1:"explicit,optional,tag:7"`
+	CName     principalName `asn1:"explicit,optional,tag:8"`
+	Realm     string        `asn1:"explicit,tag:9"`
+	SName     principalName `asn1:"explicit,tag:10"`
+	EText     string        `asn1:"explicit,optional,tag:11"`
+	EData     []byte        `asn1:"explicit,optional,tag:12"`
+}
+
+// Error is a KRB-ERROR, with which the KDC or a service rejects a request.
+// The codes are listed in RFC 4120 section 7.5.9, e.g. 6 when the KDC doesn't know the client, 7 when it doesn't know the service (usually a wrong SPN), 24 for a wrong key and 37 when clocks are too far apart.
+type Error struct {
+	Code int32
+	Text string
+}
+
+var errorNames = map[int32]string{
+	6:  "client not found in Kerberos database",
+	7:  "server not found in Kerberos database",
+	14: "encryption type not supported",
+	18: "client's credentials have been revoked",
+	24: "pre-authentication failed",
+	25: "additional pre-authentication required",
+	31: "integrity check on decrypted field failed",
+	32: "ticket expired",
+	37: "clock skew too great",
+	41: "message stream modified",
+	68: "wrong realm",
+}
+
+func (e *Error) Error() string {
+	msg := fmt.Sprintf("krb5: error %d", e.Code)
+	if name, ok := errorNames[e.Code]; ok {
+		msg += ": " + name
+	}
+	if e.Text != "" {
+		msg += " (" + e.Text + ")"
+	}
+	return msg
+}
+
+// unmarshalApplication reads a message with one of the given application tags into v.
+// A KRB-ERROR is returned as an *Error.
+func unmarshalApplication(data []byte, v interface{}, tags ...int) error {
+	var raw asn1.RawValue
+	rest, err := asn1.Unmarshal(data, &raw)
+	if err != nil {
+		return fmt.Errorf("krb5: invalid message: %v", err)
+	}
+	if raw.Class == asn1.ClassApplication && raw.Tag == msgError {
+		var krbErr krbError
+		if _, err := asn1.Unmarshal(raw.Bytes, &krbErr); err != nil {
+			return fmt.Errorf("krb5: invalid error message: %v", err)
+		}
+		return &Error{Code: krbErr.ErrorCode, Text: krbErr.EText}
+	}
+	for _, tag := range tags {
+		if raw.Class == asn1.ClassApplication && raw.Tag == tag && len(rest) == 0 {
+			if _, err := asn1.Unmarshal(raw.Bytes, v); err != nil {
+				return fmt.Errorf("krb5: invalid message: %v", err)
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("krb5: expected message %v, got %d", tags, raw.Tag)
+}
+
+func marshalPAData(paType int, value []byte) []byte {
+	return derSequence(derContext(1, derInteger(int64(paType))), derContext(2, derOctetString(value)))
+}
+
+// marshalKDCReq returns an AS-REQ or TGS-REQ.
+func marshalKDCReq(msgType int, paData [][]byte, body []byte) []byte {
+	fields := [][]byte{derContext(1, derInteger(pvno)), derContext(2, derInteger(int64(msgType)))}
+	if len(paData) > 0 {
+		fields = append(fields, derContext(3, derSequence(paData...)))
+	}
+	fields = append(fields, derContext(4, body))
+	return derApplication(msgType, derSequence(fields...))
+}
+
+// marshalReqBody returns a KDC-REQ-BODY, cname is only given in an AS-REQ.
+func marshalReqBody(cname *principalName, realm string, sname principalName, till time.Time, nonce uint32, eTypes []int32) []byte {
+	fields := [][]byte{derContext(0, derFlags(0))}
+	if cname != nil {
+		fields = append(fields, derContext(1, cname.marshal()))
+	}
+	var eTypeList [][]byte
+	for _, eType := range eTypes {
+		eTypeList = append(eTypeList, derInteger(int64(eType)))
+	}
+	fields = append(fields,
+		derContext(2, derGeneralString(realm)),
+		derContext(3, sname.marshal()),
+		derContext(5, derTime(till)),
+		derContext(7, derInteger(int64(nonce))),
+		derContext(8, derSequence(eTypeList...)))
+	return derSequence(fields...)
+}
+
+// marshalTimestamp returns the PA-ENC-TS-ENC with which the client pre-authenticates.
+func marshalTimestamp(t time.Time) []byte {
+	return derSequence(derContext(0, derTime(t)), derContext(1, derInteger(int64(t.Nanosecond()/1000))))
+}
+
+// marshalAuthenticator returns an Authenticator, which proves the client holds the session key of a ticket.
+// The time is sent in whole seconds and microseconds.
+func marshalAuthenticator(realm string, cname principalName, checksumType int32, checksum []byte, t time.Time, seqNumber uint32) []byte {
+	return derApplication(msgAuthenticator, derSequence(
+		derContext(0, derInteger(pvno)),
+		derContext(1, derGeneralString(realm)),
+		derContext(2, cname.marshal()),
+		derContext(3, derSequence(derContext(0, derInteger(int64(checksumType))), derContext(1, derOctetString(checksum)))),
+		derContext(4, derInteger(int64(t.Nanosecond()/1000))),
+		derContext(5, derTime(t)),
+		derContext(7, derInteger(int64(seqNumber)))))
+}
+
+// marshalAPReq returns an AP-REQ, ticket is the DER of the Ticket.
+func marshalAPReq(options uint32, ticket []byte, authenticator encryptedData) []byte {
+	return derApplication(msgAPReq, derSequence(
+		derContext(0, derInteger(pvno)),
+		derContext(1, derInteger(msgAPReq)),
+		derContext(2, derFlags(options)),
+		derContext(3, ticket),
+		derContext(4, authenticator.marshal())))
+}
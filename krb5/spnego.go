@@ -0,0 +1,147 @@
+package krb5
+
+import (
+	"encoding/asn1"
+	"encoding/binary"
+	"errors"
+	"time"
+)
+
+var (
+	oidSPNEGO = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 2}
+	oidKRB5   = asn1.ObjectIdentifier{1, 2, 840, 113554, 1, 2, 2}
+	// The OID Windows used for Kerberos by mistake, with which it may still answer.
+	oidMSKRB5 = asn1.ObjectIdentifier{1, 2, 840, 48018, 1, 2, 2}
+)
+
+// The token IDs of the Kerberos mechanism of GSS-API (RFC 4121):
+const (
+	tokenAPReq = 0x0100
+	tokenAPRep = 0x0200
+	tokenError = 0x0300
+)
+
+// The checksum type of the authenticator with which a context is initiated, and the context flags in it.
+const (
+	gssChecksumType = 0x8003
+	gssMutual       = 0x02
+	gssConf         = 0x10
+	gssInteg        = 0x20
+)
+
+// The state in a NegTokenResp in which the service rejected us.
+const negStateReject = 2
+
+var errInvalidToken = errors.New("krb5: invalid token from the service")
+
+type negTokenResp struct {
+	NegState      asn1.Enumerated       `asn1:"explicit,optional,tag:0"`
+	SupportedMech asn1.ObjectIdentifier `asn1:"explicit,optional,tag:1"`
+	ResponseToken []byte                `asn1:"explicit,optional,tag:2"`
+	MechListMIC   []byte                `asn1:"explicit,optional,tag:3"`
+}
+
+// SecContext is an authentication to a service in progress, see Client.InitSecContext.
+type SecContext struct {
+	// The session key of the ticket
+	key encryptionKey
+	// The time in the authenticator, which the service sends back
+	ctime time.Time
+}
+
+// InitSecContext starts authenticating to the service with the given SPN, e.g. MSSQLSvc/db.example.com:1433, optionally followed by @ and the realm of the service.
+// It returns the SPNEGO token to send to the service. We ask for mutual authentication, so the service proves it could read the ticket in its answer, which is passed to Continue.
+func (c *Client) InitSecContext(spn string) (*SecContext, []byte, error) {
+	cred, err := c.serviceTicket(spn)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// The checksum of RFC 4121 holds the channel bindings, of which we have none, and the flags of the context:
+	checksum := make([]byte, 24)
+	binary.LittleEndian.PutUint32(checksum, 16)
+	binary.LittleEndian.PutUint32(checksum[20:], gssMutual|gssConf|gssInteg)
+	seqNumber, err := newNonce()
+	if err != nil {
+		return nil, nil, err
+	}
+	now := time.Now().UTC().Truncate(time.Microsecond)
+	authenticator, err := cred.key.encrypt(usageAPReqAuthenticator, marshalAuthenticator(c.realm, c.cname, gssChecksumType, checksum, now, seqNumber))
+	if err != nil {
+		return nil, nil, err
+	}
+	apReq := marshalAPReq(apOptionMutualRequired, cred.ticket, encryptedData{EType: cred.key.KeyType, Cipher: authenticator})
+
+	// A NegTokenInit, which offers only Kerberos:
+	token := derApplication(0, derOID(oidSPNEGO), derContext(0, derSequence(
+		derContext(0, derSequence(derOID(oidKRB5))),
+		derContext(2, derOctetString(gssWrap(tokenAPReq, apReq))))))
+	return &SecContext{key: cred.key, ctime: now}, token, nil
+}
+
+// Continue checks the answer of the service to the token of InitSecContext, which completes the authentication.
+func (s *SecContext) Continue(token []byte) error {
+	var raw asn1.RawValue
+	if _, err := asn1.Unmarshal(token, &raw); err != nil || raw.Class != asn1.ClassContextSpecific || raw.Tag != 1 {
+		return errInvalidToken
+	}
+	var resp negTokenResp
+	if _, err := asn1.Unmarshal(raw.Bytes, &resp); err != nil {
+		return errInvalidToken
+	}
+	if len(resp.ResponseToken) == 0 {
+		if resp.NegState == negStateReject {
+			return errors.New("krb5: the service rejected the authentication")
+		}
+		return errors.New("krb5: the service did not authenticate itself")
+	}
+
+	tokenID, message, err := gssUnwrap(resp.ResponseToken)
+	if err != nil {
+		return err
+	}
+	switch tokenID {
+	case tokenError:
+		// Which unmarshalApplication returns as an *Error
+		return unmarshalApplication(message, nil)
+	case tokenAPRep:
+	default:
+		return errInvalidToken
+	}
+
+	var rep apRep
+	if err = unmarshalApplication(message, &rep, msgAPRep); err != nil {
+		return err
+	}
+	plaintext, err := s.key.decrypt(usageAPRepEncPart, rep.EncPart.Cipher)
+	if err != nil {
+		return err
+	}
+	var part encAPRepPart
+	if err = unmarshalApplication(plaintext, &part, msgEncAPRepPart); err != nil {
+		return err
+	}
+	if !part.CTime.Equal(s.ctime.Truncate(time.Second)) || part.CUSec != s.ctime.Nanosecond()/1000 {
+		return errors.New("krb5: the answer of the service does not match our authenticator")
+	}
+	return nil
+}
+
+// gssWrap wraps a Kerberos message in the token format of GSS-API.
+func gssWrap(tokenID uint16, message []byte) []byte {
+	return derApplication(0, derOID(oidKRB5), binary.BigEndian.AppendUint16(nil, tokenID), message)
+}
+
+// gssUnwrap is the reverse of gssWrap.
+func gssUnwrap(token []byte) (uint16, []byte, error) {
+	var raw asn1.RawValue
+	if _, err := asn1.Unmarshal(token, &raw); err != nil || raw.Class != asn1.ClassApplication || raw.Tag != 0 {
+		return 0, nil, errInvalidToken
+	}
+	var oid asn1.ObjectIdentifier
+	rest, err := asn1.Unmarshal(raw.Bytes, &oid)
+	if err != nil || !(oid.Equal(oidKRB5) || oid.Equal(oidMSKRB5)) || len(rest) < 2 {
+		return 0, nil, errInvalidToken
+	}
+	return binary.BigEndian.Uint16(rest), rest[2:], nil
+}
@@ -9,30 +9,34 @@ type MockServer struct {
 	responses       [][]byte
 	t               *testing.T
 	currentResponse int
+	// How much of the current response has been read.
+	offset int
+
+	// Everything written by the client, one entry per call to Write.
+	Written [][]byte
 }
 
+// Read returns the responses as a stream, as a socket would: a read can return part of a response, but never more than one.
 func (m *MockServer) Read(p []byte) (n int, err error) {
 	m.t.Logf("Mockread #%d", m.currentResponse)
-	if m.currentResponse > len(m.responses) {
+	if m.currentResponse >= len(m.responses) {
 		return 0, io.EOF
 	}
-	response := m.responses[m.currentResponse]
+	response := m.responses[m.currentResponse][m.offset:]
 	m.t.Log(response)
-	destLength := len(p)
-	srcLength := len(response)
-	if destLength > srcLength {
-		n = srcLength
-	} else {
-		n = destLength
+	n = copy(p, response)
+	m.offset += n
+	if n == len(response) {
+		m.currentResponse++
+		m.offset = 0
 	}
-	copy(p, response[0:n])
-	m.currentResponse++
 
 	return
 }
 
 func (m *MockServer) Write(p []byte) (n int, err error) {
 	m.t.Log(p)
+	m.Written = append(m.Written, append([]byte(nil), p...))
 	return len(p), nil
 }
 
@@ -41,5 +45,5 @@ func (m *MockServer) Close() error {
 }
 
 func MakeMockServer(responses [][]byte, t *testing.T) *MockServer {
-	return &MockServer{responses, t, 0}
+	return &MockServer{responses: responses, t: t}
 }
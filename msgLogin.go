@@ -4,31 +4,46 @@ import (
 	"bytes"
 	"encoding/binary"
 	"errors"
+	"io"
 	"os" // For hostname
 
 	utf16c "github.com/Grovespaz/go-tds/utf16"
 )
 
 func (c *Conn) login() ([]byte, error) {
+	var err error
+	if c.auth, err = c.newIntegratedAuth(); err != nil {
+		return nil, err
+	}
+
 	loginPacket, err := c.makeLoginPacket()
 	if err != nil {
 		return nil, err
 	}
 
-	if c.cfg.verboseLog {
-		errLog.Printf("Trying to login with username: %v, password: %v and default DB: %v", c.cfg.user, c.cfg.password, c.cfg.dbname)
+	if c.cfg.Verbose {
+		errLog.Printf("Trying to login with username: %v, password: %v and default DB: %v", c.cfg.User, c.cfg.Password, c.cfg.Database)
 		//errLog.Printf("Request: % X\n", loginPacket)
 	}
 
-	loginResult, sqlerr, err := c.sendMessage(ptyLogin, loginPacket)
+	loginResult, err := c.sendMessage(ptyLogin, loginPacket)
 
-	if err != nil {
-		return nil, err
+	// With integrated security the server answers with SSPI tokens, until we're authenticated:
+	for err == nil && c.auth != nil && len(*loginResult) == 1 && tokenDefinition((*loginResult)[0][0]) == sspi {
+		var token, rest []byte
+		if token, rest, err = c.answerSSPI((*loginResult)[0]); err != nil {
+			return nil, err
+		}
+		if token == nil {
+			// Nothing left to answer, as with Kerberos: the rest of the login response follows the token.
+			(*loginResult)[0] = rest
+			break
+		}
+		loginResult, err = c.sendMessage(ptySSPIMessage, token)
 	}
 
-	if len(*sqlerr) > 0 {
-		// For now:
-		return nil, (*sqlerr)[0]
+	if err != nil {
+		return nil, err
 	}
 
 	if len(*loginResult) == 0 {
@@ -41,7 +56,7 @@ func (c *Conn) login() ([]byte, error) {
 
 	loginResultData := (*loginResult)[0] //[8:]
 
-	if c.cfg.verboseLog {
+	if c.cfg.Verbose {
 		errLog.Printf("Request: % x\n", loginPacket)
 		errLog.Printf("Response: % x\n", loginResultData)
 	}
@@ -56,7 +71,7 @@ func (c *Conn) makeLoginPacket() ([]byte, error) {
 	b.Write([]byte{0, 0, 0, 0}) // Length bytes, to be set later
 
 	binary.Write(b, binary.BigEndian, c.tdsVersion)
-	binary.Write(b, binary.LittleEndian, c.cfg.maxPacketSize)
+	binary.Write(b, binary.LittleEndian, c.cfg.PacketSize)
 	binary.Write(b, binary.LittleEndian, driverVersion)
 	binary.Write(b, binary.LittleEndian, c.clientPID)
 	binary.Write(b, binary.LittleEndian, c.connectionID)
@@ -79,7 +94,7 @@ func (c *Conn) makeLoginPacket() ([]byte, error) {
 		false,
 		false,
 		false,
-		c.cfg.integratedSecurity)
+		c.auth != nil) // Integrated security
 	b.WriteByte(optionFlags2)
 
 	if (c.tdsVersion < TDS72) && (c.useOLEDB) {
@@ -93,20 +108,22 @@ func (c *Conn) makeLoginPacket() ([]byte, error) {
 		false,
 		false,
 		c.useOLEDB,
-		c.cfg.readOnly,
+		c.cfg.ReadOnly,
 		false,
 		false)
 
 	b.WriteByte(typeFlags)
 
+	featureExt := c.makeFeatureExt()
+
 	if c.tdsVersion < TDS72 {
 		b.WriteByte(0) // Was reserved < TDS7.2
 	} else {
-		optionFlags3 := makeByteFromBits(c.cfg.changePass,
+		optionFlags3 := makeByteFromBits(c.cfg.NewPassword != "" && c.auth == nil, // Change the password, only of SQL Server logins
 			false, //for now, Determines if Yukon binary xml is sent when sending XML
-			c.cfg.userInstance,
+			c.cfg.UserInstance,
 			false, //unknown collation handling pre 7.3
-			false, //Do we use the extension-section introduced in 7.4? No we don't cause we don't offer connection resuming.
+			featureExt != nil, //Do we use the extension-section introduced in 7.4? Only for the feature extensions, such as session recovery.
 			false, //Unused from here
 			false,
 			false)
@@ -116,46 +133,63 @@ func (c *Conn) makeLoginPacket() ([]byte, error) {
 	binary.Write(b, binary.LittleEndian, c.cfg.timezone)
 	binary.Write(b, binary.LittleEndian, c.cfg.lcid)
 
-	hostname, err := os.Hostname()
-	if err != nil {
-		// Not strictly necessary, we can send a nil value but meh.
-		hostname = "Unknown-go-tds-client"
+	hostname := c.hostname()
+
+	// With integrated security, the credentials are in the SSPI data instead of the user and password:
+	user, password, newPassword := c.cfg.User, c.cfg.Password, c.cfg.NewPassword
+	var sspiData []byte
+	if c.auth != nil {
+		user, password, newPassword = "", "", ""
+		var err error
+		if sspiData, err = c.auth.initialToken(); err != nil {
+			return nil, err
+		}
 	}
 
 	var appname string
-	if c.cfg.appname != "" {
-		appname = c.cfg.appname
+	if c.cfg.AppName != "" {
+		appname = c.cfg.AppName
 	} else {
 		appname = "go-tds" //os.Args[0] // Should be executable name, at least in *nix
 	}
 
-	var servername string
+	// Only set when routed, as the server we are routed to may need it to tell where we are going:
+	servername := c.cfg.serverName
 	var clientID []byte // 6-byte, apparently created using MAC (NIC) address. No idea how though, so for now:
 	clientID = []byte{0xfa, 0xca, 0xde, 0xfa, 0xca, 0xde}
 
 	// Variable portion:
 	varBlock := []varData{
 		varData{strData: hostname},
-		//According to MS specs this should be: varData{strData: ensureBrackets(c.cfg.user)},
+		//According to MS specs this should be: varData{strData: ensureBrackets(c.cfg.User)},
 		// But in reality they do:
-		varData{strData: c.cfg.user},
-		varData{data: encodePassword(c.cfg.password), halfLength: true}, //strData or data?
+		varData{strData: user},
+		varData{data: encodePassword(password), halfLength: true}, //strData or data?
 		varData{strData: appname},
 		varData{strData: servername},
-		varData{}, // Extension block which we do not use at the moment
+		varData{}, // Extension block, which holds the offset of the FeatureExt if there is one. Set below.
 		varData{strData: driverName},
-		varData{data: []byte(c.cfg.preferredLanguage)},
-		// Again, according to MS specs this should be: varData{strData: ensureBrackets(c.cfg.dbname)},
+		varData{strData: c.cfg.Language},
+		// Again, according to MS specs this should be: varData{strData: ensureBrackets(c.cfg.Database)},
 		// But in reality they do:
-		varData{strData: c.cfg.dbname},
+		varData{strData: c.cfg.Database},
 		varData{data: clientID, raw: true},
-		varData{}, // SSPI data, we'll look at this later...
-		varData{strData: c.cfg.attachDB},
-		varData{data: []byte(c.cfg.newPass), halfLength: true}, //strData or data?
-		varData{data: []byte{0, 0, 0, 0}, raw: true},           //SSPI long length.
+		varData{data: sspiData},
+		varData{strData: c.cfg.AttachDBFilename},
+		varData{data: encodePassword(newPassword), halfLength: true},
+		varData{data: []byte{0, 0, 0, 0}, raw: true}, //SSPI long length.
 	}
 
-	b.Write(makeVariableDataPortion(varBlock, b.Len()))
+	varPortion := makeVariableDataPortion(varBlock, b.Len())
+	if featureExt != nil {
+		// The FeatureExt follows all other data, of which we only know the length once it's built:
+		varBlock[5] = varData{data: make([]byte, 4)}
+		varPortion = makeVariableDataPortion(varBlock, b.Len())
+		binary.LittleEndian.PutUint32(varBlock[5].data, uint32(b.Len()+len(varPortion)))
+		varPortion = makeVariableDataPortion(varBlock, b.Len())
+	}
+	b.Write(varPortion)
+	b.Write(featureExt)
 
 	// Have to write length as first 4 bytes:
 	// Even though we'll never exceed 2 bytes...
@@ -167,6 +201,93 @@ func (c *Conn) makeLoginPacket() ([]byte, error) {
 	return result, nil
 }
 
+// Feature extensions of the login, only since TDS 7.4:
+const (
+	featureSessionRecovery = 0x01
+	featureFedAuth         = 0x02
+	featureTerminator      = 0xFF
+)
+
+// makeFeatureExt returns the FeatureExt block of the login with the feature extensions we request, nil if there are none.
+func (c *Conn) makeFeatureExt() []byte {
+	b := new(bytes.Buffer)
+	writeFeature := func(feature byte, data []byte) {
+		b.WriteByte(feature)
+		binary.Write(b, binary.LittleEndian, uint32(len(data)))
+		b.Write(data)
+	}
+	if c.recovery != nil {
+		writeFeature(featureSessionRecovery, c.recovery.featureData())
+	}
+	if c.fedAuth != nil {
+		writeFeature(featureFedAuth, c.fedAuth.featureData())
+	}
+	if b.Len() == 0 {
+		return nil
+	}
+	b.WriteByte(featureTerminator)
+	return b.Bytes()
+}
+
+// readFeatureExtAck reads a FEATUREEXTACK token, whose token byte was just read, in which the server acknowledges the feature extensions it supports.
+func (c *Conn) readFeatureExtAck(buf *bytes.Buffer) error {
+	for {
+		feature, err := buf.ReadByte()
+		if err != nil {
+			return err
+		}
+		if feature == featureTerminator {
+			return nil
+		}
+		var length uint32
+		if err = binary.Read(buf, binary.LittleEndian, &length); err != nil {
+			return err
+		}
+		data, err := readBytes(buf, int(length))
+		if err != nil {
+			return err
+		}
+		switch feature {
+		case featureSessionRecovery:
+			if c.recovery != nil {
+				if err = c.recovery.readFeatureAck(data); err != nil {
+					return err
+				}
+			}
+		case featureFedAuth:
+			if c.fedAuth != nil {
+				c.fedAuth.acknowledged = true
+			}
+		}
+	}
+}
+
+// hostname returns the name of the client machine, as sent in the login.
+func (c *Conn) hostname() string {
+	if c.cfg.WorkstationID != "" {
+		return c.cfg.WorkstationID
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		// Not strictly necessary, we can send a nil value but meh.
+		hostname = "Unknown-go-tds-client"
+	}
+	return hostname
+}
+
+// answerSSPI returns our answer to the SSPI token the server sent during login, nil if it needs none, along with the data following the token.
+func (c *Conn) answerSSPI(data []byte) ([]byte, []byte, error) {
+	if len(data) < 3 {
+		return nil, nil, c.protocolError(sspi, 0, io.ErrUnexpectedEOF)
+	}
+	length := 3 + int(binary.LittleEndian.Uint16(data[1:3]))
+	if len(data) < length {
+		return nil, nil, c.protocolError(sspi, 0, io.ErrUnexpectedEOF)
+	}
+	token, err := c.auth.nextToken(data[3:length])
+	return token, data[length:], err
+}
+
 // The second part of the LOGIN message contains all data of variable length (mostly strings)
 // The result consists of two parts, a header indicating all offsets and lengths, and the actual data following that.
 // For some reason I can't fathom, smack in the middle of the header lies a 6(!)-byte field for the ClientID, which completely breaks any sleek generic function one would want to write for this. At the end of the header is another field in case the SSPI-length was larger than uint16. This field is a uint32 and can be used as a replacement length.
@@ -263,9 +384,6 @@ func ensureBrackets(value string) string {
 		return value
 	}
 
-	if (value[0] != '[') && (value[len(value)-1] != ']') {
-		return "[" + value + "]"
-	}
-
-	panic("Incorrect format specified for value: " + value)
+	// Otherwise quote it, which also takes care of values with only one of the brackets:
+	return quoteIdentifier(value)
 }
@@ -1,24 +1,27 @@
 package gotds
 
 import (
+	"encoding/binary"
 	"errors"
 )
 
 type pl_option_token byte
 
 const (
-	VERSION    pl_option_token = 0x00
-	ENCRYPTION pl_option_token = 0x01
-	INSTOPT                    = 0x02
-	THREADID                   = 0x03
-	MARS                       = 0x04
-	TRACEID                    = 0x05
-	TERMINATOR                 = 0xFF
+	VERSION         pl_option_token = 0x00
+	ENCRYPTION      pl_option_token = 0x01
+	INSTOPT                         = 0x02
+	THREADID                        = 0x03
+	MARS                            = 0x04
+	TRACEID                         = 0x05
+	FEDAUTHREQUIRED                 = 0x06 // Since TDS 7.4
+	NONCEOPT                        = 0x08 // Since TDS 7.4
+	TERMINATOR                      = 0xFF
 )
 
 func (c *Conn) sendPreLogin() ([]byte, error) {
-	preLoginPacket := makePreLoginPacket(0, encryptNotSupported, "", 0, false, [...]byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0})
-	preLoginResult, sqlerr, err := c.sendMessage(ptyPreLogin, preLoginPacket)
+	preLoginPacket := makePreLoginPacket(0, encryptNotSupported, "", 0, false, [...]byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}, c.fedAuth != nil)
+	preLoginResult, err := c.sendMessage(ptyPreLogin, preLoginPacket)
 
 	if err != nil {
 		return nil, err
@@ -32,13 +35,19 @@ func (c *Conn) sendPreLogin() ([]byte, error) {
 		return nil, errors.New("More than 1 result in the preLogin response")
 	}
 
-	if len(*sqlerr) > 0 {
-		return nil, (*sqlerr)[0]
-	}
-
 	preLoginResultData := (*preLoginResult)[0] //[8:]
+	if tokenDefinition(preLoginResultData[0]) == errorToken {
+		// The server refused the pre-login, there are no options in that case:
+		var sqlerrs []SQLError
+		if _, err = c.processTokens(preLoginResultData, &sqlerrs); err != nil {
+			return nil, err
+		}
+		if err = c.checkErrors(sqlerrs); err != nil {
+			return nil, err
+		}
+	}
 
-	if c.cfg.verboseLog {
+	if c.cfg.Verbose {
 		errLog.Printf("Request: %v\n", preLoginPacket)
 		errLog.Printf("Response: %v\n", preLoginResultData)
 	}
@@ -53,17 +62,25 @@ type preLoginOption struct {
 	offset     uint16 //TODO: Find out max length (USHORT, big endian), fill in proper go datatype
 }
 
-func makePreLoginPacket(version int, encryption encryptionType, instanceName string, ThreadID int, mars bool, traceID [20]byte) []byte {
-	// Memory allocation might be better controlled with a pool
-	packetData := make([]byte, 0, 100)
-	preLoginData := make([]byte, 0, 100)
-
+func makePreLoginPacket(version int, encryption encryptionType, instanceName string, ThreadID int, mars bool, traceID [20]byte, fedAuth bool) []byte {
 	options := preLoginOptions{
 		preLoginOption{option: VERSION, data: []byte{0x09, 0x0, 0x0, 0x0, 0x0, 0x0}},
 		preLoginOption{option: ENCRYPTION, data: []byte{byte(encryption)}},
 		preLoginOption{option: MARS, data: []byte{0}},
 		//preLoginOption{option: TERMINATOR, data: []byte()},
 	}
+	if fedAuth {
+		// We log in with an access token, see Config.accessTokenProvider
+		options = append(options, preLoginOption{option: FEDAUTHREQUIRED, data: []byte{0x01}})
+	}
+	return options.marshal()
+}
+
+// marshal returns the pre-login message with the options: first the option tokens with the offsets and lengths of their data, then the data itself.
+func (options preLoginOptions) marshal() []byte {
+	// Memory allocation might be better controlled with a pool
+	packetData := make([]byte, 0, 100)
+	preLoginData := make([]byte, 0, 100)
 
 	startingOffset := (len(options) * (1 + 2 + 2)) + 1 // 1 for pl_option_token, 2 twice for PL_OFFSET and PL_OPTION_LENGTH. +1 for terminator
 
@@ -77,3 +94,25 @@ func makePreLoginPacket(version int, encryption encryptionType, instanceName str
 	packetData = append(packetData, preLoginData...)
 	return packetData
 }
+
+// readPreLoginOptions returns the data of the options in a pre-login message, by option token.
+func readPreLoginOptions(data []byte) (map[pl_option_token][]byte, error) {
+	options := make(map[pl_option_token][]byte)
+	for pos := 0; ; pos += 5 {
+		if pos >= len(data) {
+			return nil, errors.New("Invalid preLogin response")
+		}
+		if data[pos] == TERMINATOR {
+			return options, nil
+		}
+		if pos+5 > len(data) {
+			return nil, errors.New("Invalid preLogin response")
+		}
+		offset := int(binary.BigEndian.Uint16(data[pos+1:]))
+		length := int(binary.BigEndian.Uint16(data[pos+3:]))
+		if offset+length > len(data) {
+			return nil, errors.New("Invalid preLogin response")
+		}
+		options[pl_option_token(data[pos])] = data[offset : offset+length]
+	}
+}
@@ -0,0 +1,80 @@
+package gotds
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+// Some system procedures can be called by ID instead of by name:
+const (
+	procIDExecuteSQL uint16 = 10 // sp_executesql
+)
+
+// rpcParamName returns the name of the parameter that replaces the placeholder of the argument at argIndex.
+func rpcParamName(argIndex int) string {
+	return fmt.Sprintf("@p%d", argIndex+1)
+}
+
+// makeRPCPacket builds an RPC request calling sp_executesql with the query.
+// Only TVPs are sent as separate parameters, all other arguments are still escaped and embedded in the statement itself.
+func (c *Conn) makeRPCPacket(query string, args []driver.Value) ([]byte, error) {
+	b := new(bytes.Buffer)
+
+	// TODO(gv): Support proper transactions here
+	transactionHeader := []byte{0, 0, 0, 0, 0, 0, 0, 0}
+	outstandingRequests := 1
+
+	writeCommonHeader(b, transactionHeader, outstandingRequests)
+
+	// Call by ID:
+	binary.Write(b, binary.LittleEndian, uint16(0xFFFF))
+	binary.Write(b, binary.LittleEndian, procIDExecuteSQL)
+	// Option flags:
+	binary.Write(b, binary.LittleEndian, uint16(0))
+
+	statement, err := escapeParameters(query, args, c.cfg.Placeholder)
+	if err != nil {
+		return nil, err
+	}
+
+	var declarations []string
+	for i, arg := range args {
+		if tvp, isTVP := arg.(TVP); isTVP {
+			declarations = append(declarations, rpcParamName(i)+" "+tvp.TypeName+" READONLY")
+		}
+	}
+
+	if err = writeRPCParam(b, "@stmt", statement); err != nil {
+		return nil, err
+	}
+	if err = writeRPCParam(b, "@params", strings.Join(declarations, ", ")); err != nil {
+		return nil, err
+	}
+
+	for i, arg := range args {
+		if tvp, isTVP := arg.(TVP); isTVP {
+			writeB_VarChar(b, rpcParamName(i))
+			b.WriteByte(0) // Status flags: input parameter
+			if err = writeTVP(b, tvp); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return b.Bytes(), nil
+}
+
+// writeRPCParam writes a regular input parameter of an RPC request.
+func writeRPCParam(b *bytes.Buffer, name string, value driver.Value) error {
+	info, err := typeInfoForValue(value)
+	if err != nil {
+		return err
+	}
+	writeB_VarChar(b, name)
+	b.WriteByte(0) // Status flags: input parameter
+	writeTypeInfo(b, info)
+	return writeValue(b, info, value)
+}
@@ -2,65 +2,151 @@ package gotds
 
 import (
 	"bytes"
+	"context"
 	"database/sql/driver"
+	"errors"
+	"io"
 )
 
 func (c *Conn) Exec(query string, args []driver.Value) (driver.Result, error) {
-	if c.cfg.verboseLog {
+	if c.State == Error {
+		// A fatal error closed the connection
+		return nil, driver.ErrBadConn
+	}
+	if err := c.recoverSession(); err != nil {
+		return nil, err
+	}
+	if c.cfg.Verbose {
 		errLog.Printf("Executing query: %v", query)
 	}
 
-	queryPacket, err := c.makeSQLBatchPacket(query, args)
+	msgType, queryPacket, err := c.makeQueryPacket(query, args)
 	if err != nil {
 		return nil, err
 	}
 
-	queryResultData, sqlerr, err := c.sendMessage(ptySQLBatch, queryPacket)
+	queryResultData, err := c.sendMessage(msgType, queryPacket)
 
 	if err != nil {
 		return nil, err
 	}
 
-	if len(*sqlerr) > 0 {
-		// For now:
-		return nil, (*sqlerr)[0]
-	}
-
-	if c.cfg.verboseLog {
+	if c.cfg.Verbose {
 		errLog.Printf("Request: % x\n", queryPacket)
 		errLog.Printf("Response: % x\n", queryResultData)
 	}
 
+	// Keep track of changes to the session, e.g. by USE or SET statements:
+	if err = c.skipResults(bytes.Join(*queryResultData, nil)); err != nil {
+		return nil, err
+	}
+
 	return nil, nil
 }
 
+// skipResults processes the whole response to a batch of which the result sets aren't needed, as for Exec.
+// It returns the errors of all statements as a ServerError, whether they came before, in between or after result sets.
+func (c *Conn) skipResults(data []byte) error {
+	var sqlerrs []SQLError
+	for {
+		rest, err := c.processTokens(data, &sqlerrs)
+		if err != nil {
+			return err
+		}
+		if len(rest) == 0 || tokenDefinition(rest[0]) != colMetaData {
+			// Anything else, such as the RETURNVALUE of an RPC, isn't needed either
+			break
+		}
+
+		rows, err := c.parseResult(rest)
+		if err != nil {
+			return err
+		}
+		dest := make([]driver.Value, len(rows.columnTypes))
+		for err == nil {
+			err = rows.Next(dest)
+		}
+		var serverErr ServerError
+		if errors.As(err, &serverErr) {
+			sqlerrs = append(sqlerrs, serverErr.Errors...)
+		} else if err != io.EOF {
+			return err
+		}
+		data = rows.buf.Bytes()
+	}
+	return c.checkErrors(sqlerrs)
+}
+
 func (c *Conn) Query(query string, args []driver.Value) (driver.Rows, error) {
-	if c.cfg.verboseLog {
+	if c.retry == nil || !c.retry.RetryQueries {
+		return c.query(query, args)
+	}
+
+	var rows driver.Rows
+	var inTransaction bool
+	err := c.retry.do(context.Background(), func() error {
+		// A deadlock rolls back the whole transaction the query ran in, so retrying just the query would run it without the rest.
+		inTransaction = c.inTransaction
+		var err error
+		rows, err = c.query(query, args)
+		return err
+	}, func(err error) bool {
+		return !inTransaction && c.State != Error && isTransientServerError(err)
+	})
+	return rows, err
+}
+
+func (c *Conn) query(query string, args []driver.Value) (driver.Rows, error) {
+	if c.State == Error {
+		// A fatal error closed the connection
+		return nil, driver.ErrBadConn
+	}
+	if err := c.recoverSession(); err != nil {
+		return nil, err
+	}
+	if c.cfg.Verbose {
 		errLog.Printf("Executing query: %v", query)
 	}
 
-	queryPacket, err := c.makeSQLBatchPacket(query, args)
+	msgType, queryPacket, err := c.makeQueryPacket(query, args)
 	if err != nil {
 		return nil, err
 	}
 
-	queryResultData, sqlerr, err := c.sendMessage(ptySQLBatch, queryPacket)
+	queryResultData, err := c.sendMessage(msgType, queryPacket)
 
 	if err != nil {
 		return nil, err
 	}
 
-	if len(*sqlerr) > 0 {
-		// For now:
-		return nil, (*sqlerr)[0]
-	}
-
-	if c.cfg.verboseLog {
+	if c.cfg.Verbose {
 		errLog.Printf("Request: % x\n", queryPacket)
 		errLog.Printf("Response: % x\n", queryResultData)
 	}
 
-	return c.parseResult((*queryResultData)[0])
+	// Statements before the result set, e.g. an UPDATE or PRINT, leave their tokens in front of its COLMETADATA:
+	var sqlerrs []SQLError
+	rest, err := c.processTokens(bytes.Join(*queryResultData, nil), &sqlerrs)
+	if err != nil {
+		return nil, err
+	}
+	if err = c.checkErrors(sqlerrs); err != nil {
+		return nil, err
+	}
+	return c.parseResult(rest)
+}
+
+// makeQueryPacket builds the message to execute a query with.
+// Normally parameters are escaped and embedded in a SQL batch, but TVPs can only be sent as RPC parameters.
+func (c *Conn) makeQueryPacket(query string, args []driver.Value) (packetType, []byte, error) {
+	for _, arg := range args {
+		if _, isTVP := arg.(TVP); isTVP {
+			packet, err := c.makeRPCPacket(query, args)
+			return ptyRPC, packet, err
+		}
+	}
+	packet, err := c.makeSQLBatchPacket(query, args)
+	return ptySQLBatch, packet, err
 }
 
 func (c *Conn) makeSQLBatchPacket(query string, args []driver.Value) ([]byte, error) {
@@ -73,7 +159,7 @@ func (c *Conn) makeSQLBatchPacket(query string, args []driver.Value) ([]byte, er
 
 	writeCommonHeader(b, transactionHeader, outstandingRequests)
 
-	escapedQuery, err := escapeParameters(query, args, c.cfg.placeholder)
+	escapedQuery, err := escapeParameters(query, args, c.cfg.Placeholder)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,189 @@
+// Package ntlm implements the client side of NTLMv2 authentication (MS-NLMP), as used for integrated security by SQL Server.
+// It only authenticates: none of the signing and sealing of messages is implemented, as TDS doesn't use it.
+package ntlm
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"strings"
+	"time"
+
+	utf16c "github.com/Grovespaz/go-tds/utf16"
+)
+
+var signature = []byte("NTLMSSP\x00")
+
+// Message types:
+const (
+	negotiateMessage    = 1
+	challengeMessage    = 2
+	authenticateMessage = 3
+)
+
+// Negotiate flags, of which we only use a few:
+const (
+	negotiateUnicode                 = 0x00000001
+	requestTarget                    = 0x00000004
+	negotiateNTLM                    = 0x00000200
+	negotiateAlwaysSign              = 0x00008000
+	negotiateExtendedSessionSecurity = 0x00080000
+	negotiateTargetInfo              = 0x00800000
+	negotiate128                     = 0x20000000
+	negotiate56                      = 0x80000000
+)
+
+const negotiateFlags = negotiateUnicode | requestTarget | negotiateNTLM | negotiateAlwaysSign |
+	negotiateExtendedSessionSecurity | negotiateTargetInfo | negotiate128 | negotiate56
+
+// The AvId of the timestamp in the target info of the CHALLENGE_MESSAGE.
+const msvAvTimestamp = 7
+
+var ErrInvalidChallenge = errors.New("Invalid NTLM challenge")
+
+// Negotiate returns the NEGOTIATE_MESSAGE, which starts the authentication.
+func Negotiate() []byte {
+	b := new(bytes.Buffer)
+	b.Write(signature)
+	binary.Write(b, binary.LittleEndian, uint32(negotiateMessage))
+	binary.Write(b, binary.LittleEndian, uint32(negotiateFlags))
+	// The domain and workstation aren't supplied, so both are empty and point at the end of the message:
+	for i := 0; i < 2; i++ {
+		writeField(b, 0, 32)
+	}
+	return b.Bytes()
+}
+
+// Authenticate returns the AUTHENTICATE_MESSAGE in answer to the CHALLENGE_MESSAGE of the server.
+// The user is given without the domain, workstation is the name of the client machine.
+func Authenticate(challenge []byte, domain, user, password, workstation string) ([]byte, error) {
+	clientChallenge := make([]byte, 8)
+	if _, err := rand.Read(clientChallenge); err != nil {
+		return nil, err
+	}
+	return authenticate(challenge, domain, user, password, workstation, clientChallenge, fileTime(time.Now()))
+}
+
+// authenticate is Authenticate with the random client challenge and the current time as a FILETIME given, for tests.
+func authenticate(challenge []byte, domain, user, password, workstation string, clientChallenge, now []byte) ([]byte, error) {
+	if len(challenge) < 48 || !bytes.Equal(challenge[:8], signature) || binary.LittleEndian.Uint32(challenge[8:]) != challengeMessage {
+		return nil, ErrInvalidChallenge
+	}
+	flags := binary.LittleEndian.Uint32(challenge[20:]) & negotiateFlags
+	serverChallenge := challenge[24:32]
+	targetInfo, err := readField(challenge, 40)
+	if err != nil {
+		return nil, err
+	}
+
+	// The server's time is preferred over our own, in which case the LM response is left out:
+	timestamp, hasTimestamp := findAvPair(targetInfo, msvAvTimestamp)
+	if !hasTimestamp || len(timestamp) != 8 {
+		timestamp = now
+		hasTimestamp = false
+	}
+
+	responseKey := ntowfv2(domain, user, password)
+	ntResponse, lmResponse := responses(responseKey, serverChallenge, clientChallenge, timestamp, targetInfo)
+	if hasTimestamp {
+		lmResponse = make([]byte, 24)
+	}
+
+	payload := [][]byte{
+		lmResponse,
+		ntResponse,
+		utf16c.Encode(domain),
+		utf16c.Encode(user),
+		utf16c.Encode(workstation),
+		nil, // EncryptedRandomSessionKey, as we don't negotiate key exchange
+	}
+
+	b := new(bytes.Buffer)
+	b.Write(signature)
+	binary.Write(b, binary.LittleEndian, uint32(authenticateMessage))
+	offset := 64
+	for _, p := range payload {
+		writeField(b, len(p), offset)
+		offset += len(p)
+	}
+	binary.Write(b, binary.LittleEndian, flags)
+	for _, p := range payload {
+		b.Write(p)
+	}
+	return b.Bytes(), nil
+}
+
+// ntowfv2 returns the NTLMv2 hash of the password, which is the key the responses are made with.
+func ntowfv2(domain, user, password string) []byte {
+	return hmacMD5(md4(utf16c.Encode(password)), utf16c.Encode(strings.ToUpper(user)+domain))
+}
+
+// responses returns the NTLMv2 and LMv2 responses to the server challenge.
+func responses(responseKey, serverChallenge, clientChallenge, timestamp, targetInfo []byte) (nt, lm []byte) {
+	temp := new(bytes.Buffer)
+	temp.Write([]byte{1, 1, 0, 0, 0, 0, 0, 0}) // Version and reserved
+	temp.Write(timestamp)
+	temp.Write(clientChallenge)
+	temp.Write([]byte{0, 0, 0, 0})
+	temp.Write(targetInfo)
+	temp.Write([]byte{0, 0, 0, 0})
+
+	ntProof := hmacMD5(responseKey, append(append([]byte(nil), serverChallenge...), temp.Bytes()...))
+	nt = append(ntProof, temp.Bytes()...)
+	lm = append(hmacMD5(responseKey, append(append([]byte(nil), serverChallenge...), clientChallenge...)), clientChallenge...)
+	return nt, lm
+}
+
+func hmacMD5(key, data []byte) []byte {
+	h := hmac.New(md5.New, key)
+	h.Write(data)
+	return h.Sum(nil)
+}
+
+// fileTime returns t as a FILETIME: the number of 100 nanosecond intervals since January 1, 1601.
+func fileTime(t time.Time) []byte {
+	const epochDifference = 116444736000000000 // From 1601 to 1970
+	b := make([]byte, 8)
+	binary.LittleEndian.PutUint64(b, uint64(t.UnixNano()/100+epochDifference))
+	return b
+}
+
+// findAvPair returns the value of the AV_PAIR with the given id in the target info.
+func findAvPair(targetInfo []byte, id uint16) ([]byte, bool) {
+	for len(targetInfo) >= 4 {
+		avID := binary.LittleEndian.Uint16(targetInfo)
+		length := int(binary.LittleEndian.Uint16(targetInfo[2:]))
+		if avID == 0 || len(targetInfo) < 4+length {
+			// MsvAvEOL, or invalid
+			break
+		}
+		if avID == id {
+			return targetInfo[4 : 4+length], true
+		}
+		targetInfo = targetInfo[4+length:]
+	}
+	return nil, false
+}
+
+// writeField writes the length, maximum length and offset of a field in the payload of a message.
+func writeField(b *bytes.Buffer, length, offset int) {
+	binary.Write(b, binary.LittleEndian, uint16(length))
+	binary.Write(b, binary.LittleEndian, uint16(length))
+	binary.Write(b, binary.LittleEndian, uint32(offset))
+}
+
+// readField returns the field of the payload of which the length and offset are at pos in the message.
+func readField(msg []byte, pos int) ([]byte, error) {
+	if len(msg) < pos+8 {
+		return nil, ErrInvalidChallenge
+	}
+	length := int(binary.LittleEndian.Uint16(msg[pos:]))
+	offset := int(binary.LittleEndian.Uint32(msg[pos+4:]))
+	if offset < 0 || offset+length > len(msg) || offset+length < offset {
+		return nil, ErrInvalidChallenge
+	}
+	return msg[offset : offset+length], nil
+}
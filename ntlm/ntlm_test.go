@@ -0,0 +1,94 @@
+package ntlm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"testing"
+
+	utf16c "github.com/Grovespaz/go-tds/utf16"
+)
+
+func TestMD4(t *testing.T) {
+	// From RFC 1320
+	tests := map[string]string{
+		"":    "31d6cfe0d16ae931b73c59d7e0c089c0",
+		"abc": "a448017aaf21d8525fc10ae87aa6729d",
+		"12345678901234567890123456789012345678901234567890123456789012345678901234567890": "e33b4ddc9c38f2199c3e7b164fcc0536",
+	}
+	for input, expected := range tests {
+		if digest := hex.EncodeToString(md4([]byte(input))); digest != expected {
+			t.Fatal("Did not get expected digest of ", input, ", got: ", digest)
+		}
+	}
+}
+
+// makeChallenge builds the CHALLENGE_MESSAGE of the example in section 4.2.4 of MS-NLMP.
+func makeChallenge() []byte {
+	targetName := utf16c.Encode("Server")
+	targetInfo := new(bytes.Buffer)
+	for _, av := range []struct {
+		id    uint16
+		value string
+	}{{2, "Domain"}, {1, "Server"}, {0, ""}} {
+		binary.Write(targetInfo, binary.LittleEndian, av.id)
+		binary.Write(targetInfo, binary.LittleEndian, uint16(len(av.value)*2))
+		targetInfo.Write(utf16c.Encode(av.value))
+	}
+
+	b := new(bytes.Buffer)
+	b.Write(signature)
+	binary.Write(b, binary.LittleEndian, uint32(challengeMessage))
+	writeField(b, len(targetName), 56)
+	binary.Write(b, binary.LittleEndian, uint32(0xe28a8233))
+	b.Write([]byte{0x01, 0x23, 0x45, 0x67, 0x89, 0xab, 0xcd, 0xef}) // Server challenge
+	b.Write(make([]byte, 8))
+	writeField(b, targetInfo.Len(), 56+len(targetName))
+	b.Write([]byte{0x06, 0x00, 0x70, 0x17, 0x00, 0x00, 0x00, 0x0f}) // Version
+	b.Write(targetName)
+	b.Write(targetInfo.Bytes())
+	return b.Bytes()
+}
+
+func TestAuthenticate(t *testing.T) {
+	clientChallenge := bytes.Repeat([]byte{0xaa}, 8)
+	msg, err := authenticate(makeChallenge(), "Domain", "User", "Password", "COMPUTER", clientChallenge, make([]byte, 8))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(msg[:8], signature) || binary.LittleEndian.Uint32(msg[8:]) != authenticateMessage {
+		t.Fatal("Not an AUTHENTICATE_MESSAGE: ", msg)
+	}
+
+	// The expected values are those of the example in MS-NLMP:
+	if key := hex.EncodeToString(ntowfv2("Domain", "User", "Password")); key != "0c868a403bfd7a93a3001ef22ef02e3f" {
+		t.Fatal("Did not get expected NTOWFv2, got: ", key)
+	}
+	lm, _ := readField(msg, 12)
+	if hex.EncodeToString(lm) != "86c35097ac9cec102554764a57cccc19aaaaaaaaaaaaaaaa" {
+		t.Fatal("Did not get expected LMv2 response, got: ", hex.EncodeToString(lm))
+	}
+	nt, _ := readField(msg, 20)
+	if len(nt) < 16 || hex.EncodeToString(nt[:16]) != "68cd0ab851e51c96aabc927bebef6a1c" {
+		t.Fatal("Did not get expected NTProofStr, got: ", hex.EncodeToString(nt))
+	}
+
+	fields := map[int]string{28: "Domain", 36: "User", 44: "COMPUTER"}
+	for pos, expected := range fields {
+		if value, _ := readField(msg, pos); utf16c.Decode(value) != expected {
+			t.Fatal("Expected ", expected, ", got: ", utf16c.Decode(value))
+		}
+	}
+}
+
+func TestAuthenticateInvalidChallenge(t *testing.T) {
+	challenge := makeChallenge()
+	// Target info out of bounds:
+	binary.LittleEndian.PutUint32(challenge[44:], uint32(len(challenge)))
+	invalid := [][]byte{nil, Negotiate(), challenge[:40], challenge}
+	for _, msg := range invalid {
+		if _, err := Authenticate(msg, "", "user", "password", ""); err != ErrInvalidChallenge {
+			t.Fatal("Expected an invalid challenge, got: ", err)
+		}
+	}
+}
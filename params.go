@@ -0,0 +1,100 @@
+package gotds
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"encoding/binary"
+	"fmt"
+	"time"
+)
+
+// The functions in this file are the counterparts of the decoders in rows.go and values.go.
+// They write the TYPE_INFO and values of data we send to the server, such as RPC parameters and TVP columns.
+
+// typeInfoForValue determines the TYPE_INFO to send a value with.
+// Only the nullable types are used, so that NULLs can be sent in the same column. NULL itself is sent as nvarchar.
+func typeInfoForValue(v driver.Value) (columnInfo, error) {
+	switch v.(type) {
+	case int64:
+		return columnInfo{columnType: INTNTYPE, size: 8}, nil
+	case float64:
+		return columnInfo{columnType: FLTNTYPE, size: 8}, nil
+	case bool:
+		return columnInfo{columnType: BITNTYPE, size: 1}, nil
+	case time.Time:
+		return columnInfo{columnType: DATETIMEOFFSETNTYPE, size: 10, scale: 7}, nil
+	case []byte:
+		return columnInfo{columnType: BIGVARBINTYPE, size: plpSize}, nil
+	case string, nil:
+		return columnInfo{columnType: NVARCHARTYPE, size: plpSize, collation: make([]byte, 5)}, nil
+	case XML:
+		return columnInfo{columnType: XMLTYPE, size: plpSize}, nil
+	}
+	return columnInfo{}, fmt.Errorf("%v (%T) can't be sent as a parameter", v, v)
+}
+
+// writeTypeInfo writes the TYPE_INFO of a nullable or variable-length type, the counterpart of parseColumnType.
+func writeTypeInfo(buf *bytes.Buffer, info columnInfo) {
+	buf.WriteByte(byte(info.columnType))
+	switch info.columnType {
+	case GUIDTYPE, INTNTYPE, BITNTYPE, FLTNTYPE, MONEYNTYPE, DATETIMNTYPE:
+		buf.WriteByte(byte(info.size))
+	case DECIMALNTYPE, NUMERICNTYPE:
+		buf.Write([]byte{byte(info.size), info.precision, info.scale})
+	case TIMENTYPE, DATETIME2NTYPE, DATETIMEOFFSETNTYPE:
+		buf.WriteByte(info.scale)
+	case BIGVARBINTYPE, BIGBINARYTYPE:
+		binary.Write(buf, binary.LittleEndian, uint16(info.size))
+	case BIGVARCHRTYPE, BIGCHARTYPE, NVARCHARTYPE, NCHARTYPE:
+		binary.Write(buf, binary.LittleEndian, uint16(info.size))
+		buf.Write(info.collation)
+	case XMLTYPE:
+		buf.WriteByte(0) // No schema collection
+	}
+}
+
+// writeValue writes a value in the format described by info, converting it if needed (see encodeValue).
+func writeValue(buf *bytes.Buffer, info columnInfo, v driver.Value) error {
+	var d []byte
+	if v != nil {
+		var err error
+		if d, err = encodeValue(info, v); err != nil {
+			return err
+		}
+	}
+
+	switch {
+	case info.size == plpSize:
+		if v == nil {
+			binary.Write(buf, binary.LittleEndian, uint64(0xFFFFFFFFFFFFFFFF)) // PLP_NULL
+		} else {
+			writePLP(buf, d)
+		}
+	case info.columnType == BIGVARBINTYPE || info.columnType == BIGBINARYTYPE || info.columnType == BIGVARCHRTYPE ||
+		info.columnType == BIGCHARTYPE || info.columnType == NVARCHARTYPE || info.columnType == NCHARTYPE:
+		if len(d) > info.size {
+			return fmt.Errorf("Value of %v bytes does not fit in %v", len(d), info.typeName())
+		}
+		if v == nil {
+			binary.Write(buf, binary.LittleEndian, uint16(0xFFFF))
+		} else {
+			binary.Write(buf, binary.LittleEndian, uint16(len(d)))
+			buf.Write(d)
+		}
+	default:
+		// BYTE-length types, where 0 is NULL
+		buf.WriteByte(byte(len(d)))
+		buf.Write(d)
+	}
+	return nil
+}
+
+// writePLP writes d as PLP data in a single chunk.
+func writePLP(buf *bytes.Buffer, d []byte) {
+	binary.Write(buf, binary.LittleEndian, uint64(len(d)))
+	if len(d) > 0 {
+		binary.Write(buf, binary.LittleEndian, uint32(len(d)))
+		buf.Write(d)
+	}
+	binary.Write(buf, binary.LittleEndian, uint32(0)) // PLP_TERMINATOR
+}
@@ -0,0 +1,63 @@
+package gotds
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParamRoundTrip(t *testing.T) {
+	c := Conn{tdsVersion: TDS72}
+	values := []driver.Value{
+		int64(-1234567890123),
+		3.25,
+		true,
+		time.Date(2014, 3, 1, 12, 34, 56, 123456700, time.FixedZone("", -5*3600)),
+		[]byte{0xca, 0xfe},
+		"Hello, 世界",
+		nil,
+	}
+
+	for _, v := range values {
+		info, err := typeInfoForValue(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b := new(bytes.Buffer)
+		writeTypeInfo(b, info)
+		if err = writeValue(b, info, v); err != nil {
+			t.Fatal(err)
+		}
+
+		parsedInfo, err := c.parseColumnType(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(info, parsedInfo) {
+			t.Fatalf("TYPE_INFO of %v doesn't survive a round trip: %+v vs. %+v", v, info, parsedInfo)
+		}
+		parsed, err := readValue(b, parsedInfo)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tm, isTime := v.(time.Time); isTime {
+			if !tm.Equal(parsed.(time.Time)) {
+				t.Fatalf("Value doesn't survive a round trip: %v vs. %v", v, parsed)
+			}
+		} else if !reflect.DeepEqual(v, parsed) {
+			t.Fatalf("Value doesn't survive a round trip: %v vs. %v", v, parsed)
+		}
+		if b.Len() != 0 {
+			t.Fatalf("%v bytes left after reading %v", b.Len(), v)
+		}
+	}
+}
+
+func TestParamTypeMismatch(t *testing.T) {
+	info, _ := typeInfoForValue(int64(1))
+	if err := writeValue(new(bytes.Buffer), info, []byte{1}); err == nil {
+		t.Fatal("Expected an error writing a []byte as an int")
+	}
+}
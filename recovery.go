@@ -0,0 +1,271 @@
+package gotds
+
+import (
+	"bytes"
+	"context"
+	"database/sql/driver"
+	"encoding/binary"
+	"errors"
+	"time"
+)
+
+// Connection resiliency: when the server dropped an idle connection, e.g. because a network device in between timed it out,
+// we reconnect and have the server recover the session as it was. For this we keep track of the state of the session:
+// the server tells us the initial state in the FEATUREEXTACK of the login, and any changes to it in SESSIONSTATE tokens.
+// The database, language and collation are tracked through ENVCHANGE tokens instead.
+
+// sessionRecovery holds what's needed to recover the session on a new connection, see Config.ConnectRetryCount.
+type sessionRecovery struct {
+	// The state of the session right after login.
+	initial sessionData
+	// Whatever changed since, only the states that changed are set.
+	current sessionData
+
+	// The states the server reported to be unrecoverable, the session can only be recovered while there are none.
+	unrecoverable map[byte]bool
+
+	// Set when the server acknowledged the session recovery feature of the login.
+	acknowledged bool
+	// Set while logging in to recover the session.
+	recovering bool
+}
+
+// sessionData is the state of a session as sent in the SESSIONRECOVERY feature extension of the login.
+type sessionData struct {
+	database  string
+	collation []byte
+	language  string
+	states    map[byte][]byte
+}
+
+// The default of Config.ConnectRetryInterval.
+const defaultConnectRetryInterval = 10 * time.Second
+
+// Status bits of a SESSIONSTATE token:
+const sessionStateRecoverable = 0x01
+
+// setEnv records a change of the database, language or collation.
+// Changes during the initial login are part of the initial state.
+func (r *sessionRecovery) setEnv(envType byte, value string, collation []byte, login bool) {
+	data := &r.current
+	if login && !r.recovering {
+		data = &r.initial
+	}
+	switch envType {
+	case envDatabase:
+		data.database = value
+	case envLanguage:
+		data.language = value
+	case envCollation:
+		data.collation = collation
+	}
+}
+
+// readFeatureAck reads the initial states of the session from the acknowledgement of the SESSIONRECOVERY feature.
+// When recovering a session these are the states it was recovered with, which are then sent again if the session has to be recovered once more.
+func (r *sessionRecovery) readFeatureAck(data []byte) error {
+	r.acknowledged = true
+	target := &r.initial
+	if r.recovering {
+		target = &r.current
+	}
+	return readSessionStates(bytes.NewBuffer(data), target, nil)
+}
+
+// readSessionState reads the data of a SESSIONSTATE token.
+func (r *sessionRecovery) readSessionState(data []byte) error {
+	buf := bytes.NewBuffer(data)
+	// SeqNo: tokens are processed in the order they arrive on a single connection, so we don't need it.
+	if _, err := readBytes(buf, 4); err != nil {
+		return err
+	}
+	status, err := buf.ReadByte()
+	if err != nil {
+		return err
+	}
+	return readSessionStates(buf, &r.current, func(id byte) {
+		if r.unrecoverable == nil {
+			r.unrecoverable = make(map[byte]bool)
+		}
+		if status&sessionStateRecoverable == 0 {
+			r.unrecoverable[id] = true
+		} else {
+			delete(r.unrecoverable, id)
+		}
+	})
+}
+
+// readSessionStates reads a SessionStateDataSet into data, calling changed (if set) for each state.
+func readSessionStates(buf *bytes.Buffer, data *sessionData, changed func(id byte)) error {
+	for buf.Len() > 0 {
+		id, err := buf.ReadByte()
+		if err != nil {
+			return err
+		}
+		// The length is a BYTE, or 0xFF followed by a DWORD
+		b, err := buf.ReadByte()
+		if err != nil {
+			return err
+		}
+		length := uint32(b)
+		if b == 0xFF {
+			if err = binary.Read(buf, binary.LittleEndian, &length); err != nil {
+				return err
+			}
+		}
+		value, err := readBytes(buf, int(length))
+		if err != nil {
+			return err
+		}
+		if data.states == nil {
+			data.states = make(map[byte][]byte)
+		}
+		data.states[id] = append([]byte(nil), value...)
+		if changed != nil {
+			changed(id)
+		}
+	}
+	return nil
+}
+
+// reset forgets all changes, for when the session is reset to its initial state by RESETCONNECTION.
+func (r *sessionRecovery) reset() {
+	r.current = sessionData{}
+	r.unrecoverable = nil
+}
+
+// recoverable reports whether the session can be recovered on a new connection.
+func (r *sessionRecovery) recoverable() bool {
+	return len(r.unrecoverable) == 0
+}
+
+// featureData returns the data of the SESSIONRECOVERY feature extension of the login.
+// This is empty for the initial login, when recovering it's the initial state followed by the changes to it.
+func (r *sessionRecovery) featureData() []byte {
+	if !r.recovering {
+		return nil
+	}
+	b := new(bytes.Buffer)
+	writeSessionData(b, r.initial)
+	// Only what differs from the initial state is sent:
+	changes := r.current
+	if changes.database == r.initial.database {
+		changes.database = ""
+	}
+	if changes.language == r.initial.language {
+		changes.language = ""
+	}
+	if bytes.Equal(changes.collation, r.initial.collation) {
+		changes.collation = nil
+	}
+	writeSessionData(b, changes)
+	return b.Bytes()
+}
+
+// writeSessionData writes data as a SessionRecoveryData structure, which starts with its length.
+func writeSessionData(w *bytes.Buffer, data sessionData) {
+	b := new(bytes.Buffer)
+	writeB_VarChar(b, data.database)
+	b.WriteByte(byte(len(data.collation)))
+	b.Write(data.collation)
+	writeB_VarChar(b, data.language)
+	// In order of their ID, just like the server sends them:
+	for id := 0; id < 256; id++ {
+		value, ok := data.states[byte(id)]
+		if !ok {
+			continue
+		}
+		b.WriteByte(byte(id))
+		if len(value) < 0xFF {
+			b.WriteByte(byte(len(value)))
+		} else {
+			b.WriteByte(0xFF)
+			binary.Write(b, binary.LittleEndian, uint32(len(value)))
+		}
+		b.Write(value)
+	}
+
+	binary.Write(w, binary.LittleEndian, uint32(b.Len()))
+	w.Write(b.Bytes())
+}
+
+// The server accepted the login, but didn't recover the session.
+var errSessionNotRecovered = errors.New("The server did not recover the session")
+
+// recoverSession reconnects and recovers the session if the server dropped the connection while it was idle.
+// It does nothing unless connection resiliency is enabled, see Config.ConnectRetryCount.
+// Only transient errors (see IsTransient) are retried, and all attempts together take at most Config.Timeout, if set.
+// When the session can't be recovered, driver.ErrBadConn is returned so database/sql retries on a new connection.
+func (c *Conn) recoverSession() error {
+	if c.recovery == nil || c.State == Error || !isDropped(c.socket) {
+		return nil
+	}
+	c.socket.Close()
+	if !c.recovery.recoverable() {
+		errLog.Printf("Connection was dropped, and the session can't be recovered.\n")
+		c.State = Error
+		return driver.ErrBadConn
+	}
+
+	interval := c.cfg.ConnectRetryInterval
+	if interval <= 0 {
+		interval = defaultConnectRetryInterval
+	}
+	ctx := context.Background()
+	if c.cfg.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.cfg.Timeout)
+		defer cancel()
+	}
+	var err error
+	for attempt := 0; attempt < c.cfg.ConnectRetryCount; attempt++ {
+		if attempt > 0 {
+			timer := time.NewTimer(interval)
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+				err = ctx.Err()
+			}
+		}
+		if err == nil {
+			if err = c.reconnect(ctx); err == nil {
+				return nil
+			}
+		}
+		if !IsTransient(err) {
+			// E.g. the login failed, which won't change by trying again
+			break
+		}
+	}
+	errLog.Printf("Connection was dropped, and recovering the session failed: %v\n", err)
+	c.State = Error
+	return driver.ErrBadConn
+}
+
+// reconnect opens a new connection and logs in with the recovery data of the session.
+func (c *Conn) reconnect(ctx context.Context) error {
+	socket, err := dial(ctx, &c.cfg)
+	if err != nil {
+		return err
+	}
+	recovery := c.recovery
+	c.socket = socket
+	recovery.recovering = true
+	err = c.connect(ctx)
+	recovery.recovering = false
+	if err != nil {
+		socket.Close()
+		// connect gives up on recovery when the server doesn't acknowledge it, but we may retry
+		c.recovery = recovery
+		return err
+	}
+	if c.recovery == nil {
+		// The server accepted the login, but didn't recover the session
+		c.recovery = recovery
+		c.State = Error
+		socket.Close()
+		return errSessionNotRecovered
+	}
+	return nil
+}
@@ -0,0 +1,329 @@
+package gotds
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"encoding/binary"
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// serveTDS listens on a local port and serves each connection made to it with the next of handlers.
+// It returns the address to connect to.
+func serveTDS(t *testing.T, handlers ...func(s *testServerConn)) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for _, handler := range handlers {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			handler(&testServerConn{t: t, conn: conn})
+			conn.Close()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+// testServerConn is a connection to the test server, see serveTDS.
+type testServerConn struct {
+	t    *testing.T
+	conn net.Conn
+}
+
+// read reads a message of the client, which should be of the given type.
+func (s *testServerConn) read(expected packetType) []byte {
+	var message []byte
+	for {
+		header := make([]byte, headerSize)
+		if _, err := io.ReadFull(s.conn, header); err != nil {
+			s.t.Error("Test server could not read: ", err)
+			return nil
+		}
+		if packetType(header[0]) != expected {
+			s.t.Error("Test server expected a message of type ", expected, ", got: ", header[0])
+		}
+		data := make([]byte, int(binary.BigEndian.Uint16(header[2:4]))-headerSize)
+		if _, err := io.ReadFull(s.conn, data); err != nil {
+			s.t.Error("Test server could not read: ", err)
+			return nil
+		}
+		message = append(message, data...)
+		if header[1]&statusEOM != 0 {
+			return message
+		}
+	}
+}
+
+// write sends a response consisting of the given tokens.
+func (s *testServerConn) write(tokens ...[]byte) {
+	if _, err := s.conn.Write(makePacket(ptyTableResult, bytes.Join(tokens, nil), 1, true)); err != nil {
+		s.t.Error("Test server could not write: ", err)
+	}
+}
+
+// preLogin handles the pre-login of the client.
+func (s *testServerConn) preLogin() {
+	s.read(ptyPreLogin)
+	s.write(makePreLoginPacket(0, encryptNotSupported, "", 0, false, [20]byte{}, false))
+}
+
+// featureExt returns the FeatureExt block of a LOGIN7 message, nil if there is none.
+func featureExt(login []byte) []byte {
+	// The extension is the 6th entry of the offsets and lengths, which start at byte 36:
+	if len(login) < 94 || login[27]&0x10 == 0 {
+		return nil
+	}
+	offset := int(binary.LittleEndian.Uint16(login[36+5*4:]))
+	return login[binary.LittleEndian.Uint32(login[offset:]):]
+}
+
+func makeLoginAckToken() []byte {
+	b := new(bytes.Buffer)
+	b.WriteByte(1)                          // Interface
+	b.Write([]byte{0x74, 0x00, 0x00, 0x04}) // TDS 7.4
+	writeB_VarChar(b, "Test Server")
+	b.Write([]byte{12, 0, 0, 0})
+	return append([]byte{byte(loginAck), byte(b.Len()), byte(b.Len() >> 8)}, b.Bytes()...)
+}
+
+func makeEnvChangeToken(envType byte, newValue, oldValue string) []byte {
+	b := new(bytes.Buffer)
+	b.WriteByte(envType)
+	writeB_VarChar(b, newValue)
+	writeB_VarChar(b, oldValue)
+	return append([]byte{byte(envChange), byte(b.Len()), byte(b.Len() >> 8)}, b.Bytes()...)
+}
+
+func makeFeatureExtAckToken(feature byte, data []byte) []byte {
+	token := []byte{byte(featureExtAck), feature, 0, 0, 0, 0}
+	binary.LittleEndian.PutUint32(token[2:], uint32(len(data)))
+	return append(append(token, data...), featureTerminator)
+}
+
+func makeSessionStateToken(seqNo uint32, status byte, states ...byte) []byte {
+	token := []byte{byte(sessionState), 0, 0, 0, 0, 0, 0, 0, 0, status}
+	binary.LittleEndian.PutUint32(token[1:], uint32(5+len(states)))
+	binary.LittleEndian.PutUint32(token[5:], seqNo)
+	return append(token, states...)
+}
+
+var testDoneToken = []byte{0xfd, 0x00, 0x00, 0xc1, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}
+
+// waitUntilDropped waits for the client to notice that the server closed the connection.
+func waitUntilDropped(t *testing.T, c *Conn) {
+	for deadline := time.Now().Add(5 * time.Second); !isDropped(c.socket); {
+		if time.Now().After(deadline) {
+			t.Fatal("The client did not notice the connection was closed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSessionRecovery(t *testing.T) {
+	var recoveryData []byte
+	addr := serveTDS(t, func(s *testServerConn) {
+		s.preLogin()
+		login := s.read(ptyLogin)
+		if !bytes.Equal(login[4:8], []byte{0x04, 0x00, 0x00, 0x74}) {
+			t.Error("Expected a TDS 7.4 login, got version: ", login[4:8])
+		}
+		if ext := featureExt(login); !bytes.Equal(ext, []byte{featureSessionRecovery, 0, 0, 0, 0, featureTerminator}) {
+			t.Error("Expected the session recovery feature without data, got: ", ext)
+		}
+		s.write(makeLoginAckToken(), makeEnvChangeToken(envDatabase, "gotest", "master"), makeEnvChangeToken(envLanguage, "us_english", ""),
+			makeFeatureExtAckToken(featureSessionRecovery, []byte{0, 1, 1}), testDoneToken)
+
+		s.read(ptySQLBatch)
+		s.write(makeEnvChangeToken(envDatabase, "other", "gotest"), makeSessionStateToken(1, sessionStateRecoverable, 2, 2, 'x', 'y'), testDoneToken)
+		// And then the connection is dropped
+	}, func(s *testServerConn) {
+		s.preLogin()
+		recoveryData = featureExt(s.read(ptyLogin))
+		s.write(makeLoginAckToken(), makeEnvChangeToken(envDatabase, "other", "master"),
+			makeFeatureExtAckToken(featureSessionRecovery, []byte{0, 1, 1, 2, 2, 'x', 'y'}), testDoneToken)
+
+		s.read(ptySQLBatch)
+		s.write(testDoneToken)
+	})
+
+	c, err := MakeConnection(&Config{Net: "tcp", Addr: addr, PacketSize: 0x1000, Placeholder: '?', ConnectRetryCount: 1, ConnectRetryInterval: time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if c.recovery == nil || c.tdsVersion != TDS74 {
+		t.Fatal("Expected session recovery to be acknowledged")
+	}
+	if _, err = c.Exec("USE other; SET DATEFIRST 1", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	waitUntilDropped(t, c)
+	if _, err = c.Exec("SELECT 1", nil); err != nil {
+		t.Fatal("Expected the session to be recovered, got: ", err)
+	}
+
+	initial := new(bytes.Buffer)
+	writeB_VarChar(initial, "gotest")
+	initial.WriteByte(0) // No collation
+	writeB_VarChar(initial, "us_english")
+	initial.Write([]byte{0, 1, 1})
+	changes := new(bytes.Buffer)
+	writeB_VarChar(changes, "other")
+	changes.Write([]byte{0, 0}) // Same collation and language
+	changes.Write([]byte{2, 2, 'x', 'y'})
+
+	expected := new(bytes.Buffer)
+	expected.WriteByte(featureSessionRecovery)
+	binary.Write(expected, binary.LittleEndian, uint32(8+initial.Len()+changes.Len()))
+	binary.Write(expected, binary.LittleEndian, uint32(initial.Len()))
+	expected.Write(initial.Bytes())
+	binary.Write(expected, binary.LittleEndian, uint32(changes.Len()))
+	expected.Write(changes.Bytes())
+	expected.WriteByte(featureTerminator)
+	if !bytes.Equal(recoveryData, expected.Bytes()) {
+		t.Fatalf("Did not receive expected recovery data, got:\n% x\nexpected:\n% x", recoveryData, expected.Bytes())
+	}
+}
+
+func TestSessionNotRecoverable(t *testing.T) {
+	addr := serveTDS(t, func(s *testServerConn) {
+		s.preLogin()
+		s.read(ptyLogin)
+		s.write(makeLoginAckToken(), makeFeatureExtAckToken(featureSessionRecovery, nil), testDoneToken)
+
+		s.read(ptySQLBatch)
+		// E.g. after BEGIN TRANSACTION:
+		s.write(makeSessionStateToken(1, 0, 3, 1, 1), testDoneToken)
+	})
+
+	c, err := MakeConnection(&Config{Net: "tcp", Addr: addr, PacketSize: 0x1000, Placeholder: '?', ConnectRetryCount: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if _, err = c.Exec("BEGIN TRANSACTION", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	waitUntilDropped(t, c)
+	if _, err = c.Exec("SELECT 1", nil); err != driver.ErrBadConn {
+		t.Fatal("Expected a session that can't be recovered to give driver.ErrBadConn, got: ", err)
+	}
+	if c.IsValid() {
+		t.Fatal("Expected the connection to be invalid")
+	}
+}
+
+func TestSessionRecoveryAfterQuery(t *testing.T) {
+	var recoveryData []byte
+	addr := serveTDS(t, func(s *testServerConn) {
+		s.preLogin()
+		s.read(ptyLogin)
+		s.write(makeLoginAckToken(), makeFeatureExtAckToken(featureSessionRecovery, []byte{0, 1, 1}), testDoneToken)
+
+		// Response to "USE other; SET DATEFIRST 1; SELECT 1", where the result follows the ENVCHANGE:
+		s.read(ptySQLBatch)
+		s.write(makeEnvChangeToken(envDatabase, "other", "gotest"),
+			[]byte{0x81, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x38, 0x00}, []byte{0xd1, 0x01, 0x00, 0x00, 0x00},
+			makeSessionStateToken(1, sessionStateRecoverable, 2, 2, 'x', 'y'), testDoneToken)
+		// And then the connection is dropped
+	}, func(s *testServerConn) {
+		s.preLogin()
+		recoveryData = featureExt(s.read(ptyLogin))
+		s.write(makeLoginAckToken(), makeFeatureExtAckToken(featureSessionRecovery, []byte{0, 1, 1, 2, 2, 'x', 'y'}), testDoneToken)
+
+		s.read(ptySQLBatch)
+		s.write(testDoneToken)
+	})
+
+	c, err := MakeConnection(&Config{Net: "tcp", Addr: addr, PacketSize: 0x1000, Placeholder: '?', ConnectRetryCount: 1, ConnectRetryInterval: time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	rows, err := c.Query("USE other; SET DATEFIRST 1; SELECT 1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := make([]driver.Value, 1)
+	for err == nil {
+		err = rows.Next(values)
+	}
+	if err != io.EOF {
+		t.Fatal(err)
+	}
+
+	waitUntilDropped(t, c)
+	if _, err = c.Exec("SELECT 1", nil); err != nil {
+		t.Fatal("Expected the session to be recovered, got: ", err)
+	}
+	database := new(bytes.Buffer)
+	writeB_VarChar(database, "other")
+	if !bytes.Contains(recoveryData, database.Bytes()) || !bytes.Contains(recoveryData, []byte{2, 2, 'x', 'y'}) {
+		t.Fatalf("Expected the changes made by the query to be recovered, got:\n% x", recoveryData)
+	}
+}
+
+func TestSessionRecoveryLoginFailed(t *testing.T) {
+	var attempts int32
+	failLogin := func(s *testServerConn) {
+		s.preLogin()
+		s.read(ptyLogin)
+		atomic.AddInt32(&attempts, 1)
+		s.write(makeErrorToken(18456, 14, "Login failed for user 'app'."), testDoneToken)
+	}
+	addr := serveTDS(t, func(s *testServerConn) {
+		s.preLogin()
+		s.read(ptyLogin)
+		s.write(makeLoginAckToken(), makeFeatureExtAckToken(featureSessionRecovery, []byte{0, 1, 1}), testDoneToken)
+	}, failLogin, failLogin)
+
+	c, err := MakeConnection(&Config{Net: "tcp", Addr: addr, PacketSize: 0x1000, Placeholder: '?', ConnectRetryCount: 2, ConnectRetryInterval: time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	waitUntilDropped(t, c)
+	if _, err = c.Exec("SELECT 1", nil); err != driver.ErrBadConn {
+		t.Fatal("Expected the session not to be recovered, got: ", err)
+	}
+	if n := atomic.LoadInt32(&attempts); n != 1 {
+		t.Fatal("Expected a failed login not to be retried, got attempts: ", n)
+	}
+}
+
+func TestSessionRecoveryTimeout(t *testing.T) {
+	addr := serveTDS(t, func(s *testServerConn) {
+		s.preLogin()
+		s.read(ptyLogin)
+		s.write(makeLoginAckToken(), makeFeatureExtAckToken(featureSessionRecovery, []byte{0, 1, 1}), testDoneToken)
+	})
+
+	c, err := MakeConnection(&Config{Net: "tcp", Addr: addr, PacketSize: 0x1000, Placeholder: '?', ConnectRetryCount: 3, ConnectRetryInterval: time.Hour, Timeout: 100 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	// The server is gone, so every attempt is refused:
+	waitUntilDropped(t, c)
+	c.cfg.Addr = refusedAddr(t)
+	start := time.Now()
+	if _, err = c.Exec("SELECT 1", nil); err != driver.ErrBadConn {
+		t.Fatal("Expected the session not to be recovered, got: ", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatal("Expected recovering to give up after the timeout, took: ", elapsed)
+	}
+}
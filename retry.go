@@ -0,0 +1,108 @@
+package gotds
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"net"
+	"syscall"
+	"time"
+)
+
+// Error numbers of errors that are likely to go away by themselves.
+var transientErrorNumbers = map[int32]bool{
+	1205:  true, // Transaction was deadlocked and has been chosen as the deadlock victim
+	4060:  true, // Cannot open database requested by the login, e.g. during a failover
+	10928: true, // Resource limit reached (Azure SQL)
+	10929: true, // Resource limit reached (Azure SQL)
+	40197: true, // Service error processing the request (Azure SQL), e.g. during an upgrade
+	40501: true, // Service is currently busy (Azure SQL)
+	40613: true, // Database is not currently available (Azure SQL)
+	49918: true, // Not enough resources to process the request (Azure SQL)
+	49919: true, // Too many create or update operations in progress (Azure SQL)
+	49920: true, // Too many operations in progress (Azure SQL)
+}
+
+// IsTransient reports whether err is likely to go away when the operation is retried.
+// This is the case for deadlocks, throttling and failovers (see transientErrorNumbers), and for network errors such as connection resets and timeouts.
+func IsTransient(err error) bool {
+	if err == nil {
+		return false
+	}
+	if isTransientServerError(err) {
+		return true
+	}
+
+	if errors.Is(err, driver.ErrBadConn) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNABORTED) ||
+		errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.EPIPE) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// isTransientServerError reports whether err contains a transient error sent by the server.
+func isTransientServerError(err error) bool {
+	var serverErr ServerError
+	if errors.As(err, &serverErr) {
+		for _, sqlerr := range serverErr.Errors {
+			if transientErrorNumbers[sqlerr.Number] {
+				return true
+			}
+		}
+		return false
+	}
+	var sqlerr SQLError
+	return errors.As(err, &sqlerr) && transientErrorNumbers[sqlerr.Number]
+}
+
+// RetryPolicy determines how operations that failed with a transient error (see IsTransient) are retried.
+// Retries are opt-in, see Connector.
+type RetryPolicy struct {
+	// The number of retries after the first attempt.
+	MaxRetries int
+	// The time to wait before the first retry, which doubles for every retry after that. Defaults to 100ms.
+	InitialBackoff time.Duration
+	// The maximum time to wait between retries, 0 means no maximum.
+	MaxBackoff time.Duration
+	// Connecting is always retried, but queries are only retried when this is set.
+	// Only Query is retried, never Exec, and only outside of a transaction: a deadlock rolls back the transaction the query ran in,
+	// and the caller has to rerun all of it. So this is safe as long as the queries are idempotent reads.
+	// A query is retried on the same connection, and only for errors sent by the server (e.g. a deadlock) that leave the connection usable.
+	RetryQueries bool
+}
+
+// backoff returns the time to wait before the given retry, starting at 0.
+func (p *RetryPolicy) backoff(retry int) time.Duration {
+	d := p.InitialBackoff
+	if d <= 0 {
+		d = 100 * time.Millisecond
+	}
+	for i := 0; i < retry && (p.MaxBackoff <= 0 || d < p.MaxBackoff); i++ {
+		d *= 2
+	}
+	if p.MaxBackoff > 0 && d > p.MaxBackoff {
+		d = p.MaxBackoff
+	}
+	return d
+}
+
+// do runs f until it succeeds, fails with an error for which retryable returns false, or runs out of retries.
+// The last error is returned, or ctx.Err() if ctx is done while waiting for a retry.
+func (p *RetryPolicy) do(ctx context.Context, f func() error, retryable func(error) bool) error {
+	err := f()
+	for retry := 0; err != nil && retry < p.MaxRetries && retryable(err); retry++ {
+		timer := time.NewTimer(p.backoff(retry))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+		errLog.Printf("Retrying after transient error: %v\n", err)
+		err = f()
+	}
+	return err
+}
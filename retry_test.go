@@ -0,0 +1,121 @@
+package gotds
+
+import (
+	"bytes"
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/Grovespaz/go-tds/mockserver"
+)
+
+func TestIsTransient(t *testing.T) {
+	tests := []struct {
+		err       error
+		transient bool
+	}{
+		{nil, false},
+		{errors.New("something else"), false},
+		{SQLError{Number: 1205}, true},
+		{ServerError{SQLError: SQLError{Number: 2627}, Errors: []SQLError{{Number: 2627}, {Number: 40501}}}, true},
+		{ServerError{SQLError: SQLError{Number: 2627}, Errors: []SQLError{{Number: 2627}}}, false},
+		{fmt.Errorf("query failed: %w", SQLError{Number: 40613}), true},
+		{&net.OpError{Op: "read", Net: "tcp", Err: os.NewSyscallError("read", syscall.ECONNRESET)}, true},
+		{driver.ErrBadConn, true},
+	}
+	for _, test := range tests {
+		if IsTransient(test.err) != test.transient {
+			t.Fatal("Expected IsTransient to return ", test.transient, " for: ", test.err)
+		}
+	}
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	p := RetryPolicy{InitialBackoff: time.Second, MaxBackoff: 5 * time.Second}
+	expected := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second}
+	for i, d := range expected {
+		if p.backoff(i) != d {
+			t.Fatal("Expected a backoff of ", d, " for retry ", i, ", got: ", p.backoff(i))
+		}
+	}
+}
+
+func TestRetryPolicyContext(t *testing.T) {
+	p := RetryPolicy{MaxRetries: 5, InitialBackoff: time.Hour}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := p.do(ctx, func() error { return SQLError{Number: 1205} }, IsTransient)
+	if err != context.Canceled {
+		t.Fatal("Expected the retries to stop once the context is done, got: ", err)
+	}
+}
+
+func TestQueryRetry(t *testing.T) {
+	deadlock := makeErrorToken(1205, 13, "Transaction (Process ID 52) was deadlocked on lock resources with another process and has been chosen as the deadlock victim. Rerun the transaction.")
+	result := []byte{0x81, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x38, 0x00,
+		0xd1, 0x01, 0x00, 0x00, 0x00,
+		0xfd, 0x10, 0x00, 0xc1, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}
+	mockSrv := mockserver.MakeMockServer([][]byte{
+		makePacket(ptyTableResult, deadlock, 1, true),
+		makePacket(ptyTableResult, result, 1, true),
+	}, t)
+	c := &Conn{socket: mockSrv, tdsVersion: TDS73, cfg: Config{PacketSize: 0x1000, Placeholder: '?'},
+		retry: &RetryPolicy{MaxRetries: 1, InitialBackoff: time.Millisecond, RetryQueries: true}}
+
+	rows, err := c.Query("SELECT 1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mockSrv.Written) != 2 {
+		t.Fatal("Expected the query to be sent twice, got: ", len(mockSrv.Written))
+	}
+	values := make([]driver.Value, 1)
+	if err = rows.Next(values); err != nil || values[0].(int64) != 1 {
+		t.Fatal("Did not receive expected value 1, got: ", values[0], err)
+	}
+
+	// Exec is never retried:
+	mockSrv = mockserver.MakeMockServer([][]byte{makePacket(ptyTableResult, deadlock, 1, true)}, t)
+	c.socket = mockSrv
+	if _, err = c.Exec("UPDATE a SET b = 1", nil); !IsTransient(err) || len(mockSrv.Written) != 1 {
+		t.Fatal("Expected Exec to fail without retrying, got: ", err)
+	}
+}
+
+// makeTransactionEnvChangeToken returns an ENVCHANGE token of a transaction, of which the values are descriptors rather than strings.
+func makeTransactionEnvChangeToken(envType byte, newValue, oldValue []byte) []byte {
+	data := append([]byte{envType, byte(len(newValue))}, newValue...)
+	data = append(append(data, byte(len(oldValue))), oldValue...)
+	return append([]byte{byte(envChange), byte(len(data)), byte(len(data) >> 8)}, data...)
+}
+
+func TestQueryRetryInTransaction(t *testing.T) {
+	deadlock := makeErrorToken(1205, 13, "Transaction (Process ID 52) was deadlocked on lock resources with another process and has been chosen as the deadlock victim. Rerun the transaction.")
+	descriptor := []byte{1, 0, 0, 0, 0, 0, 0, 0}
+	mockSrv := mockserver.MakeMockServer([][]byte{
+		makePacket(ptyTableResult, append(makeTransactionEnvChangeToken(envBeginTransaction, descriptor, nil), testDoneToken...), 1, true),
+		// The server rolls back the transaction of the deadlock victim:
+		makePacket(ptyTableResult, bytes.Join([][]byte{deadlock, makeTransactionEnvChangeToken(envRollbackTransaction, nil, descriptor), testDoneToken}, nil), 1, true),
+	}, t)
+	c := &Conn{socket: mockSrv, tdsVersion: TDS73, cfg: Config{PacketSize: 0x1000, Placeholder: '?'},
+		retry: &RetryPolicy{MaxRetries: 1, InitialBackoff: time.Millisecond, RetryQueries: true}}
+
+	if _, err := c.Exec("BEGIN TRAN; UPDATE a SET b = 1", nil); err != nil {
+		t.Fatal(err)
+	}
+	if !c.inTransaction {
+		t.Fatal("Expected a transaction to be open")
+	}
+	if _, err := c.Query("SELECT b FROM a", nil); !IsTransient(err) || len(mockSrv.Written) != 2 {
+		t.Fatal("Expected the query in the transaction to fail without retrying, got: ", err, len(mockSrv.Written))
+	}
+	if c.inTransaction {
+		t.Fatal("Expected the transaction to be rolled back")
+	}
+}
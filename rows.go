@@ -3,9 +3,17 @@ package gotds
 import (
 	"bytes"
 	"database/sql/driver"
+	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	utf16c "github.com/Grovespaz/go-tds/utf16"
 )
 
 var (
@@ -16,6 +24,23 @@ type columnType byte
 
 type columnInfo struct {
 	columnType columnType
+	// Only set for result columns: the UserType and flags (see colNullable etc.) from COLMETADATA.
+	userType uint32
+	flags    uint16
+	// Maximum length in bytes of a value of this column.
+	// For fixed-length types this is simply the length of the value.
+	size int
+	// Precision and scale of decimal and numeric columns. Time, datetime2 and datetimeoffset only use scale.
+	precision byte
+	scale     byte
+	// The 5-byte collation of character columns, nil for all other types.
+	collation []byte
+	// Only for text, ntext and image columns: the (multi-part) name of the table the column belongs to.
+	tableName []string
+	// Only for xml columns: the schema collection the column is bound to, nil for untyped xml.
+	xmlSchema *xmlSchemaInfo
+	// Only for CLR UDT columns: the type of the values.
+	udt *udtInfo
 }
 
 const (
@@ -39,7 +64,19 @@ const (
 	INT8TYPE     columnType = 0x7F // BigInt
 
 	//Variable length:
-	//4 or 8-byte:
+	//BYTE-length bytes:
+	GUIDTYPE            columnType = 0x24 // UniqueIdentifier
+	INTNTYPE            columnType = 0x26
+	DATENTYPE           columnType = 0x28 // Date
+	TIMENTYPE           columnType = 0x29 // Time
+	DATETIME2NTYPE      columnType = 0x2A // DateTime2
+	DATETIMEOFFSETNTYPE columnType = 0x2B // DateTimeOffset
+	BITNTYPE            columnType = 0x68
+	DECIMALNTYPE        columnType = 0x6A // Decimal
+	NUMERICNTYPE        columnType = 0x6C // Numeric
+	FLTNTYPE            columnType = 0x6D
+	MONEYNTYPE          columnType = 0x6E
+	DATETIMNTYPE        columnType = 0x6F
 
 	//Variable USHORT (uint16)-length bytes:
 	BIGVARBINTYPE columnType = 0xA5
@@ -48,21 +85,265 @@ const (
 	BIGCHARTYPE   columnType = 0xAF
 	NVARCHARTYPE  columnType = 0xE7
 	NCHARTYPE     columnType = 0xEF
+	UDTTYPE       columnType = 0xF0 // CLR UDT, always sent as PLP
+	XMLTYPE       columnType = 0xF1 // Always sent as PLP
+
+	//Variable LONG (uint32)-length bytes:
+	IMAGETYPE     columnType = 0x22
+	TEXTTYPE      columnType = 0x23
+	NTEXTTYPE     columnType = 0x63
+	SSVARIANTTYPE columnType = 0x62 // Sql_Variant
+)
+
+// Flags of a column in COLMETADATA:
+const (
+	colNullable        = 0x0001
+	colCaseSensitive   = 0x0002
+	colUpdateable      = 0x000C // 2 bits: 0 = read-only, 1 = read/write, 2 = unknown
+	colIdentity        = 0x0010
+	colComputed        = 0x0020
+	colFixedLenCLRType = 0x0100
+	colSparseColumnSet = 0x0400
+	colEncrypted       = 0x0800
+	colHidden          = 0x2000
+	colKey             = 0x4000
+	colNullableUnknown = 0x8000
 )
 
-// Unused at the moment:
-//var fixedLengthTypes = []columnType{NULLTYPE, INT1TYPE, BITTYPE, INT2TYPE, INT4TYPE, DATETIM4TYPE, FLT4TYPE, MONEY4TYPE, MONEYTYPE, DATETIMETYPE, FLT8TYPE, INT8TYPE}
+// The UserType of timestamp (rowversion) columns, which are otherwise sent as binary(8).
+const userTypeTimestamp = 0x0050
+
+// A (max) length of 0xFFFF in the TYPE_INFO of a USHORT-length type means the values are sent as PLP (partially length-prefixed) data.
+const plpSize = 0xFFFF
+
+var fixedLengthTypes = map[columnType]int{
+	NULLTYPE:     0,
+	INT1TYPE:     1,
+	BITTYPE:      1,
+	INT2TYPE:     2,
+	INT4TYPE:     4,
+	DATETIM4TYPE: 4,
+	FLT4TYPE:     4,
+	MONEY4TYPE:   4,
+	MONEYTYPE:    8,
+	DATETIMETYPE: 8,
+	FLT8TYPE:     8,
+	INT8TYPE:     8,
+}
+
+// typeName returns the SQL Server name of the column's type, including its length, precision or scale where applicable, e.g. "nvarchar(50)".
+func (info columnInfo) typeName() string {
+	switch info.columnType {
+	case INT1TYPE:
+		return "tinyint"
+	case BITTYPE, BITNTYPE:
+		return "bit"
+	case INT2TYPE:
+		return "smallint"
+	case INT4TYPE:
+		return "int"
+	case INT8TYPE:
+		return "bigint"
+	case INTNTYPE:
+		switch info.size {
+		case 1:
+			return "tinyint"
+		case 2:
+			return "smallint"
+		case 4:
+			return "int"
+		}
+		return "bigint"
+	case FLT4TYPE:
+		return "real"
+	case FLT8TYPE:
+		return "float"
+	case FLTNTYPE:
+		if info.size == 4 {
+			return "real"
+		}
+		return "float"
+	case MONEY4TYPE:
+		return "smallmoney"
+	case MONEYTYPE:
+		return "money"
+	case MONEYNTYPE:
+		if info.size == 4 {
+			return "smallmoney"
+		}
+		return "money"
+	case DATETIM4TYPE:
+		return "smalldatetime"
+	case DATETIMETYPE:
+		return "datetime"
+	case DATETIMNTYPE:
+		if info.size == 4 {
+			return "smalldatetime"
+		}
+		return "datetime"
+	case DATENTYPE:
+		return "date"
+	case TIMENTYPE:
+		return fmt.Sprintf("time(%d)", info.scale)
+	case DATETIME2NTYPE:
+		return fmt.Sprintf("datetime2(%d)", info.scale)
+	case DATETIMEOFFSETNTYPE:
+		return fmt.Sprintf("datetimeoffset(%d)", info.scale)
+	case DECIMALNTYPE:
+		return fmt.Sprintf("decimal(%d,%d)", info.precision, info.scale)
+	case NUMERICNTYPE:
+		return fmt.Sprintf("numeric(%d,%d)", info.precision, info.scale)
+	case GUIDTYPE:
+		return "uniqueidentifier"
+	case BIGVARBINTYPE:
+		return "varbinary(" + info.lengthDeclaration(1) + ")"
+	case BIGBINARYTYPE:
+		return "binary(" + info.lengthDeclaration(1) + ")"
+	case BIGVARCHRTYPE:
+		return "varchar(" + info.lengthDeclaration(1) + ")"
+	case BIGCHARTYPE:
+		return "char(" + info.lengthDeclaration(1) + ")"
+	case NVARCHARTYPE:
+		return "nvarchar(" + info.lengthDeclaration(2) + ")"
+	case NCHARTYPE:
+		return "nchar(" + info.lengthDeclaration(2) + ")"
+	case TEXTTYPE:
+		return "text"
+	case NTEXTTYPE:
+		return "ntext"
+	case IMAGETYPE:
+		return "image"
+	case XMLTYPE:
+		return "xml"
+	case SSVARIANTTYPE:
+		return "sql_variant"
+	case UDTTYPE:
+		if info.udt != nil {
+			return info.udt.typeName
+		}
+	case NULLTYPE:
+		return "null"
+	}
+	return ""
+}
+
+// lengthDeclaration returns the length of a (var)char, n(var)char or (var)binary column as used in its declaration: the number of characters or "max".
+func (info columnInfo) lengthDeclaration(bytesPerChar int) string {
+	if info.size == plpSize {
+		return "max"
+	}
+	return strconv.Itoa(info.size / bytesPerChar)
+}
 
 type Rows struct {
 	columnNames []string
 	columnTypes []columnInfo
 	buf         *bytes.Buffer
+	// The connection, to deliver INFO messages sent in between the rows to.
+	c *Conn
+	// The length of the whole result, to determine the offset of a ProtocolError.
+	length int
 }
 
 func (r Rows) Columns() []string {
 	return r.columnNames
 }
 
+// ColumnTypeDatabaseTypeName implements the driver.RowsColumnTypeDatabaseTypeName interface.
+// It returns the uppercase name of the type without length, precision or scale, e.g. "NVARCHAR".
+func (r Rows) ColumnTypeDatabaseTypeName(index int) string {
+	info := r.columnTypes[index]
+	if info.userType == userTypeTimestamp {
+		return "TIMESTAMP"
+	}
+	name := info.typeName()
+	if i := strings.IndexByte(name, '('); i >= 0 {
+		name = name[:i]
+	}
+	return strings.ToUpper(name)
+}
+
+// ColumnTypeLength implements the driver.RowsColumnTypeLength interface.
+// The length is in characters for character types and in bytes for binary types, (max) types report math.MaxInt64.
+func (r Rows) ColumnTypeLength(index int) (length int64, ok bool) {
+	info := r.columnTypes[index]
+	switch info.columnType {
+	case BIGVARCHRTYPE, BIGCHARTYPE, BIGVARBINTYPE, BIGBINARYTYPE, UDTTYPE:
+		if info.size == plpSize {
+			return math.MaxInt64, true
+		}
+		return int64(info.size), true
+	case NVARCHARTYPE, NCHARTYPE:
+		if info.size == plpSize {
+			return math.MaxInt64, true
+		}
+		return int64(info.size / 2), true
+	case TEXTTYPE, NTEXTTYPE, IMAGETYPE, XMLTYPE:
+		return math.MaxInt64, true
+	}
+	return 0, false
+}
+
+// ColumnTypeNullable implements the driver.RowsColumnTypeNullable interface.
+func (r Rows) ColumnTypeNullable(index int) (nullable, ok bool) {
+	flags := r.columnTypes[index].flags
+	if flags&colNullableUnknown != 0 {
+		return false, false
+	}
+	return flags&colNullable != 0, true
+}
+
+// ColumnTypePrecisionScale implements the driver.RowsColumnTypePrecisionScale interface.
+// Only decimal and numeric columns report a precision and scale.
+func (r Rows) ColumnTypePrecisionScale(index int) (precision, scale int64, ok bool) {
+	info := r.columnTypes[index]
+	switch info.columnType {
+	case DECIMALNTYPE, NUMERICNTYPE:
+		return int64(info.precision), int64(info.scale), true
+	}
+	return 0, 0, false
+}
+
+// ColumnTypeScanType implements the driver.RowsColumnTypeScanType interface.
+// It returns the type of the values that Next returns for the column.
+func (r Rows) ColumnTypeScanType(index int) reflect.Type {
+	switch r.columnTypes[index].columnType {
+	case INT1TYPE, INT2TYPE, INT4TYPE, INT8TYPE, INTNTYPE:
+		return reflect.TypeOf(int64(0))
+	case BITTYPE, BITNTYPE:
+		return reflect.TypeOf(false)
+	case FLT4TYPE, FLT8TYPE, FLTNTYPE:
+		return reflect.TypeOf(float64(0))
+	case DATETIM4TYPE, DATETIMETYPE, DATETIMNTYPE, DATENTYPE, TIMENTYPE, DATETIME2NTYPE, DATETIMEOFFSETNTYPE:
+		return reflect.TypeOf(time.Time{})
+	case BIGVARCHRTYPE, BIGCHARTYPE, NVARCHARTYPE, NCHARTYPE, TEXTTYPE, NTEXTTYPE, XMLTYPE:
+		return reflect.TypeOf("")
+	case MONEY4TYPE, MONEYTYPE, MONEYNTYPE, DECIMALNTYPE, NUMERICNTYPE, GUIDTYPE,
+		BIGVARBINTYPE, BIGBINARYTYPE, IMAGETYPE, UDTTYPE:
+		return reflect.TypeOf([]byte{})
+	}
+	// NULL and sql_variant, the latter can hold any of the above.
+	return reflect.TypeOf((*interface{})(nil)).Elem()
+}
+
+// readInfo reads an INFO token, whose token byte was just read, and passes it on to the message handler.
+func (r Rows) readInfo() error {
+	var length uint16
+	if err := binary.Read(r.buf, binary.LittleEndian, &length); err != nil {
+		return err
+	}
+	data, err := readBytes(r.buf, int(length))
+	if err != nil {
+		return err
+	}
+	msg, err := r.c.makeError(append([]byte{byte(info), byte(length), byte(length >> 8)}, data...))
+	if err != nil {
+		return err
+	}
+	r.c.handleMessage(msg)
+	return nil
+}
+
 func (r Rows) Close() error {
 	r.buf.Reset()
 	return nil
@@ -73,45 +354,56 @@ func (r Rows) Next(dest []driver.Value) error {
 		errLog.Printf("Nothing left in buffer\n")
 		return io.EOF
 	}
-	if b, err := r.buf.ReadByte(); (err != nil) || (tokenDefinition(b) != row) {
+	start := r.length - r.buf.Len()
+	b, err := r.buf.ReadByte()
+	// Messages such as RAISERROR ... WITH NOWAIT can be sent in between the rows:
+	for err == nil && tokenDefinition(b) == info {
+		if err = r.readInfo(); err != nil {
+			return r.c.protocolError(info, start, err)
+		}
+		start = r.length - r.buf.Len()
+		b, err = r.buf.ReadByte()
+	}
+	if err == nil && tokenDefinition(b) != row && tokenDefinition(b) != nbcRow {
+		// The end of the result, which can be followed by changes to the session:
+		r.buf.UnreadByte()
+		var sqlerrs []SQLError
+		rest, err := r.c.processTokens(r.buf.Bytes(), &sqlerrs)
+		if err != nil {
+			return err
+		}
+		r.buf.Next(r.buf.Len() - len(rest))
+		// An error, e.g. a division by zero halfway through the result, ends it early:
+		if err = r.c.checkErrors(sqlerrs); err != nil {
+			return err
+		}
+		return io.EOF
+	}
+	if err != nil {
 		errLog.Printf("Not a valid token definition at this point: %v \n", b)
 		errLog.Printf("%v \n", err)
 		return io.EOF
 	}
 	if len(r.columnTypes) != len(dest) {
-		panic("Invalid slice-length received")
+		return fmt.Errorf("Expected %v destination values, got %v", len(r.columnTypes), len(dest))
 	}
-	for i, m := range r.columnTypes {
-		var v interface{}
-		switch m.columnType {
-		case INT4TYPE:
-			d := make([]byte, 4, 4)
-			n, err := r.buf.Read(d)
-			if err != nil {
-				return nil
-			}
-			if n != 4 {
-				fmt.Printf("Got %v instead of 4\n", n)
-				return ErrInvalidData
-			}
-			v = int64(d[0]) | int64(d[1]<<8) | int64(d[2]<<16) | int64(d[3]<<24)
-		case NVARCHARTYPE:
-			// Should be nul-aware here:
-			readB_VarChar(r.buf)
-		case NCHARTYPE, BIGCHARTYPE, BIGBINARYTYPE, BIGVARCHRTYPE, BIGVARBINTYPE:
-			// Length is encoded as USHORT:
-			var length uint16
-			rawLength := r.buf.Next(2)
-			length = uint16(rawLength[0]) | uint16(rawLength[1]<<8)
-			_ = length
-			switch m.columnType {
-			default:
-				panic("Haven't implemented these yet, sorry")
-			}
-		default:
-			errLog.Printf("Invalid or iunimplemented token: %v \n", m.columnType)
-			return ErrInvalidData
 
+	// NBCROW (TDS 7.3b and up) starts with a bitmap of the columns that are NULL, which are then left out of the row.
+	var nullBitmap []byte
+	if tokenDefinition(b) == nbcRow {
+		if nullBitmap, err = readBytes(r.buf, (len(r.columnTypes)+7)/8); err != nil {
+			return r.c.protocolError(nbcRow, start, err)
+		}
+	}
+
+	for i, m := range r.columnTypes {
+		if nullBitmap != nil && nullBitmap[i/8]&(1<<uint(i%8)) != 0 {
+			dest[i] = nil
+			continue
+		}
+		v, err := readValue(r.buf, m)
+		if err != nil {
+			return r.c.protocolError(tokenDefinition(b), r.length-r.buf.Len(), err)
 		}
 		dest[i] = v
 	}
@@ -119,44 +411,69 @@ func (r Rows) Next(dest []driver.Value) error {
 }
 
 func (c *Conn) parseResult(raw []byte) (Rows, error) {
-	var rows Rows
-	if tokenDefinition(raw[0]) != colMetaData {
-		panic("Got noMetaData in COLMETADATA, should not happen")
+	rows := Rows{buf: new(bytes.Buffer), c: c, length: len(raw)}
+	if len(raw) == 0 {
+		return rows, nil
+	}
+	switch tokenDefinition(raw[0]) {
+	case colMetaData:
+	case done, doneProc, doneInProc:
+		// The statement didn't return a result set
+		return rows, nil
+	default:
+		return rows, c.protocolError(tokenDefinition(raw[0]), 0, ErrInvalidData)
 	}
+
 	buf := bytes.NewBuffer(raw[1:])
-	fieldcount, err := buf.ReadByte()
-	if err != nil {
-		return rows, err
+	// All errors from here on are caused by invalid COLMETADATA:
+	fail := func(err error) (Rows, error) {
+		return rows, c.protocolError(colMetaData, len(raw)-buf.Len(), err)
+	}
+
+	var fieldcount uint16
+	if err := binary.Read(buf, binary.LittleEndian, &fieldcount); err != nil {
+		return fail(err)
+	}
+	if fieldcount == 0xFFFF {
+		// NoMetaData, only sent when the metadata hasn't changed since a previous result, which we never ask for.
+		return fail(ErrInvalidData)
 	}
-	buf.ReadByte()
-	for i := byte(0); i < fieldcount; i++ {
-		// UserType (we ignore this for now)
+	for i := uint16(0); i < fieldcount; i++ {
+		// UserType
 		// Will always be 0x0000 except for TIMESTAMP (0x0050) and alias types (greater than 0x00FF).
+		var userType uint32
+		var err error
 		if c.tdsVersion >= TDS72 {
 			// ULONG (uint32) in TDS72 and higher
-			_ = buf.Next(4)
+			err = binary.Read(buf, binary.LittleEndian, &userType)
 		} else {
 			// USHORT (uint16) in TDS71 and lower
-			_ = buf.Next(2)
+			var shortUserType uint16
+			err = binary.Read(buf, binary.LittleEndian, &shortUserType)
+			userType = uint32(shortUserType)
 		}
-
-		// Flags, also ignorable for now
-		_ = buf.Next(2)
-
-		// Type info:
-		info, err := parseColumnType(buf)
 		if err != nil {
-			return rows, err
+			return fail(err)
+		}
+
+		var flags uint16
+		if err = binary.Read(buf, binary.LittleEndian, &flags); err != nil {
+			return fail(err)
 		}
 
-		// If  text, ntext and image, table name:
-		if false {
-			//Commented because not yet used:
-			//tablename := readB_VarChar(buf)
+		// Type info, including the table name for text, ntext and image:
+		info, err := c.parseColumnType(buf)
+		if err != nil {
+			return fail(err)
 		}
+		info.userType = userType
+		info.flags = flags
 
 		// Column name
-		columnName := readB_VarChar(buf)
+		columnName, err := readB_VarChar(buf)
+		if err != nil {
+			return fail(err)
+		}
 
 		rows.columnNames = append(rows.columnNames, columnName)
 		rows.columnTypes = append(rows.columnTypes, info)
@@ -165,29 +482,290 @@ func (c *Conn) parseResult(raw []byte) (Rows, error) {
 	return rows, nil
 }
 
-func parseColumnType(buf *bytes.Buffer) (columnInfo, error) {
+// parseColumnType parses the TYPE_INFO of a column in COLMETADATA.
+// For text, ntext and image columns the table name that follows the TYPE_INFO is read as well.
+func (c *Conn) parseColumnType(buf *bytes.Buffer) (columnInfo, error) {
 	var result columnInfo
 	b, err := buf.ReadByte()
-	//fmt.Printf("% X \n", buf.Bytes())
 	if err != nil {
 		return result, err
 	}
-	ctype := columnType(b)
-	result.columnType = ctype
-	// So what follows might be the most useless switch statement in the world. Even the default clause is useless as it will never get executed, unless I really did something wrong.
-	switch ctype & 0x30 {
-	case 0x16:
-		//Zero-length token, nothing to do
-	case 0x30:
-		//Above is the same as:
-		//case NULLTYPE, INT1TYPE, BITTYPE, INT2TYPE, INT4TYPE, DATETIM4TYPE, FLT4TYPE, MONEY4TYPE, MONEYTYPE, DATETIMETYPE, FLT8TYPE, INT8TYPE:
-	// Nothing to do for these, length is contained within type
-	case 0x20:
-		// Variable-length token, length is contained in value
-	case 0x0:
-		// Variable count token,
+	result.columnType = columnType(b)
+
+	if size, ok := fixedLengthTypes[result.columnType]; ok {
+		// Nothing else to read for these, length is implied by the type
+		result.size = size
+		return result, nil
+	}
+
+	switch result.columnType {
+	case GUIDTYPE, INTNTYPE, BITNTYPE, FLTNTYPE, MONEYNTYPE, DATETIMNTYPE:
+		size, err := buf.ReadByte()
+		if err != nil {
+			return result, err
+		}
+		result.size = int(size)
+	case DECIMALNTYPE, NUMERICNTYPE:
+		d, err := readBytes(buf, 3)
+		if err != nil {
+			return result, err
+		}
+		result.size, result.precision, result.scale = int(d[0]), d[1], d[2]
+	case DATENTYPE:
+		result.size = 3
+	case TIMENTYPE, DATETIME2NTYPE, DATETIMEOFFSETNTYPE:
+		if result.scale, err = buf.ReadByte(); err != nil {
+			return result, err
+		}
+		// The length of the time part depends on the scale, the date takes 3 more bytes and the offset another 2:
+		result.size = 5
+		if result.scale <= 2 {
+			result.size = 3
+		} else if result.scale <= 4 {
+			result.size = 4
+		}
+		switch result.columnType {
+		case DATETIME2NTYPE:
+			result.size += 3
+		case DATETIMEOFFSETNTYPE:
+			result.size += 5
+		}
+	case SSVARIANTTYPE:
+		var size uint32
+		if err := binary.Read(buf, binary.LittleEndian, &size); err != nil {
+			return result, err
+		}
+		result.size = int(size)
+	case BIGVARBINTYPE, BIGBINARYTYPE:
+		var size uint16
+		if err := binary.Read(buf, binary.LittleEndian, &size); err != nil {
+			return result, err
+		}
+		result.size = int(size)
+	case BIGVARCHRTYPE, BIGCHARTYPE, NVARCHARTYPE, NCHARTYPE:
+		var size uint16
+		if err := binary.Read(buf, binary.LittleEndian, &size); err != nil {
+			return result, err
+		}
+		result.size = int(size)
+		if result.collation, err = readBytes(buf, 5); err != nil {
+			return result, err
+		}
+	case UDTTYPE:
+		var size uint16
+		if err := binary.Read(buf, binary.LittleEndian, &size); err != nil {
+			return result, err
+		}
+		result.size = int(size)
+		if result.udt, err = readUDTInfo(buf); err != nil {
+			return result, err
+		}
+	case XMLTYPE:
+		result.size = plpSize
+		if result.xmlSchema, err = readXMLSchemaInfo(buf); err != nil {
+			return result, err
+		}
+	case TEXTTYPE, NTEXTTYPE, IMAGETYPE:
+		var size uint32
+		if err := binary.Read(buf, binary.LittleEndian, &size); err != nil {
+			return result, err
+		}
+		result.size = int(size)
+		if result.columnType != IMAGETYPE {
+			if result.collation, err = readBytes(buf, 5); err != nil {
+				return result, err
+			}
+		}
+		if result.tableName, err = c.readTableName(buf); err != nil {
+			return result, err
+		}
 	default:
-		panic(fmt.Sprintf("unknown column type: %x", b))
+		return result, fmt.Errorf("unknown column type: %x", b)
 	}
 	return result, nil
 }
+
+// readTableName reads the table name of a text, ntext or image column.
+// Since TDS 7.2 this is sent as a multi-part name (e.g. database, schema and table), before that as a single US_VARCHAR.
+func (c *Conn) readTableName(buf *bytes.Buffer) ([]string, error) {
+	if c.tdsVersion < TDS72 {
+		name, err := readUS_VarChar(buf)
+		if err != nil {
+			return nil, err
+		}
+		return []string{name}, nil
+	}
+
+	numParts, err := buf.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	parts := make([]string, numParts)
+	for i := range parts {
+		if parts[i], err = readUS_VarChar(buf); err != nil {
+			return nil, err
+		}
+	}
+	return parts, nil
+}
+
+// readValue reads a single value from a ROW token according to the TYPE_INFO of its column.
+func readValue(buf *bytes.Buffer, info columnInfo) (driver.Value, error) {
+	var d []byte
+	var err error
+
+	if size, ok := fixedLengthTypes[info.columnType]; ok {
+		if size == 0 {
+			return nil, nil
+		}
+		d, err = readBytes(buf, size)
+	} else {
+		switch info.columnType {
+		case GUIDTYPE, INTNTYPE, BITNTYPE, FLTNTYPE, MONEYNTYPE, DATETIMNTYPE,
+			DECIMALNTYPE, NUMERICNTYPE, DATENTYPE, TIMENTYPE, DATETIME2NTYPE, DATETIMEOFFSETNTYPE:
+			d, err = readBYTELenBytes(buf)
+		case NVARCHARTYPE, NCHARTYPE, BIGCHARTYPE, BIGVARCHRTYPE, BIGBINARYTYPE, BIGVARBINTYPE:
+			if info.size == plpSize {
+				d, err = readPLP(buf)
+			} else {
+				d, err = readUSHORTLenBytes(buf)
+			}
+		case XMLTYPE, UDTTYPE:
+			d, err = readPLP(buf)
+		case TEXTTYPE, NTEXTTYPE, IMAGETYPE:
+			d, err = readTextPtrBytes(buf)
+		case SSVARIANTTYPE:
+			return readVariant(buf)
+		default:
+			errLog.Printf("Invalid or unimplemented type: %v \n", info.columnType)
+			return nil, ErrInvalidData
+		}
+	}
+
+	if err != nil || d == nil {
+		return nil, err
+	}
+	return convertValue(d, info)
+}
+
+// convertValue converts the raw bytes of a (non-NULL) value to the proper Go type.
+func convertValue(d []byte, info columnInfo) (driver.Value, error) {
+	switch info.columnType {
+	case INT1TYPE, INT2TYPE, INT4TYPE, INT8TYPE, INTNTYPE:
+		return decodeInt(d)
+	case BITTYPE, BITNTYPE:
+		return decodeBit(d)
+	case FLT4TYPE, FLT8TYPE, FLTNTYPE:
+		return decodeFloat(d)
+	case MONEY4TYPE, MONEYTYPE, MONEYNTYPE:
+		return decodeMoney(d)
+	case DECIMALNTYPE, NUMERICNTYPE:
+		return decodeDecimal(d, info.scale)
+	case DATETIM4TYPE, DATETIMETYPE, DATETIMNTYPE:
+		return decodeDateTime(d)
+	case DATENTYPE:
+		return decodeDate(d)
+	case TIMENTYPE:
+		return decodeTime(d, info.scale)
+	case DATETIME2NTYPE:
+		return decodeDateTime2(d, info.scale)
+	case DATETIMEOFFSETNTYPE:
+		return decodeDateTimeOffset(d, info.scale)
+	case GUIDTYPE:
+		if len(d) != 16 {
+			return nil, ErrInvalidData
+		}
+		return d, nil
+	case NVARCHARTYPE, NCHARTYPE, NTEXTTYPE:
+		return utf16c.Decode(d), nil
+	case BIGCHARTYPE, BIGVARCHRTYPE, TEXTTYPE:
+		return decodeCharData(d, info.collation), nil
+	case XMLTYPE:
+		return decodeXML(d), nil
+	case BIGBINARYTYPE, BIGVARBINTYPE, IMAGETYPE, UDTTYPE:
+		return d, nil
+	}
+	errLog.Printf("Invalid or unimplemented type: %v \n", info.columnType)
+	return nil, ErrInvalidData
+}
+
+// readBYTELenBytes reads a value prefixed by its length as a BYTE.
+// A length of 0 denotes NULL, in which case nil is returned.
+func readBYTELenBytes(buf *bytes.Buffer) ([]byte, error) {
+	length, err := buf.ReadByte()
+	if err != nil || length == 0 {
+		return nil, err
+	}
+	return readBytes(buf, int(length))
+}
+
+// readUSHORTLenBytes reads a value prefixed by its length as an USHORT.
+// A length of 0xFFFF denotes NULL, in which case nil is returned.
+func readUSHORTLenBytes(buf *bytes.Buffer) ([]byte, error) {
+	var length uint16
+	if err := binary.Read(buf, binary.LittleEndian, &length); err != nil {
+		return nil, err
+	}
+	if length == 0xFFFF {
+		return nil, nil
+	}
+	return readBytes(buf, int(length))
+}
+
+// readTextPtrBytes reads a text, ntext or image value.
+// These are preceded by a text pointer and a timestamp, neither of which we use. A text pointer of length 0 denotes NULL.
+func readTextPtrBytes(buf *bytes.Buffer) ([]byte, error) {
+	textPtrLength, err := buf.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	if textPtrLength == 0 {
+		return nil, nil
+	}
+
+	// Text pointer, followed by the 8-byte timestamp:
+	if _, err := readBytes(buf, int(textPtrLength)+8); err != nil {
+		return nil, err
+	}
+
+	var length uint32
+	if err := binary.Read(buf, binary.LittleEndian, &length); err != nil {
+		return nil, err
+	}
+	return readBytes(buf, int(length))
+}
+
+// readPLP reads a PLP (partially length-prefixed) value, as used by the (max) types.
+// The value is sent as an ULONGLONG total length, followed by chunks that are each prefixed with their ULONG length until a chunk of length 0 is encountered.
+func readPLP(buf *bytes.Buffer) ([]byte, error) {
+	var totalLength uint64
+	if err := binary.Read(buf, binary.LittleEndian, &totalLength); err != nil {
+		return nil, err
+	}
+	switch totalLength {
+	case 0xFFFFFFFFFFFFFFFF: // PLP_NULL
+		return nil, nil
+	case 0xFFFFFFFFFFFFFFFE: // UNKNOWN_PLP_LEN
+		totalLength = 0
+	}
+
+	if totalLength > uint64(buf.Len()) {
+		// Don't trust the announced length further than the data we actually have
+		totalLength = uint64(buf.Len())
+	}
+	result := make([]byte, 0, int(totalLength))
+	for {
+		var chunkLength uint32
+		if err := binary.Read(buf, binary.LittleEndian, &chunkLength); err != nil {
+			return nil, err
+		}
+		if chunkLength == 0 {
+			return result, nil
+		}
+		chunk, err := readBytes(buf, int(chunkLength))
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, chunk...)
+	}
+}
@@ -1,8 +1,11 @@
 package gotds
 
 import (
+	"bytes"
 	"database/sql/driver"
 	//"fmt"
+	"io"
+	"reflect"
 	"testing"
 )
 
@@ -10,7 +13,7 @@ func TestParseSimpleSelectResult(t *testing.T) {
 	c := Conn{tdsVersion: TDS72}
 	// Original query: "SELECT 1, 2, 3"
 	raw := []byte{0x81, 0x03, 0x00, 0x00, 0x00, 0x00, 0x00, 0x20, 0x00, 0x38, 0x00, 0x00, 0x00, 0x00, 0x00, 0x20, 0x00, 0x38, 0x00, 0x00, 0x00, 0x00, 0x00, 0x20, 0x00, 0x38, 0x00, 0xd1, 0x01, 0x00, 0x00, 0x00, 0x02, 0x00, 0x00, 0x00, 0x03, 0x00, 0x00, 0x00, 0xfd, 0x10, 0x00, 0xc1, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}
-	result, err := c.parseResult(raw[1:])
+	result, err := c.parseResult(raw)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -25,3 +28,197 @@ func TestParseSimpleSelectResult(t *testing.T) {
 		t.Fatal("Did not receive expected values [1 2 3], got: ", values)
 	}
 }
+
+func TestParseBinaryResult(t *testing.T) {
+	c := Conn{tdsVersion: TDS72}
+	// Original query: "SELECT CAST(0x0102 AS varbinary(10)), CAST(NULL AS binary(2)), CAST('abc' AS varchar(10))"
+	raw := []byte{0x81, 0x03, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x01, 0x00, 0xa5, 0x0a, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x01, 0x00, 0xad, 0x02, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x01, 0x00, 0xa7, 0x0a, 0x00, 0x09, 0x04, 0xd0, 0x00, 0x34, 0x00,
+		0xd1,
+		0x02, 0x00, 0x01, 0x02,
+		0xff, 0xff,
+		0x03, 0x00, 'a', 'b', 0x80}
+	result, err := c.parseResult(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	values := make([]driver.Value, 3)
+	err = result.Next(values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(values[0].([]byte), []byte{1, 2}) {
+		t.Fatal("Did not receive expected varbinary value, got: ", values[0])
+	}
+	if values[1] != nil {
+		t.Fatal("Expected NULL for binary value, got: ", values[1])
+	}
+	if values[2].(string) != "ab€" {
+		t.Fatal("Did not receive expected varchar value, got: ", values[2])
+	}
+}
+
+func TestParseLegacyLOBResult(t *testing.T) {
+	c := Conn{tdsVersion: TDS72}
+	// Original query: "SELECT img, txt FROM [dbo].[blobs]", with one row holding 0xCAFE and NULL
+	raw := []byte{0x81, 0x02, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x09, 0x00, 0x22, 0xff, 0xff, 0xff, 0x7f,
+		0x02, 0x03, 0x00, 'd', 0x00, 'b', 0x00, 'o', 0x00, 0x05, 0x00, 'b', 0x00, 'l', 0x00, 'o', 0x00, 'b', 0x00, 's', 0x00,
+		0x03, 'i', 0x00, 'm', 0x00, 'g', 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x09, 0x00, 0x23, 0xff, 0xff, 0xff, 0x7f, 0x09, 0x04, 0xd0, 0x00, 0x34,
+		0x02, 0x03, 0x00, 'd', 0x00, 'b', 0x00, 'o', 0x00, 0x05, 0x00, 'b', 0x00, 'l', 0x00, 'o', 0x00, 'b', 0x00, 's', 0x00,
+		0x03, 't', 0x00, 'x', 0x00, 't', 0x00,
+		0xd1,
+		0x10, 0xa0, 0xa1, 0xa2, 0xa3, 0xa4, 0xa5, 0xa6, 0xa7, 0xa8, 0xa9, 0xaa, 0xab, 0xac, 0xad, 0xae, 0xaf,
+		0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08,
+		0x02, 0x00, 0x00, 0x00, 0xca, 0xfe,
+		0x00}
+	result, err := c.parseResult(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.columnTypes[0].tableName) != 2 || result.columnTypes[0].tableName[1] != "blobs" {
+		t.Fatal("Did not parse the table name of the image column, got: ", result.columnTypes[0].tableName)
+	}
+	if result.Columns()[1] != "txt" {
+		t.Fatal("Did not parse the column name of the text column, got: ", result.Columns()[1])
+	}
+
+	values := make([]driver.Value, 2)
+	err = result.Next(values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(values[0].([]byte), []byte{0xca, 0xfe}) {
+		t.Fatal("Did not receive expected image value, got: ", values[0])
+	}
+	if values[1] != nil {
+		t.Fatal("Expected NULL for text value, got: ", values[1])
+	}
+}
+
+func TestColumnTypes(t *testing.T) {
+	c := Conn{tdsVersion: TDS72}
+	// Original query: "SELECT name, amount, version, id FROM [dbo].[orders]",
+	// with name nvarchar(50) NULL, amount decimal(10,2) NOT NULL, version timestamp and id int NOT NULL
+	raw := []byte{0x81, 0x04, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x09, 0x00, 0xe7, 0x64, 0x00, 0x09, 0x04, 0xd0, 0x00, 0x34, 0x01, 'a', 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x08, 0x00, 0x6a, 0x09, 0x0a, 0x02, 0x01, 'b', 0x00,
+		0x50, 0x00, 0x00, 0x00, 0x00, 0x00, 0xad, 0x08, 0x00, 0x01, 'c', 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x18, 0x00, 0x38, 0x01, 'd', 0x00}
+	result, err := c.parseResult(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names := []string{"NVARCHAR", "DECIMAL", "TIMESTAMP", "INT"}
+	for i, name := range names {
+		if got := result.ColumnTypeDatabaseTypeName(i); got != name {
+			t.Fatal("Expected database type name ", name, ", got: ", got)
+		}
+	}
+
+	if length, ok := result.ColumnTypeLength(0); !ok || length != 50 {
+		t.Fatal("Expected a length of 50 characters, got: ", length, ok)
+	}
+	if length, ok := result.ColumnTypeLength(2); !ok || length != 8 {
+		t.Fatal("Expected a length of 8 bytes, got: ", length, ok)
+	}
+	if _, ok := result.ColumnTypeLength(3); ok {
+		t.Fatal("Expected no length for int")
+	}
+
+	if nullable, ok := result.ColumnTypeNullable(0); !ok || !nullable {
+		t.Fatal("Expected nullable column, got: ", nullable, ok)
+	}
+	if nullable, ok := result.ColumnTypeNullable(1); !ok || nullable {
+		t.Fatal("Expected non-nullable column, got: ", nullable, ok)
+	}
+
+	if precision, scale, ok := result.ColumnTypePrecisionScale(1); !ok || precision != 10 || scale != 2 {
+		t.Fatal("Expected decimal(10,2), got: ", precision, scale, ok)
+	}
+	if _, _, ok := result.ColumnTypePrecisionScale(0); ok {
+		t.Fatal("Expected no precision and scale for nvarchar")
+	}
+
+	scanTypes := []reflect.Type{reflect.TypeOf(""), reflect.TypeOf([]byte{}), reflect.TypeOf([]byte{}), reflect.TypeOf(int64(0))}
+	for i, scanType := range scanTypes {
+		if got := result.ColumnTypeScanType(i); got != scanType {
+			t.Fatal("Expected scan type ", scanType, ", got: ", got)
+		}
+	}
+}
+
+func TestParseNBCRowResult(t *testing.T) {
+	c := Conn{tdsVersion: TDS73}
+	// Original query: "SELECT 1, CAST(NULL AS int), 3" with three nullable int columns, the second sent as NULL through the bitmap of an NBCROW
+	raw := []byte{0x81, 0x03, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x01, 0x00, 0x26, 0x04, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x01, 0x00, 0x26, 0x04, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x01, 0x00, 0x26, 0x04, 0x00,
+		0xd2, 0x02,
+		0x04, 0x01, 0x00, 0x00, 0x00,
+		0x04, 0x03, 0x00, 0x00, 0x00,
+		0xd1,
+		0x04, 0x04, 0x00, 0x00, 0x00,
+		0x04, 0x05, 0x00, 0x00, 0x00,
+		0x04, 0x06, 0x00, 0x00, 0x00}
+	result, err := c.parseResult(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	values := make([]driver.Value, 3)
+	if err = result.Next(values); err != nil {
+		t.Fatal(err)
+	}
+	if values[0].(int64) != 1 || values[1] != nil || values[2].(int64) != 3 {
+		t.Fatal("Did not receive expected values [1 <nil> 3], got: ", values)
+	}
+
+	// A regular ROW may follow an NBCROW:
+	if err = result.Next(values); err != nil {
+		t.Fatal(err)
+	}
+	if values[0].(int64) != 4 || values[1].(int64) != 5 || values[2].(int64) != 6 {
+		t.Fatal("Did not receive expected values [4 5 6], got: ", values)
+	}
+}
+
+func FuzzParseResult(f *testing.F) {
+	f.Add([]byte{0x81, 0x03, 0x00, 0x00, 0x00, 0x00, 0x00, 0x20, 0x00, 0x38, 0x00, 0x00, 0x00, 0x00, 0x00, 0x20, 0x00, 0x38, 0x00, 0x00, 0x00, 0x00, 0x00, 0x20, 0x00, 0x38, 0x00, 0xd1, 0x01, 0x00, 0x00, 0x00, 0x02, 0x00, 0x00, 0x00, 0x03, 0x00, 0x00, 0x00, 0xfd, 0x10, 0x00, 0xc1, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00})
+	f.Add([]byte{0x81, 0x02, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x01, 0x00, 0xe7, 0xff, 0xff, 0x09, 0x04, 0xd0, 0x00, 0x34, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x01, 0x00, 0x62, 0x10, 0x00, 0x00, 0x00, 0x00,
+		0xd2, 0x02, 0xfe, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x02, 0x00, 0x00, 0x00, 'a', 0x00, 0x00, 0x00, 0x00, 0x00})
+	f.Fuzz(func(t *testing.T, raw []byte) {
+		c := Conn{tdsVersion: TDS73}
+		result, err := c.parseResult(raw)
+		if err != nil {
+			return
+		}
+		values := make([]driver.Value, len(result.columnTypes))
+		for i := 0; i < 100 && result.Next(values) == nil; i++ {
+		}
+	})
+}
+
+func TestParseTruncatedResult(t *testing.T) {
+	c := Conn{tdsVersion: TDS72}
+	// The column name of "SELECT 1 AS a" is cut off:
+	_, err := c.parseResult([]byte{0x81, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x38, 0x01})
+	protocolErr, ok := err.(ProtocolError)
+	if !ok {
+		t.Fatal("Expected a ProtocolError, got: ", err)
+	}
+	if protocolErr.Token != 0x81 || protocolErr.Offset != 11 || protocolErr.Err != io.ErrUnexpectedEOF {
+		t.Fatal("Did not receive expected ProtocolError, got: ", protocolErr)
+	}
+	if c.State != Error {
+		t.Fatal("Expected a ProtocolError to mark the connection as dead")
+	}
+}
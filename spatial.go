@@ -0,0 +1,359 @@
+package gotds
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+var ErrInvalidSpatial = errors.New("Invalid geometry or geography value")
+
+// Geometry holds a geometry value as its SRID and its WKT (well-known text) representation, e.g. "POINT (1 2)".
+// It implements sql.Scanner to decode the serialized value of a geometry column.
+// Only points, linestrings and polygons are supported.
+type Geometry struct {
+	SRID int32
+	WKT  string
+}
+
+// Scan implements the sql.Scanner interface. NULL is scanned as a zero Geometry.
+func (g *Geometry) Scan(src interface{}) error {
+	var err error
+	switch v := src.(type) {
+	case nil:
+		*g = Geometry{}
+	case []byte:
+		g.SRID, g.WKT, err = decodeSpatial(v, false)
+	default:
+		err = fmt.Errorf("cannot scan %T into Geometry", src)
+	}
+	return err
+}
+
+// Geography holds a geography value as its SRID and its WKT (well-known text) representation, e.g. "POINT (-122.35 47.65)".
+// Like SQL Server itself, the WKT lists longitude before latitude.
+// It implements sql.Scanner to decode the serialized value of a geography column.
+// Only points, linestrings and polygons are supported.
+type Geography struct {
+	SRID int32
+	WKT  string
+}
+
+// Scan implements the sql.Scanner interface. NULL is scanned as a zero Geography.
+func (g *Geography) Scan(src interface{}) error {
+	var err error
+	switch v := src.(type) {
+	case nil:
+		*g = Geography{}
+	case []byte:
+		g.SRID, g.WKT, err = decodeSpatial(v, true)
+	default:
+		err = fmt.Errorf("cannot scan %T into Geography", src)
+	}
+	return err
+}
+
+// Serialization properties of geometry and geography values:
+const (
+	spatialHasZ          = 0x01
+	spatialHasM          = 0x02
+	spatialIsValid       = 0x04
+	spatialSinglePoint   = 0x08
+	spatialSingleSegment = 0x10
+)
+
+// OpenGIS types of the shapes in a geometry or geography value:
+const (
+	shapePoint      = 1
+	shapeLineString = 2
+	shapePolygon    = 3
+)
+
+type spatialPoint struct {
+	x, y, z, m float64
+}
+
+type spatialFigure struct {
+	pointOffset int
+}
+
+type spatialShape struct {
+	figureOffset int
+	openGISType  byte
+}
+
+// spatialReader reads the serialized geometry or geography format.
+type spatialReader struct {
+	data []byte
+	pos  int
+}
+
+func (r *spatialReader) readBytes(n int) ([]byte, error) {
+	if n < 0 || len(r.data)-r.pos < n {
+		return nil, ErrInvalidSpatial
+	}
+	b := r.data[r.pos : r.pos+n]
+	r.pos += n
+	return b, nil
+}
+
+func (r *spatialReader) readByte() (byte, error) {
+	b, err := r.readBytes(1)
+	if err != nil {
+		return 0, err
+	}
+	return b[0], nil
+}
+
+func (r *spatialReader) readInt32() (int32, error) {
+	b, err := r.readBytes(4)
+	if err != nil {
+		return 0, err
+	}
+	return int32(binary.LittleEndian.Uint32(b)), nil
+}
+
+// readCount reads a number of points, figures or shapes, each of which take up at least size bytes.
+func (r *spatialReader) readCount(size int) (int, error) {
+	n, err := r.readInt32()
+	if err != nil {
+		return 0, err
+	}
+	if n < 0 || int(n) > (len(r.data)-r.pos)/size {
+		return 0, ErrInvalidSpatial
+	}
+	return int(n), nil
+}
+
+func (r *spatialReader) readFloat64() (float64, error) {
+	b, err := r.readBytes(8)
+	if err != nil {
+		return 0, err
+	}
+	return math.Float64frombits(binary.LittleEndian.Uint64(b)), nil
+}
+
+// readPoints reads n points. Geography stores latitude before longitude, these are swapped so that x is always the longitude.
+func (r *spatialReader) readPoints(n int, geography bool) ([]spatialPoint, error) {
+	points := make([]spatialPoint, n)
+	var err error
+	for i := range points {
+		if points[i].x, err = r.readFloat64(); err != nil {
+			return nil, err
+		}
+		if points[i].y, err = r.readFloat64(); err != nil {
+			return nil, err
+		}
+		if geography {
+			points[i].x, points[i].y = points[i].y, points[i].x
+		}
+	}
+	return points, nil
+}
+
+// decodeSpatial decodes a serialized geometry or geography value into its SRID and WKT.
+func decodeSpatial(data []byte, geography bool) (int32, string, error) {
+	r := spatialReader{data: data}
+	srid, err := r.readInt32()
+	if err != nil {
+		return 0, "", err
+	}
+	version, err := r.readByte()
+	if err != nil {
+		return 0, "", err
+	}
+	if version != 1 && version != 2 {
+		return 0, "", ErrInvalidSpatial
+	}
+	properties, err := r.readByte()
+	if err != nil {
+		return 0, "", err
+	}
+
+	var points []spatialPoint
+	switch {
+	case properties&spatialSinglePoint != 0:
+		points, err = r.readPoints(1, geography)
+	case properties&spatialSingleSegment != 0:
+		points, err = r.readPoints(2, geography)
+	default:
+		var n int
+		if n, err = r.readCount(16); err == nil {
+			points, err = r.readPoints(n, geography)
+		}
+	}
+	if err != nil {
+		return 0, "", err
+	}
+
+	hasZ, hasM := properties&spatialHasZ != 0, properties&spatialHasM != 0
+	if hasZ {
+		for i := range points {
+			if points[i].z, err = r.readFloat64(); err != nil {
+				return 0, "", err
+			}
+		}
+	}
+	if hasM {
+		for i := range points {
+			if points[i].m, err = r.readFloat64(); err != nil {
+				return 0, "", err
+			}
+		}
+	}
+
+	w := wktWriter{hasZ: hasZ, hasM: hasM}
+	if properties&spatialSinglePoint != 0 {
+		w.writePoint(points)
+		return srid, w.String(), nil
+	}
+	if properties&spatialSingleSegment != 0 {
+		w.writeLineString(points)
+		return srid, w.String(), nil
+	}
+
+	figures, shapes, err := r.readFiguresAndShapes()
+	if err != nil {
+		return 0, "", err
+	}
+	if len(shapes) == 0 {
+		return srid, "", ErrInvalidSpatial
+	}
+	if len(shapes) > 1 {
+		// Collections (MultiPoint, MultiPolygon, etc.) consist of multiple shapes
+		return srid, "", fmt.Errorf("unsupported spatial type: %v", shapes[0].openGISType)
+	}
+
+	// A single shape owns all figures, each of which owns the points up to the next figure:
+	var parts [][]spatialPoint
+	for i, f := range figures {
+		end := len(points)
+		if i+1 < len(figures) {
+			end = figures[i+1].pointOffset
+		}
+		if f.pointOffset < 0 || f.pointOffset > end || end > len(points) {
+			return srid, "", ErrInvalidSpatial
+		}
+		parts = append(parts, points[f.pointOffset:end])
+	}
+
+	switch shapes[0].openGISType {
+	case shapePoint:
+		w.writePoint(points)
+	case shapeLineString:
+		w.writeLineString(points)
+	case shapePolygon:
+		w.writePolygon(parts)
+	default:
+		return srid, "", fmt.Errorf("unsupported spatial type: %v", shapes[0].openGISType)
+	}
+	return srid, w.String(), nil
+}
+
+// readFiguresAndShapes reads the figures and shapes that follow the points of a value which is neither a single point nor a single line segment.
+func (r *spatialReader) readFiguresAndShapes() ([]spatialFigure, []spatialShape, error) {
+	n, err := r.readCount(5)
+	if err != nil {
+		return nil, nil, err
+	}
+	figures := make([]spatialFigure, n)
+	for i := range figures {
+		// Attribute (interior / exterior ring etc.), which doesn't matter for WKT
+		if _, err = r.readByte(); err != nil {
+			return nil, nil, err
+		}
+		offset, err := r.readInt32()
+		if err != nil {
+			return nil, nil, err
+		}
+		figures[i].pointOffset = int(offset)
+	}
+
+	if n, err = r.readCount(9); err != nil {
+		return nil, nil, err
+	}
+	shapes := make([]spatialShape, n)
+	for i := range shapes {
+		// Parent offset, only relevant for collections
+		if _, err = r.readInt32(); err != nil {
+			return nil, nil, err
+		}
+		offset, err := r.readInt32()
+		if err != nil {
+			return nil, nil, err
+		}
+		shapes[i].figureOffset = int(offset)
+		if shapes[i].openGISType, err = r.readByte(); err != nil {
+			return nil, nil, err
+		}
+	}
+	return figures, shapes, nil
+}
+
+// wktWriter builds the WKT representation of a shape.
+type wktWriter struct {
+	strings.Builder
+	hasZ, hasM bool
+}
+
+func (w *wktWriter) writeCoordinates(points []spatialPoint) {
+	w.WriteByte('(')
+	for i, p := range points {
+		if i > 0 {
+			w.WriteString(", ")
+		}
+		w.WriteString(formatSpatialFloat(p.x) + " " + formatSpatialFloat(p.y))
+		if w.hasZ || w.hasM {
+			if w.hasZ && !math.IsNaN(p.z) {
+				w.WriteString(" " + formatSpatialFloat(p.z))
+			} else {
+				w.WriteString(" NULL")
+			}
+		}
+		if w.hasM {
+			w.WriteString(" " + formatSpatialFloat(p.m))
+		}
+	}
+	w.WriteByte(')')
+}
+
+func (w *wktWriter) writePoint(points []spatialPoint) {
+	w.WriteString("POINT ")
+	if len(points) == 0 {
+		w.WriteString("EMPTY")
+		return
+	}
+	w.writeCoordinates(points[:1])
+}
+
+func (w *wktWriter) writeLineString(points []spatialPoint) {
+	w.WriteString("LINESTRING ")
+	if len(points) == 0 {
+		w.WriteString("EMPTY")
+		return
+	}
+	w.writeCoordinates(points)
+}
+
+func (w *wktWriter) writePolygon(rings [][]spatialPoint) {
+	w.WriteString("POLYGON ")
+	if len(rings) == 0 {
+		w.WriteString("EMPTY")
+		return
+	}
+	w.WriteByte('(')
+	for i, ring := range rings {
+		if i > 0 {
+			w.WriteString(", ")
+		}
+		w.writeCoordinates(ring)
+	}
+	w.WriteByte(')')
+}
+
+func formatSpatialFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
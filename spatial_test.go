@@ -0,0 +1,69 @@
+package gotds
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// writeSpatialHeader writes the SRID, version and properties of a serialized geometry or geography value.
+func writeSpatialHeader(b *bytes.Buffer, srid int32, properties byte) {
+	binary.Write(b, binary.LittleEndian, srid)
+	b.WriteByte(1)
+	b.WriteByte(properties)
+}
+
+func TestDecodeGeographyPoint(t *testing.T) {
+	b := new(bytes.Buffer)
+	writeSpatialHeader(b, 4326, spatialIsValid|spatialSinglePoint)
+	binary.Write(b, binary.LittleEndian, []float64{47.65, -122.35}) // Latitude first
+
+	var g Geography
+	if err := g.Scan(b.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	if g.SRID != 4326 || g.WKT != "POINT (-122.35 47.65)" {
+		t.Fatal("Did not receive expected geography, got: ", g)
+	}
+}
+
+func TestDecodeGeometryPolygon(t *testing.T) {
+	b := new(bytes.Buffer)
+	writeSpatialHeader(b, 0, spatialIsValid)
+	// Points:
+	binary.Write(b, binary.LittleEndian, int32(8))
+	binary.Write(b, binary.LittleEndian, []float64{0, 0, 10, 0, 10, 10, 0, 0})
+	binary.Write(b, binary.LittleEndian, []float64{2, 2, 3, 2, 3, 3, 2, 2})
+	// Figures, an exterior and an interior ring:
+	binary.Write(b, binary.LittleEndian, int32(2))
+	b.Write([]byte{0x02, 0x00, 0x00, 0x00, 0x00})
+	b.Write([]byte{0x00, 0x04, 0x00, 0x00, 0x00})
+	// Shapes:
+	binary.Write(b, binary.LittleEndian, int32(1))
+	binary.Write(b, binary.LittleEndian, []int32{-1, 0})
+	b.WriteByte(shapePolygon)
+
+	var g Geometry
+	if err := g.Scan(b.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	expected := "POLYGON ((0 0, 10 0, 10 10, 0 0), (2 2, 3 2, 3 3, 2 2))"
+	if g.WKT != expected {
+		t.Fatal("Did not receive expected geometry, got: ", g.WKT)
+	}
+
+	// Truncated:
+	if err := g.Scan(b.Bytes()[:b.Len()-3]); err == nil {
+		t.Fatal("Expected an error for a truncated geometry")
+	}
+}
+
+func FuzzDecodeSpatial(f *testing.F) {
+	b := new(bytes.Buffer)
+	writeSpatialHeader(b, 4326, spatialIsValid|spatialSinglePoint)
+	binary.Write(b, binary.LittleEndian, [2]float64{47.65, -122.35})
+	f.Add(b.Bytes(), true)
+	f.Fuzz(func(t *testing.T, d []byte, geography bool) {
+		decodeSpatial(d, geography)
+	})
+}
@@ -3,7 +3,10 @@ package gotds
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
 	"fmt"
+	"io"
+	"strings"
 )
 
 type SQLError struct {
@@ -29,55 +32,204 @@ func (e SQLError) Error() string {
 	return fmt.Sprintf("Msg %v, Level %v, State %v, Line %v\n%v", e.Number, e.Class, e.State, e.Line, e.Text)
 }
 
-func (c *Conn) makeError(raw []byte) SQLError {
+// ProtocolError is returned when a response from the server can't be decoded, e.g. because it is truncated or malformed.
+// The connection can't be used anymore afterwards, since there's no telling where the next token starts.
+type ProtocolError struct {
+	// The offset in the response at which decoding failed.
+	Offset int
+	// The token that was being decoded.
+	Token byte
+	// What went wrong, e.g. ErrInvalidData or io.ErrUnexpectedEOF.
+	Err error
+}
+
+func (e ProtocolError) Error() string {
+	return fmt.Sprintf("Invalid data in token 0x%02X at offset %v: %v", e.Token, e.Offset, e.Err)
+}
+
+func (e ProtocolError) Unwrap() error {
+	return e.Err
+}
+
+// protocolError wraps an error that occurred while decoding a token, marking the connection dead.
+// A premature io.EOF is reported as io.ErrUnexpectedEOF.
+func (c *Conn) protocolError(token tokenDefinition, offset int, err error) error {
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if c != nil {
+		c.State = Error
+	}
+	return ProtocolError{Offset: offset, Token: byte(token), Err: err}
+}
+
+// ServerError holds all errors the server sent in response to a single request, e.g. every constraint violation of a multi-statement batch.
+// The fields of the first error are available directly, the others through Errors.
+// Each of them can be retrieved with errors.As, which returns the first:
+//
+//	var sqlerr gotds.SQLError
+//	if errors.As(err, &sqlerr) && sqlerr.Number == 2627 {
+//		// Violation of a unique constraint
+//	}
+type ServerError struct {
+	SQLError
+	// All errors in the order they were sent, including the first.
+	Errors []SQLError
+}
+
+// Errors of this class and up are fatal, the server closes the connection after sending them.
+const fatalErrorClass = 20
+
+// The login failed because of the password, which can be changed by logging in with Config.NewPassword.
+// A ServerError holding the error of the server matches these with errors.Is.
+var (
+	ErrPasswordExpired    = errors.New("The password of the login has expired")
+	ErrPasswordMustChange = errors.New("The password of the login must be changed")
+)
+
+// The numbers of the errors behind ErrPasswordExpired and ErrPasswordMustChange.
+const (
+	errNumPasswordExpired    = 18487
+	errNumPasswordMustChange = 18488
+)
+
+func (e ServerError) Error() string {
+	if len(e.Errors) <= 1 {
+		return e.SQLError.Error()
+	}
+	messages := make([]string, len(e.Errors))
+	for i, sqlerr := range e.Errors {
+		messages[i] = sqlerr.Error()
+	}
+	return strings.Join(messages, "\n")
+}
+
+// Fatal returns whether any of the errors is fatal, meaning the connection can't be used anymore.
+func (e ServerError) Fatal() bool {
+	for _, sqlerr := range e.Errors {
+		if sqlerr.Class >= fatalErrorClass {
+			return true
+		}
+	}
+	return false
+}
+
+// Unwrap returns the individual errors.
+// A login that failed because of the password is followed by ErrPasswordExpired or ErrPasswordMustChange.
+//
+// A fatal error doesn't include driver.ErrBadConn, as database/sql would then run the statement again on another connection,
+// while the server may already have executed it. The connection returns driver.ErrBadConn from then on instead.
+func (e ServerError) Unwrap() []error {
+	errs := make([]error, 0, len(e.Errors))
+	for _, sqlerr := range e.Errors {
+		errs = append(errs, sqlerr)
+		switch sqlerr.Number {
+		case errNumPasswordExpired:
+			errs = append(errs, ErrPasswordExpired)
+		case errNumPasswordMustChange:
+			errs = append(errs, ErrPasswordMustChange)
+		}
+	}
+	return errs
+}
+
+// checkErrors returns the errors of a response as a ServerError, or nil if there are none.
+// The connection is marked dead if any of them is fatal, after which it only returns driver.ErrBadConn, see IsValid.
+func (c *Conn) checkErrors(sqlerrs []SQLError) error {
+	if len(sqlerrs) == 0 {
+		return nil
+	}
+	err := ServerError{SQLError: sqlerrs[0], Errors: sqlerrs}
+	if err.Fatal() {
+		c.State = Error
+	}
+	return err
+}
+
+// MessageHandler receives the informational messages sent by the server, such as the output of PRINT,
+// RAISERROR with a severity of 10 or lower (including WITH NOWAIT progress messages) and DBCC output.
+// These are sent as INFO tokens, which have the same fields as errors.
+type MessageHandler func(msg SQLError)
+
+// SetMessageHandler sets the function that receives the informational messages of this connection, in the order they are sent.
+// By default they are only logged when verbose logging is on.
+// The handler is called while a response is being read, so it should not use the connection itself.
+//
+// Like BulkCopy, it can be reached through database/sql using sql.Conn.Raw:
+//
+//	err = conn.Raw(func(driverConn interface{}) error {
+//		driverConn.(*gotds.Conn).SetMessageHandler(func(msg gotds.SQLError) { log.Println(msg.Text) })
+//		return nil
+//	})
+func (c *Conn) SetMessageHandler(handler MessageHandler) {
+	c.messageHandler = handler
+}
+
+func (c *Conn) handleMessage(msg SQLError) {
+	if c.cfg.Verbose {
+		errLog.Printf("Received message: %v\n", msg.Text)
+	}
+	if c.messageHandler != nil {
+		c.messageHandler(msg)
+	}
+}
+
+// makeError decodes an ERROR or INFO token, both of which have the same layout.
+func (c *Conn) makeError(raw []byte) (SQLError, error) {
 	var sqlerr SQLError
 
 	buf := bytes.NewBuffer(raw)
 	token, err := buf.ReadByte()
 	if err != nil {
-		panic(err)
+		return sqlerr, err
 	}
-	if token != 0xAA {
-		panic("Invalid error token")
+	if tokenDefinition(token) != errorToken && tokenDefinition(token) != info {
+		return sqlerr, ErrInvalidData
 	}
 
 	var length uint16
 	err = binary.Read(buf, binary.LittleEndian, &length)
 	if err != nil {
-		panic(err)
+		return sqlerr, err
 	}
 
 	err = binary.Read(buf, binary.LittleEndian, &sqlerr.Number)
 	if err != nil {
-		panic(err)
+		return sqlerr, err
 	}
 
 	sqlerr.State, err = buf.ReadByte()
 	if err != nil {
-		panic(err)
+		return sqlerr, err
 	}
 
 	sqlerr.Class, err = buf.ReadByte()
 	if err != nil {
-		panic(err)
+		return sqlerr, err
 	}
 
-	sqlerr.Text = readUS_VarChar(buf)
+	if sqlerr.Text, err = readUS_VarChar(buf); err != nil {
+		return sqlerr, err
+	}
 	errLog.Printf(sqlerr.Text)
-	sqlerr.Server = readB_VarChar(buf)
-	sqlerr.Procedure = readB_VarChar(buf)
+	if sqlerr.Server, err = readB_VarChar(buf); err != nil {
+		return sqlerr, err
+	}
+	if sqlerr.Procedure, err = readB_VarChar(buf); err != nil {
+		return sqlerr, err
+	}
 
 	if c.tdsVersion >= TDS72 {
 		err = binary.Read(buf, binary.LittleEndian, &sqlerr.Line)
 	} else {
 		// TDS7.1 and earlier use a unsigned short instead of a long:
 		var tempLine uint16
-		err = binary.Read(buf, binary.LittleEndian, &sqlerr.Line)
+		err = binary.Read(buf, binary.LittleEndian, &tempLine)
 		sqlerr.Line = int32(tempLine)
 	}
 	if err != nil {
-		panic(err)
+		return sqlerr, err
 	}
 
-	return sqlerr
+	return sqlerr, nil
 }
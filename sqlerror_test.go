@@ -1,13 +1,22 @@
 package gotds
 
 import (
+	"bytes"
+	"database/sql/driver"
+	"encoding/binary"
+	"errors"
 	"testing"
+
+	"github.com/Grovespaz/go-tds/mockserver"
 )
 
 func TestDecodeError(t *testing.T) {
 	c := Conn{tdsVersion: TDS72}
 	rawErr := []byte{0xaa, 0x04, 0x01, 0xab, 0x0f, 0x00, 0x00, 0x03, 0x10, 0x6a, 0x00, 0x54, 0x00, 0x68, 0x00, 0x65, 0x00, 0x20, 0x00, 0x69, 0x00, 0x6e, 0x00, 0x63, 0x00, 0x6f, 0x00, 0x6d, 0x00, 0x69, 0x00, 0x6e, 0x00, 0x67, 0x00, 0x20, 0x00, 0x74, 0x00, 0x61, 0x00, 0x62, 0x00, 0x75, 0x00, 0x6c, 0x00, 0x61, 0x00, 0x72, 0x00, 0x20, 0x00, 0x64, 0x00, 0x61, 0x00, 0x74, 0x00, 0x61, 0x00, 0x20, 0x00, 0x73, 0x00, 0x74, 0x00, 0x72, 0x00, 0x65, 0x00, 0x61, 0x00, 0x6d, 0x00, 0x20, 0x00, 0x28, 0x00, 0x54, 0x00, 0x44, 0x00, 0x53, 0x00, 0x29, 0x00, 0x20, 0x00, 0x70, 0x00, 0x72, 0x00, 0x6f, 0x00, 0x74, 0x00, 0x6f, 0x00, 0x63, 0x00, 0x6f, 0x00, 0x6c, 0x00, 0x20, 0x00, 0x73, 0x00, 0x74, 0x00, 0x72, 0x00, 0x65, 0x00, 0x61, 0x00, 0x6d, 0x00, 0x20, 0x00, 0x69, 0x00, 0x73, 0x00, 0x20, 0x00, 0x69, 0x00, 0x6e, 0x00, 0x63, 0x00, 0x6f, 0x00, 0x72, 0x00, 0x72, 0x00, 0x65, 0x00, 0x63, 0x00, 0x74, 0x00, 0x2e, 0x00, 0x20, 0x00, 0x54, 0x00, 0x68, 0x00, 0x65, 0x00, 0x20, 0x00, 0x4d, 0x00, 0x41, 0x00, 0x52, 0x00, 0x53, 0x00, 0x20, 0x00, 0x54, 0x00, 0x44, 0x00, 0x53, 0x00, 0x20, 0x00, 0x68, 0x00, 0x65, 0x00, 0x61, 0x00, 0x64, 0x00, 0x65, 0x00, 0x72, 0x00, 0x20, 0x00, 0x63, 0x00, 0x6f, 0x00, 0x6e, 0x00, 0x74, 0x00, 0x61, 0x00, 0x69, 0x00, 0x6e, 0x00, 0x65, 0x00, 0x64, 0x00, 0x20, 0x00, 0x65, 0x00, 0x72, 0x00, 0x72, 0x00, 0x6f, 0x00, 0x72, 0x00, 0x73, 0x00, 0x2e, 0x00, 0x11, 0x56, 0x00, 0x50, 0x00, 0x53, 0x00, 0x31, 0x00, 0x32, 0x00, 0x31, 0x00, 0x32, 0x00, 0x30, 0x00, 0x5c, 0x00, 0x53, 0x00, 0x51, 0x00, 0x4c, 0x00, 0x48, 0x00, 0x4f, 0x00, 0x4c, 0x00, 0x4c, 0x00, 0x59, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0xfd, 0x02, 0x00, 0xfd, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}
-	sqlerr := c.makeError(rawErr)
+	sqlerr, err := c.makeError(rawErr)
+	if err != nil {
+		t.Fatal(err)
+	}
 	if sqlerr.Text != "The incoming tabular data stream (TDS) protocol stream is incorrect. The MARS TDS header contained errors." {
 		t.Fatal("SQL Error text could was not properly decoded")
 	}
@@ -15,3 +24,208 @@ func TestDecodeError(t *testing.T) {
 		t.Fatal("SQL Error servername could was not properly decoded")
 	}
 }
+
+// makeInfoToken builds an INFO token for the given message, as sent by e.g. PRINT.
+func makeInfoToken(number int32, text string) []byte {
+	b := new(bytes.Buffer)
+	binary.Write(b, binary.LittleEndian, number)
+	b.Write([]byte{0x01, 0x00}) // State, Class
+	writeUS_VarChar(b, text)
+	writeB_VarChar(b, "srv")
+	writeB_VarChar(b, "")
+	binary.Write(b, binary.LittleEndian, int32(1))
+
+	token := []byte{byte(info), byte(b.Len()), byte(b.Len() >> 8)}
+	return append(token, b.Bytes()...)
+}
+
+func TestMessageHandler(t *testing.T) {
+	// Response to "PRINT 'start'; SELECT 1; RAISERROR('progress', 0, 1) WITH NOWAIT; SELECT 2", as a single result for simplicity:
+	var response []byte
+	response = append(response, makeInfoToken(0, "start")...)
+	response = append(response, 0x81, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x38, 0x00)
+	response = append(response, 0xd1, 0x01, 0x00, 0x00, 0x00)
+	response = append(response, makeInfoToken(50000, "progress")...)
+	response = append(response, 0xd1, 0x02, 0x00, 0x00, 0x00)
+	response = append(response, 0xfd, 0x10, 0x00, 0xc1, 0x00, 0x02, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00)
+
+	mockSrv := mockserver.MakeMockServer([][]byte{makePacket(ptyTableResult, response, 1, true)}, t)
+	c := &Conn{socket: mockSrv, tdsVersion: TDS73, cfg: Config{PacketSize: 0x1000, Placeholder: '?'}}
+
+	var messages []string
+	c.SetMessageHandler(func(msg SQLError) {
+		messages = append(messages, msg.Text)
+	})
+
+	rows, err := c.Query("SELECT 1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 1 || messages[0] != "start" {
+		t.Fatal("Expected the first message before any rows, got: ", messages)
+	}
+
+	values := make([]driver.Value, 1)
+	for i := int64(1); i <= 2; i++ {
+		if err = rows.Next(values); err != nil {
+			t.Fatal(err)
+		}
+		if values[0].(int64) != i {
+			t.Fatal("Did not receive expected value ", i, ", got: ", values[0])
+		}
+	}
+	if len(messages) != 2 || messages[1] != "progress" {
+		t.Fatal("Expected the second message in between the rows, got: ", messages)
+	}
+}
+
+func TestMessageBeforeResult(t *testing.T) {
+	// Response to "UPDATE t SET a = 1; PRINT 'x'; SELECT 1":
+	var response []byte
+	response = append(response, 0xfd, 0x11, 0x00, 0xc5, 0x00, 0x03, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00)
+	response = append(response, makeInfoToken(0, "x")...)
+	response = append(response, 0x81, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x38, 0x00)
+	response = append(response, 0xd1, 0x01, 0x00, 0x00, 0x00)
+	response = append(response, 0xfd, 0x10, 0x00, 0xc1, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00)
+
+	mockSrv := mockserver.MakeMockServer([][]byte{makePacket(ptyTableResult, response, 1, true)}, t)
+	c := &Conn{socket: mockSrv, tdsVersion: TDS73, cfg: Config{PacketSize: 0x1000, Placeholder: '?'}}
+	var messages []string
+	c.SetMessageHandler(func(msg SQLError) {
+		messages = append(messages, msg.Text)
+	})
+
+	rows, err := c.Query("UPDATE t SET a = 1; PRINT 'x'; SELECT 1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 1 || messages[0] != "x" {
+		t.Fatal("Expected the message before the result, got: ", messages)
+	}
+	if len(rows.Columns()) != 1 {
+		t.Fatal("Expected the result of the SELECT, got columns: ", rows.Columns())
+	}
+	values := make([]driver.Value, 1)
+	if err = rows.Next(values); err != nil || values[0].(int64) != 1 {
+		t.Fatal("Did not receive expected row, got: ", values[0], err)
+	}
+}
+
+// makeErrorToken builds an ERROR token with the given number and class.
+func makeErrorToken(number int32, class byte, text string) []byte {
+	token := makeInfoToken(number, text)
+	token[0] = byte(errorToken)
+	token[8] = class
+	return token
+}
+
+func TestMultipleErrors(t *testing.T) {
+	// Each statement ends with its own DONE:
+	var response []byte
+	response = append(response, makeErrorToken(2627, 14, "Violation of PRIMARY KEY constraint 'PK_a'.")...)
+	response = append(response, 0xfd, 0x03, 0x00, 0xc3, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00)
+	response = append(response, makeErrorToken(547, 16, "The INSERT statement conflicted with the FOREIGN KEY constraint 'FK_b'.")...)
+	response = append(response, 0xfd, 0x02, 0x00, 0xc3, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00)
+
+	mockSrv := mockserver.MakeMockServer([][]byte{makePacket(ptyTableResult, response, 1, true)}, t)
+	c := &Conn{socket: mockSrv, tdsVersion: TDS73, cfg: Config{PacketSize: 0x1000, Placeholder: '?'}}
+
+	_, err := c.Exec("INSERT INTO a VALUES (1); INSERT INTO b VALUES (2)", nil)
+	serverErr, ok := err.(ServerError)
+	if !ok {
+		t.Fatal("Expected a ServerError, got: ", err)
+	}
+	if serverErr.Number != 2627 || len(serverErr.Errors) != 2 || serverErr.Errors[1].Number != 547 {
+		t.Fatal("Did not receive expected errors, got: ", serverErr.Errors)
+	}
+
+	var sqlerr SQLError
+	if !errors.As(err, &sqlerr) || sqlerr.Number != 2627 {
+		t.Fatal("Expected errors.As to find the first error, got: ", sqlerr)
+	}
+	if errors.Is(err, driver.ErrBadConn) || c.State == Error {
+		t.Fatal("Non-fatal errors should not mark the connection as dead")
+	}
+}
+
+func TestFatalError(t *testing.T) {
+	response := makeErrorToken(0, 20, "A severe error occurred on the current command.")
+	mockSrv := mockserver.MakeMockServer([][]byte{makePacket(ptyTableResult, response, 1, true)}, t)
+	c := &Conn{socket: mockSrv, tdsVersion: TDS73, cfg: Config{PacketSize: 0x1000, Placeholder: '?'}}
+
+	// The statement may have been executed, so database/sql must not run it again on another connection:
+	_, err := c.Exec("SELECT 1", nil)
+	var serverErr ServerError
+	if !errors.As(err, &serverErr) || !serverErr.Fatal() || errors.Is(err, driver.ErrBadConn) {
+		t.Fatal("Expected a fatal ServerError that isn't a driver.ErrBadConn, got: ", err)
+	}
+	if c.IsValid() {
+		t.Fatal("Expected a fatal error to mark the connection as dead")
+	}
+	if _, err = c.Exec("SELECT 1", nil); err != driver.ErrBadConn {
+		t.Fatal("Expected a dead connection to return driver.ErrBadConn, got: ", err)
+	}
+}
+
+func TestErrorAfterDone(t *testing.T) {
+	// Only the second statement fails:
+	var response []byte
+	response = append(response, 0xfd, 0x11, 0x00, 0xc3, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00)
+	response = append(response, makeErrorToken(547, 16, "The INSERT statement conflicted with the FOREIGN KEY constraint 'FK_b'.")...)
+	response = append(response, 0xfd, 0x02, 0x00, 0xc3, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00)
+	mockSrv := mockserver.MakeMockServer([][]byte{makePacket(ptyTableResult, response, 1, true)}, t)
+	c := &Conn{socket: mockSrv, tdsVersion: TDS73, cfg: Config{PacketSize: 0x1000, Placeholder: '?'}}
+
+	var sqlerr SQLError
+	if _, err := c.Exec("INSERT INTO a VALUES (1); INSERT INTO b VALUES (2)", nil); !errors.As(err, &sqlerr) || sqlerr.Number != 547 {
+		t.Fatal("Expected the error of the second statement, got: ", err)
+	}
+}
+
+func TestErrorInResult(t *testing.T) {
+	// Response to "SELECT 1 / x FROM t", where the second row divides by zero:
+	var response []byte
+	response = append(response, 0x81, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x38, 0x00)
+	response = append(response, 0xd1, 0x01, 0x00, 0x00, 0x00)
+	response = append(response, makeErrorToken(8134, 16, "Divide by zero error encountered.")...)
+	response = append(response, 0xfd, 0x02, 0x00, 0xc1, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00)
+	messages := [][]byte{makePacket(ptyTableResult, response, 1, true), makePacket(ptyTableResult, response, 1, true)}
+	mockSrv := mockserver.MakeMockServer(messages, t)
+	c := &Conn{socket: mockSrv, tdsVersion: TDS73, cfg: Config{PacketSize: 0x1000, Placeholder: '?'}}
+
+	rows, err := c.Query("SELECT 1 / x FROM t", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := make([]driver.Value, 1)
+	if err = rows.Next(values); err != nil {
+		t.Fatal(err)
+	}
+	var sqlerr SQLError
+	if err = rows.Next(values); !errors.As(err, &sqlerr) || sqlerr.Number != 8134 {
+		t.Fatal("Expected the error to end the result, got: ", err)
+	}
+
+	// Exec, which skips the result, reports it as well:
+	if _, err = c.Exec("SELECT 1 / x FROM t", nil); !errors.As(err, &sqlerr) || sqlerr.Number != 8134 {
+		t.Fatal("Expected the error in the result, got: ", err)
+	}
+}
+
+func FuzzMakeError(f *testing.F) {
+	f.Add(makeErrorToken(547, 16, "The INSERT statement conflicted with the FOREIGN KEY constraint 'FK_b'."))
+	f.Add(makeInfoToken(0, "start"))
+	f.Fuzz(func(t *testing.T, raw []byte) {
+		c := Conn{tdsVersion: TDS72}
+		c.makeError(raw)
+	})
+}
+
+func FuzzReadMessage(f *testing.F) {
+	f.Add(makePacket(ptyTableResult, append(makeInfoToken(0, "start"), makeErrorToken(547, 16, "error")...), 1, true))
+	f.Fuzz(func(t *testing.T, packet []byte) {
+		mockSrv := mockserver.MakeMockServer([][]byte{packet}, t)
+		c := &Conn{socket: mockSrv, tdsVersion: TDS73, cfg: Config{PacketSize: 0x1000}}
+		c.readMessage()
+	})
+}
@@ -0,0 +1,161 @@
+// Package ssrp implements a client for the SQL Server Resolution Protocol, as spoken by the SQL Server Browser service.
+// The Browser listens on UDP port 1434 and tells clients which instances run on a machine, and on which ports they can be reached.
+package ssrp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// The port the SQL Server Browser listens on.
+const DefaultPort = "1434"
+
+// The time to wait for a response when the context has no deadline.
+// The Browser doesn't respond at all to requests for unknown instances, so there is always a timeout.
+const DefaultTimeout = 5 * time.Second
+
+// Request types:
+const (
+	clntUcastEx   = 0x03 // List all instances on the machine
+	clntUcastInst = 0x04 // Get the details of a single instance
+)
+
+// The type of a SVR_RESP message
+const svrResp = 0x05
+
+// Instance names are at most 32 bytes.
+const maxInstanceNameLength = 32
+
+var (
+	ErrNoResponse      = errors.New("No response from SQL Server Browser")
+	ErrInvalidResponse = errors.New("Invalid response from SQL Server Browser")
+)
+
+// Instance describes an instance, as reported by the SQL Server Browser.
+type Instance struct {
+	ServerName   string
+	InstanceName string
+	IsClustered  bool
+	Version      string
+	// The TCP port the instance listens on, empty if TCP is disabled.
+	TCPPort string
+	// The name of the pipe the instance listens on, empty if named pipes are disabled.
+	NamedPipe string
+	// All properties as sent, including the ones above.
+	Properties map[string]string
+}
+
+// LookupInstance asks the SQL Server Browser at addr for the details of an instance.
+// The addr is a host, optionally with a port (DefaultPort otherwise).
+func LookupInstance(ctx context.Context, addr, instance string) (*Instance, error) {
+	if instance == "" || len(instance) > maxInstanceNameLength || strings.IndexByte(instance, 0) >= 0 {
+		return nil, fmt.Errorf("Invalid instance name: %q", instance)
+	}
+	request := append([]byte{clntUcastInst}, instance...)
+	request = append(request, 0)
+
+	instances, err := query(ctx, addr, request)
+	if err != nil {
+		return nil, err
+	}
+	for i := range instances {
+		if strings.EqualFold(instances[i].InstanceName, instance) {
+			return &instances[i], nil
+		}
+	}
+	return nil, fmt.Errorf("Instance %v was not found", instance)
+}
+
+// ListInstances asks the SQL Server Browser at addr for all instances on its machine.
+// The addr is a host, optionally with a port (DefaultPort otherwise).
+func ListInstances(ctx context.Context, addr string) ([]Instance, error) {
+	return query(ctx, addr, []byte{clntUcastEx})
+}
+
+// query sends a request and parses the instances in the response.
+func query(ctx context.Context, addr string, request []byte) ([]Instance, error) {
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, DefaultPort)
+	}
+	if _, hasDeadline := ctx.Deadline(); !hasDeadline {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, DefaultTimeout)
+		defer cancel()
+	}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	deadline, _ := ctx.Deadline()
+	if err = conn.SetDeadline(deadline); err != nil {
+		return nil, err
+	}
+
+	if _, err = conn.Write(request); err != nil {
+		return nil, err
+	}
+	// The response is at most 3 bytes of header and 65535 bytes of data:
+	response := make([]byte, 3+0xFFFF)
+	n, err := conn.Read(response)
+	if err != nil {
+		if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+			return nil, ErrNoResponse
+		}
+		return nil, err
+	}
+	return parseResponse(response[:n])
+}
+
+// parseResponse parses a SVR_RESP message, which holds a list of instances like so:
+//
+//	ServerName;HOST;InstanceName;SQLEXPRESS;IsClustered;No;Version;11.0.2100.60;tcp;49172;np;\\HOST\pipe\MSSQL$SQLEXPRESS\sql\query;;
+//
+// Each instance is a list of keys and values separated by semicolons, ending with an empty value.
+func parseResponse(response []byte) ([]Instance, error) {
+	if len(response) < 3 || response[0] != svrResp {
+		return nil, ErrInvalidResponse
+	}
+	size := int(response[1]) | int(response[2])<<8
+	if size > len(response)-3 {
+		return nil, ErrInvalidResponse
+	}
+
+	var instances []Instance
+	for _, data := range strings.Split(string(response[3:3+size]), ";;") {
+		if data == "" {
+			continue
+		}
+		parts := strings.Split(data, ";")
+		if len(parts)%2 != 0 {
+			return nil, ErrInvalidResponse
+		}
+		instance := Instance{Properties: make(map[string]string, len(parts)/2)}
+		for i := 0; i < len(parts); i += 2 {
+			key, value := parts[i], parts[i+1]
+			instance.Properties[key] = value
+			switch strings.ToLower(key) {
+			case "servername":
+				instance.ServerName = value
+			case "instancename":
+				instance.InstanceName = value
+			case "isclustered":
+				instance.IsClustered = strings.EqualFold(value, "yes")
+			case "version":
+				instance.Version = value
+			case "tcp":
+				instance.TCPPort = value
+			case "np":
+				instance.NamedPipe = value
+			}
+		}
+		instances = append(instances, instance)
+	}
+	return instances, nil
+}
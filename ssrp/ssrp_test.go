@@ -0,0 +1,104 @@
+package ssrp
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+// startResponder starts a UDP responder standing in for the SQL Server Browser, returning its address.
+// Requests for instances it doesn't know are ignored, like the Browser does.
+func startResponder(t *testing.T, instances map[string]string) string {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	go func() {
+		buf := make([]byte, 1024)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			var data string
+			switch {
+			case n == 1 && buf[0] == clntUcastEx:
+				for _, instance := range instances {
+					data += instance
+				}
+			case n > 2 && buf[0] == clntUcastInst && buf[n-1] == 0:
+				data = instances[string(buf[1:n-1])]
+			}
+			if data == "" {
+				continue
+			}
+			response := append([]byte{svrResp, byte(len(data)), byte(len(data) >> 8)}, data...)
+			conn.WriteTo(response, addr)
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+var testInstances = map[string]string{
+	"SQLEXPRESS": `ServerName;HOST;InstanceName;SQLEXPRESS;IsClustered;No;Version;11.0.2100.60;tcp;49172;np;\\HOST\pipe\MSSQL$SQLEXPRESS\sql\query;;`,
+	"REPORTING":  `ServerName;HOST;InstanceName;REPORTING;IsClustered;Yes;Version;12.0.2000.8;np;\\HOST\pipe\MSSQL$REPORTING\sql\query;;`,
+}
+
+func TestLookupInstance(t *testing.T) {
+	addr := startResponder(t, testInstances)
+
+	instance, err := LookupInstance(context.Background(), addr, "SQLEXPRESS")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if instance.ServerName != "HOST" || instance.InstanceName != "SQLEXPRESS" || instance.IsClustered || instance.Version != "11.0.2100.60" || instance.TCPPort != "49172" {
+		t.Fatalf("Did not receive expected instance, got: %+v", instance)
+	}
+	if instance.NamedPipe != `\\HOST\pipe\MSSQL$SQLEXPRESS\sql\query` || instance.Properties["tcp"] != "49172" {
+		t.Fatalf("Did not receive expected properties, got: %+v", instance)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if _, err = LookupInstance(ctx, addr, "MISSING"); err != ErrNoResponse {
+		t.Fatal("Expected no response for an unknown instance, got: ", err)
+	}
+	if _, err = LookupInstance(ctx, addr, "THIS_INSTANCE_NAME_IS_FAR_TOO_LONG"); err == nil {
+		t.Fatal("Expected an error for an instance name of over 32 bytes")
+	}
+}
+
+func TestListInstances(t *testing.T) {
+	addr := startResponder(t, testInstances)
+
+	instances, err := ListInstances(context.Background(), addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(instances) != 2 {
+		t.Fatalf("Expected 2 instances, got: %+v", instances)
+	}
+	for _, instance := range instances {
+		if instance.InstanceName == "REPORTING" && (!instance.IsClustered || instance.TCPPort != "") {
+			t.Fatalf("Did not receive expected instance, got: %+v", instance)
+		}
+	}
+}
+
+func TestParseResponse(t *testing.T) {
+	invalid := [][]byte{
+		{},
+		{0x04, 0x00, 0x00},
+		{svrResp, 0x10, 0x00, 'a'},
+		append([]byte{svrResp, 0x05, 0x00}, bytes.Repeat([]byte{'a'}, 5)...),
+	}
+	for _, response := range invalid {
+		if _, err := parseResponse(response); err != ErrInvalidResponse {
+			t.Fatal("Expected an invalid response error for: ", response)
+		}
+	}
+}
@@ -12,7 +12,7 @@ type Stmt struct {
 }
 
 func (c *Conn) Prepare(query string) (driver.Stmt, error) {
-	return Stmt{statement: query}, nil
+	return Stmt{c: c, statement: query}, nil
 }
 
 func (s Stmt) Close() error {
@@ -31,3 +31,14 @@ func (s Stmt) Exec(args []driver.Value) (driver.Result, error) {
 func (s Stmt) Query(args []driver.Value) (driver.Rows, error) {
 	return s.c.Query(s.statement, args)
 }
+
+// CheckNamedValue implements the driver.NamedValueChecker interface.
+// It lets our own parameter types, such as XML, through unconverted so they can be sent with the proper type.
+// Everything else is converted by database/sql as usual.
+func (c *Conn) CheckNamedValue(nv *driver.NamedValue) error {
+	switch nv.Value.(type) {
+	case XML, TVP:
+		return nil
+	}
+	return driver.ErrSkip
+}
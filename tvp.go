@@ -0,0 +1,201 @@
+package gotds
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// TVP is a table-valued parameter.
+// Passing one as a query argument sends the query as an RPC call to sp_executesql, in which the TVP's placeholder is replaced by a parameter name.
+// To pass a TVP to a stored procedure, simply execute it with a placeholder:
+//
+//	db.Exec("EXEC dbo.InsertUsers ?", gotds.TVP{TypeName: "dbo.UserTableType", Rows: users})
+type TVP struct {
+	// The name of the user-defined table type, optionally prefixed with its schema, e.g. "dbo.UserTableType".
+	TypeName string
+	// Either a slice of structs, of which the exported fields make up the columns in order of declaration,
+	// or a slice of rows ([][]interface{}) with the values of the columns in order.
+	// Struct fields tagged with `tds:"-"` are skipped.
+	Rows interface{}
+}
+
+const tvpType columnType = 0xF3
+
+var errInvalidTVPRows = errors.New("TVP rows must be a slice of structs or a [][]interface{}")
+
+// tvpColumns converts the rows of a TVP into driver values, and determines the TYPE_INFO of each column.
+func (tvp TVP) tvpColumns() ([]columnInfo, [][]driver.Value, error) {
+	rv := reflect.ValueOf(tvp.Rows)
+	if rv.Kind() != reflect.Slice {
+		return nil, nil, errInvalidTVPRows
+	}
+
+	if rows, ok := tvp.Rows.([][]interface{}); ok {
+		return tvpColumnsFromRows(rows)
+	}
+
+	elemType := rv.Type().Elem()
+	for elemType.Kind() == reflect.Ptr {
+		elemType = elemType.Elem()
+	}
+	if elemType.Kind() != reflect.Struct {
+		return nil, nil, errInvalidTVPRows
+	}
+
+	var fields []int
+	var columns []columnInfo
+	var known []bool
+	for i := 0; i < elemType.NumField(); i++ {
+		field := elemType.Field(i)
+		if field.PkgPath != "" || field.Tag.Get("tds") == "-" {
+			continue
+		}
+		info, ok, err := typeInfoForType(field.Type)
+		if err != nil {
+			return nil, nil, fmt.Errorf("TVP field %v: %v", field.Name, err)
+		}
+		fields = append(fields, i)
+		columns = append(columns, info)
+		known = append(known, ok)
+	}
+
+	values := make([][]driver.Value, rv.Len())
+	for r := range values {
+		elem := rv.Index(r)
+		for elem.Kind() == reflect.Ptr {
+			if elem.IsNil() {
+				return nil, nil, fmt.Errorf("TVP row %v is nil", r)
+			}
+			elem = elem.Elem()
+		}
+		values[r] = make([]driver.Value, len(fields))
+		for c, f := range fields {
+			v, err := driver.DefaultParameterConverter.ConvertValue(elem.Field(f).Interface())
+			if err != nil {
+				return nil, nil, fmt.Errorf("TVP row %v, field %v: %v", r, elemType.Field(f).Name, err)
+			}
+			values[r][c] = v
+		}
+	}
+	if err := inferColumns(columns, known, values); err != nil {
+		return nil, nil, err
+	}
+	return columns, values, nil
+}
+
+// typeInfoForType determines the TYPE_INFO of a TVP column from the type of a struct field.
+// This isn't possible for types of which the zero value is NULL (e.g. sql.NullInt64), in which case false is returned.
+func typeInfoForType(t reflect.Type) (columnInfo, bool, error) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	v, err := driver.DefaultParameterConverter.ConvertValue(reflect.Zero(t).Interface())
+	if err != nil || v == nil {
+		return columnInfo{}, false, err
+	}
+	info, err := typeInfoForValue(v)
+	return info, err == nil, err
+}
+
+// tvpColumnsFromRows converts rows of values.
+func tvpColumnsFromRows(rows [][]interface{}) ([]columnInfo, [][]driver.Value, error) {
+	if len(rows) == 0 {
+		return nil, nil, nil
+	}
+
+	columnCount := len(rows[0])
+	values := make([][]driver.Value, len(rows))
+	for r, row := range rows {
+		if len(row) != columnCount {
+			return nil, nil, fmt.Errorf("TVP row %v has %v columns instead of %v", r, len(row), columnCount)
+		}
+		values[r] = make([]driver.Value, columnCount)
+		for c, cell := range row {
+			v, err := driver.DefaultParameterConverter.ConvertValue(cell)
+			if err != nil {
+				return nil, nil, fmt.Errorf("TVP row %v, column %v: %v", r, c, err)
+			}
+			values[r][c] = v
+		}
+	}
+
+	columns := make([]columnInfo, columnCount)
+	if err := inferColumns(columns, make([]bool, columnCount), values); err != nil {
+		return nil, nil, err
+	}
+	return columns, values, nil
+}
+
+// inferColumns determines the TYPE_INFO of the columns that aren't known yet by their first non-NULL value.
+// Columns with only NULLs are sent as nvarchar.
+func inferColumns(columns []columnInfo, known []bool, values [][]driver.Value) error {
+	for c := range columns {
+		for r := 0; r < len(values) && !known[c]; r++ {
+			if values[r][c] == nil {
+				continue
+			}
+			info, err := typeInfoForValue(values[r][c])
+			if err != nil {
+				return fmt.Errorf("TVP row %v, column %v: %v", r, c, err)
+			}
+			columns[c], known[c] = info, true
+		}
+		if !known[c] {
+			columns[c], _ = typeInfoForValue(nil)
+		}
+	}
+	return nil
+}
+
+// splitTypeName splits a type name such as "dbo.UserTableType" or "[dbo].[User Type]" into its schema and name.
+func splitTypeName(typeName string) (schema string, name string) {
+	name = typeName
+	if i := strings.LastIndex(typeName, "."); i >= 0 {
+		schema, name = typeName[:i], typeName[i+1:]
+	}
+	return strings.Trim(schema, "[]"), strings.Trim(name, "[]")
+}
+
+// writeTVP writes the TYPE_INFO and value of a TVP as an RPC parameter.
+func writeTVP(buf *bytes.Buffer, tvp TVP) error {
+	columns, rows, err := tvp.tvpColumns()
+	if err != nil {
+		return err
+	}
+
+	schema, name := splitTypeName(tvp.TypeName)
+	buf.WriteByte(byte(tvpType))
+	writeB_VarChar(buf, "") // The database name has to be empty
+	writeB_VarChar(buf, schema)
+	writeB_VarChar(buf, name)
+
+	// TVP_COLMETADATA
+	if len(columns) == 0 {
+		binary.Write(buf, binary.LittleEndian, uint16(0xFFFF)) // TVP_NULL_TOKEN
+	} else {
+		binary.Write(buf, binary.LittleEndian, uint16(len(columns)))
+		for _, column := range columns {
+			binary.Write(buf, binary.LittleEndian, uint32(0)) // UserType
+			binary.Write(buf, binary.LittleEndian, uint16(1)) // Flags: nullable
+			writeTypeInfo(buf, column)
+			writeB_VarChar(buf, "") // Column names have to be empty
+		}
+	}
+	buf.WriteByte(0) // TVP_END_TOKEN, we don't send any ordering
+
+	for r, row := range rows {
+		buf.WriteByte(byte(tvpRow))
+		for c, v := range row {
+			if err := writeValue(buf, columns[c], v); err != nil {
+				return fmt.Errorf("TVP row %v, column %v: %v", r, c, err)
+			}
+		}
+	}
+	buf.WriteByte(0) // TVP_END_TOKEN
+	return nil
+}
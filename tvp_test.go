@@ -0,0 +1,84 @@
+package gotds
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"testing"
+
+	utf16c "github.com/Grovespaz/go-tds/utf16"
+)
+
+type testTVPUser struct {
+	ID      int
+	Name    string
+	skipped bool
+	Ignored string `tds:"-"`
+}
+
+func TestWriteTVP(t *testing.T) {
+	b := new(bytes.Buffer)
+	err := writeTVP(b, TVP{TypeName: "[dbo].[UserType]", Rows: []testTVPUser{{ID: 1, Name: "ab", Ignored: "x"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []byte{0xf3,
+		0x00,
+		0x03, 'd', 0x00, 'b', 0x00, 'o', 0x00,
+		0x08, 'U', 0x00, 's', 0x00, 'e', 0x00, 'r', 0x00, 'T', 0x00, 'y', 0x00, 'p', 0x00, 'e', 0x00,
+		0x02, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x01, 0x00, 0x26, 0x08, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x01, 0x00, 0xe7, 0xff, 0xff, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x00,
+		0x01,
+		0x08, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x04, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x04, 0x00, 0x00, 0x00, 'a', 0x00, 'b', 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x00}
+	if !bytes.Equal(b.Bytes(), expected) {
+		t.Fatalf("Did not receive expected TVP, got:\n% x\nexpected:\n% x", b.Bytes(), expected)
+	}
+}
+
+func TestWriteTVPRows(t *testing.T) {
+	// The type of the first column can only be determined by the second row:
+	rows := [][]interface{}{{nil, "a"}, {int64(2), nil}}
+	columns, values, err := TVP{Rows: rows}.tvpColumns()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if columns[0].columnType != INTNTYPE || columns[1].columnType != NVARCHARTYPE {
+		t.Fatal("Did not infer the expected column types, got: ", columns)
+	}
+	if values[1][0].(int64) != 2 {
+		t.Fatal("Did not receive expected value, got: ", values[1][0])
+	}
+
+	if _, _, err = (TVP{Rows: [][]interface{}{{1}, {1, 2}}}).tvpColumns(); err == nil {
+		t.Fatal("Expected an error for rows of different lengths")
+	}
+	if _, _, err = (TVP{Rows: []int{1}}).tvpColumns(); err == nil {
+		t.Fatal("Expected an error for a slice of ints")
+	}
+}
+
+func TestMakeTVPQueryPacket(t *testing.T) {
+	c := Conn{tdsVersion: TDS73}
+	c.cfg.Placeholder = '?'
+	tvp := TVP{TypeName: "dbo.UserType", Rows: []testTVPUser{{ID: 1, Name: "ab"}}}
+	msgType, packet, err := c.makeQueryPacket("EXEC dbo.InsertUsers ?, ?", []driver.Value{tvp, int64(5)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msgType != ptyRPC {
+		t.Fatal("Queries with a TVP should be sent as RPC, got: ", msgType)
+	}
+	if !bytes.Equal(packet[0x16:0x1a], []byte{0xff, 0xff, 0x0a, 0x00}) {
+		t.Fatal("Expected a call to sp_executesql, got: ", packet[0x16:0x1a])
+	}
+	if !bytes.Contains(packet, utf16c.Encode("EXEC dbo.InsertUsers @p1, 5")) {
+		t.Fatal("Statement does not contain the TVP parameter")
+	}
+	if !bytes.Contains(packet, utf16c.Encode("@p1 dbo.UserType READONLY")) {
+		t.Fatal("Parameter declaration of the TVP is missing")
+	}
+}
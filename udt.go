@@ -0,0 +1,188 @@
+package gotds
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// CLR UDT values (hierarchyid, geometry, geography and user-defined CLR types) are returned as their raw serialized bytes.
+// For the built-in types, HierarchyID, Geometry and Geography can be used as a Scan destination to decode them.
+
+// udtInfo is the type of a CLR UDT column, as sent in its TYPE_INFO.
+type udtInfo struct {
+	dbName   string
+	schema   string
+	typeName string
+	// e.g. "Microsoft.SqlServer.Types.SqlHierarchyId, Microsoft.SqlServer.Types, Version=11.0.0.0, Culture=neutral, PublicKeyToken=89845dcd8080cc91"
+	assemblyQualifiedName string
+}
+
+// readUDTInfo reads the part of the TYPE_INFO of a CLR UDT column that follows the maximum length.
+func readUDTInfo(buf *bytes.Buffer) (*udtInfo, error) {
+	var info udtInfo
+	var err error
+	if info.dbName, err = readB_VarChar(buf); err != nil {
+		return nil, err
+	}
+	if info.schema, err = readB_VarChar(buf); err != nil {
+		return nil, err
+	}
+	if info.typeName, err = readB_VarChar(buf); err != nil {
+		return nil, err
+	}
+	if info.assemblyQualifiedName, err = readUS_VarChar(buf); err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+var ErrInvalidHierarchyID = errors.New("Invalid hierarchyid value")
+
+// HierarchyID holds a hierarchyid in its string representation, e.g. "/1/2.1/".
+// It implements sql.Scanner to decode the serialized value of a hierarchyid column.
+type HierarchyID string
+
+// Scan implements the sql.Scanner interface. NULL is scanned as an empty string.
+func (h *HierarchyID) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case nil:
+		*h = ""
+		return nil
+	case []byte:
+		path, err := decodeHierarchyID(v)
+		if err != nil {
+			return err
+		}
+		*h = HierarchyID(path)
+		return nil
+	}
+	return fmt.Errorf("cannot scan %T into HierarchyID", src)
+}
+
+// A hierarchyid is a bit string of labels, each encoded as a prefix selecting one of the patterns below.
+// In a pattern, x is a bit of the label value (relative to min, most significant bit first), 0 and 1 are fixed bits and T is the terminator bit:
+// 1 means the label ends its level ("/"), 0 means another label follows within the same level (".").
+// The bit string is padded with zeroes up to a whole number of bytes.
+type hierarchyIDPattern struct {
+	prefix  string
+	min     int64
+	pattern string
+}
+
+// The ranges beyond 32-bit label values (prefixes 000100 and 111111) are not supported.
+var hierarchyIDPatterns = []hierarchyIDPattern{
+	{"000101", -4294971464, "xxxxxxxxxxxxxxxxxxx0xxxxxx0xxx0x1xxxT"},
+	{"000110", -4168, "xxxxx0xxx0x1xxxT"},
+	{"0010", -72, "xx0x1xxxT"},
+	{"00111", -8, "xxxT"},
+	{"01", 0, "xxT"},
+	{"100", 4, "xxT"},
+	{"101", 8, "xxxT"},
+	{"110", 16, "xx0x1xxxT"},
+	{"1110", 80, "xxx0xxx0x1xxxT"},
+	{"11110", 1104, "xxxxx0xxx0x1xxxT"},
+	{"111110", 5200, "xxxxxxxxxxxxxxxxxxx0xxxxxx0xxx0x1xxxT"},
+}
+
+// hierarchyIDReader reads a hierarchyid bit by bit.
+type hierarchyIDReader struct {
+	data []byte
+	pos  int // in bits
+}
+
+func (r *hierarchyIDReader) bitsLeft() int {
+	return len(r.data)*8 - r.pos
+}
+
+func (r *hierarchyIDReader) readBit() (byte, error) {
+	if r.bitsLeft() <= 0 {
+		return 0, ErrInvalidHierarchyID
+	}
+	bit := (r.data[r.pos/8] >> uint(7-r.pos%8)) & 1
+	r.pos++
+	return bit, nil
+}
+
+// onlyPadding returns whether the remaining bits are all zero.
+func (r *hierarchyIDReader) onlyPadding() bool {
+	for i := r.pos; i < len(r.data)*8; i++ {
+		if (r.data[i/8]>>uint(7-i%8))&1 != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// matchPrefix reads the prefix of the next label and returns its pattern.
+func (r *hierarchyIDReader) matchPrefix() (*hierarchyIDPattern, error) {
+	prefix := make([]byte, 0, 6)
+	for len(prefix) < 6 {
+		bit, err := r.readBit()
+		if err != nil {
+			return nil, err
+		}
+		prefix = append(prefix, '0'+bit)
+		for i := range hierarchyIDPatterns {
+			if hierarchyIDPatterns[i].prefix == string(prefix) {
+				return &hierarchyIDPatterns[i], nil
+			}
+		}
+	}
+	return nil, ErrInvalidHierarchyID
+}
+
+// readLabel reads a label according to its pattern, returning its value and the terminator bit.
+func (r *hierarchyIDReader) readLabel(p *hierarchyIDPattern) (int64, bool, error) {
+	var value int64
+	for _, c := range p.pattern {
+		bit, err := r.readBit()
+		if err != nil {
+			return 0, false, err
+		}
+		switch c {
+		case 'x':
+			value = value<<1 | int64(bit)
+		case 'T':
+			return p.min + value, bit == 1, nil
+		default:
+			if bit != byte(c-'0') {
+				return 0, false, ErrInvalidHierarchyID
+			}
+		}
+	}
+	return 0, false, ErrInvalidHierarchyID
+}
+
+// decodeHierarchyID decodes a serialized hierarchyid into its string representation.
+func decodeHierarchyID(data []byte) (string, error) {
+	r := hierarchyIDReader{data: data}
+	result := "/"
+	var level []string
+	for !r.onlyPadding() {
+		p, err := r.matchPrefix()
+		if err != nil {
+			return "", err
+		}
+		value, last, err := r.readLabel(p)
+		if err != nil {
+			return "", err
+		}
+		if !last {
+			// Labels followed by another label within the same level are stored incremented by one, so that /1/ < /1.1/ < /2/
+			value--
+		}
+		level = append(level, strconv.FormatInt(value, 10))
+		if last {
+			result += strings.Join(level, ".") + "/"
+			level = level[:0]
+		}
+	}
+	if len(level) > 0 {
+		// The last level wasn't terminated
+		return "", ErrInvalidHierarchyID
+	}
+	return result, nil
+}
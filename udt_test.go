@@ -0,0 +1,79 @@
+package gotds
+
+import (
+	"database/sql/driver"
+	"testing"
+)
+
+func TestDecodeHierarchyID(t *testing.T) {
+	tests := []struct {
+		raw  []byte
+		path string
+	}{
+		{[]byte{}, "/"},
+		{[]byte{0x58}, "/1/"},
+		{[]byte{0x5a, 0xc0}, "/1/1/"},
+		{[]byte{0x62, 0xc0}, "/1.1/"},
+		{[]byte{0x84}, "/4/"},
+		{[]byte{0x3f, 0x80}, "/-1/"},
+		{[]byte{0xc1, 0x10}, "/16/"},
+	}
+	for _, test := range tests {
+		var h HierarchyID
+		if err := h.Scan(test.raw); err != nil {
+			t.Fatalf("Could not decode % x: %v", test.raw, err)
+		}
+		if string(h) != test.path {
+			t.Fatalf("Decoding % x: expected %v, got %v", test.raw, test.path, h)
+		}
+	}
+
+	// A level that isn't terminated:
+	var h HierarchyID
+	if err := h.Scan([]byte{0x50}); err == nil {
+		t.Fatal("Expected an error for an unterminated level, got: ", h)
+	}
+}
+
+func TestParseUDTResult(t *testing.T) {
+	c := Conn{tdsVersion: TDS72}
+	// Original query: "SELECT CAST('/1/' AS hierarchyid)"
+	raw := []byte{0x81, 0x01, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x01, 0x00, 0xf0, 0x92, 0x03,
+		0x04, 't', 0x00, 'e', 0x00, 's', 0x00, 't', 0x00,
+		0x03, 's', 0x00, 'y', 0x00, 's', 0x00,
+		0x0b, 'h', 0x00, 'i', 0x00, 'e', 0x00, 'r', 0x00, 'a', 0x00, 'r', 0x00, 'c', 0x00, 'h', 0x00, 'y', 0x00, 'i', 0x00, 'd', 0x00,
+		0x04, 0x00, 'S', 0x00, 'q', 0x00, 'l', 0x00, 'H', 0x00,
+		0x00,
+		0xd1,
+		0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x01, 0x00, 0x00, 0x00, 0x58,
+		0x00, 0x00, 0x00, 0x00}
+	result, err := c.parseResult(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	udt := result.columnTypes[0].udt
+	if udt == nil || udt.schema != "sys" || udt.typeName != "hierarchyid" || udt.assemblyQualifiedName != "SqlH" {
+		t.Fatal("Did not parse the UDT type info, got: ", udt)
+	}
+
+	values := make([]driver.Value, 1)
+	if err = result.Next(values); err != nil {
+		t.Fatal(err)
+	}
+	var h HierarchyID
+	if err = h.Scan(values[0]); err != nil {
+		t.Fatal(err)
+	}
+	if h != "/1/" {
+		t.Fatal("Did not receive expected hierarchyid, got: ", h)
+	}
+}
+
+func FuzzDecodeHierarchyID(f *testing.F) {
+	f.Add([]byte{0x5a, 0xc0})
+	f.Fuzz(func(t *testing.T, d []byte) {
+		decodeHierarchyID(d)
+	})
+}
@@ -85,7 +85,8 @@ func Encode(s string) []byte {
 func Decode(s []byte) string {
 	a := make([]rune, len(s) / 2)
 	n := 0
-	for i := 0; i < len(s); i+=2 {
+	// A trailing odd byte is ignored
+	for i := 0; i+1 < len(s); i+=2 {
 		switch r := MakeUint16(s[i], s[i + 1]); {
 		case surr1 <= r && r < surr2 && i+3 < len(s) &&
 			surr2 <= MakeUint16(s[i+2], s[i+3]) && MakeUint16(s[i+2], s[i+3]) < surr3:
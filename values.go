@@ -0,0 +1,448 @@
+package gotds
+
+import (
+	"database/sql/driver"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+
+	utf16c "github.com/Grovespaz/go-tds/utf16"
+)
+
+// The functions in this file convert the raw bytes of fixed-length and BYTE-length values to Go types.
+// The length of the data is validated here, since the nullable types (INTN, FLTN, etc.) share these decoders and determine their actual type by length.
+
+// decodeInt decodes tinyint (which is unsigned), smallint, int and bigint values.
+func decodeInt(d []byte) (driver.Value, error) {
+	switch len(d) {
+	case 1:
+		return int64(d[0]), nil
+	case 2:
+		return int64(int16(binary.LittleEndian.Uint16(d))), nil
+	case 4:
+		return int64(int32(binary.LittleEndian.Uint32(d))), nil
+	case 8:
+		return int64(binary.LittleEndian.Uint64(d)), nil
+	}
+	return nil, ErrInvalidData
+}
+
+func decodeBit(d []byte) (driver.Value, error) {
+	if len(d) != 1 {
+		return nil, ErrInvalidData
+	}
+	return d[0] != 0, nil
+}
+
+// decodeFloat decodes real and float values, both are returned as float64.
+func decodeFloat(d []byte) (driver.Value, error) {
+	switch len(d) {
+	case 4:
+		return float64(math.Float32frombits(binary.LittleEndian.Uint32(d))), nil
+	case 8:
+		return math.Float64frombits(binary.LittleEndian.Uint64(d)), nil
+	}
+	return nil, ErrInvalidData
+}
+
+// decodeMoney decodes smallmoney and money values.
+// Just like decimals these are returned as their textual representation so no precision is lost.
+func decodeMoney(d []byte) (driver.Value, error) {
+	var v int64
+	switch len(d) {
+	case 4:
+		v = int64(int32(binary.LittleEndian.Uint32(d)))
+	case 8:
+		// Money is sent as two 4-byte values, the most significant half first
+		v = int64(int32(binary.LittleEndian.Uint32(d[0:4])))<<32 | int64(binary.LittleEndian.Uint32(d[4:8]))
+	default:
+		return nil, ErrInvalidData
+	}
+	return formatDecimal(big.NewInt(v), 4), nil
+}
+
+// decodeDecimal decodes decimal and numeric values: a sign byte (1 = positive) followed by a little-endian integer.
+func decodeDecimal(d []byte, scale byte) (driver.Value, error) {
+	if len(d) < 2 {
+		return nil, ErrInvalidData
+	}
+
+	// big.Int wants big-endian:
+	magnitude := make([]byte, len(d)-1)
+	for i, b := range d[1:] {
+		magnitude[len(magnitude)-1-i] = b
+	}
+	v := new(big.Int).SetBytes(magnitude)
+	if d[0] == 0 {
+		v.Neg(v)
+	}
+	return formatDecimal(v, int(scale)), nil
+}
+
+// formatDecimal formats v / 10^scale without losing precision.
+func formatDecimal(v *big.Int, scale int) []byte {
+	digits := new(big.Int).Abs(v).String()
+	for len(digits) <= scale {
+		digits = "0" + digits
+	}
+
+	result := make([]byte, 0, len(digits)+2)
+	if v.Sign() < 0 {
+		result = append(result, '-')
+	}
+	result = append(result, digits[:len(digits)-scale]...)
+	if scale > 0 {
+		result = append(result, '.')
+		result = append(result, digits[len(digits)-scale:]...)
+	}
+	return result
+}
+
+// decodeDateTime decodes smalldatetime and datetime values.
+// Smalldatetime: USHORT days since 1900-01-01 and USHORT minutes since midnight.
+// Datetime: LONG days since 1900-01-01 and ULONG ticks (1/300th of a second) since midnight.
+func decodeDateTime(d []byte) (driver.Value, error) {
+	switch len(d) {
+	case 4:
+		days := binary.LittleEndian.Uint16(d[0:2])
+		minutes := binary.LittleEndian.Uint16(d[2:4])
+		return time.Date(1900, 1, 1+int(days), 0, int(minutes), 0, 0, time.UTC), nil
+	case 8:
+		days := int32(binary.LittleEndian.Uint32(d[0:4]))
+		ticks := binary.LittleEndian.Uint32(d[4:8])
+		ns := int64(ticks) * 10000000 / 3
+		return time.Date(1900, 1, 1+int(days), 0, 0, 0, 0, time.UTC).Add(time.Duration(ns)), nil
+	}
+	return nil, ErrInvalidData
+}
+
+// decodeDate decodes a date: a 3-byte unsigned number of days since 0001-01-01.
+func decodeDate(d []byte) (driver.Value, error) {
+	if len(d) != 3 {
+		return nil, ErrInvalidData
+	}
+	return dateFromDays(d), nil
+}
+
+// decodeTime decodes a time value, which is returned as a time.Time on 0001-01-01.
+func decodeTime(d []byte, scale byte) (driver.Value, error) {
+	ns, err := timeToNanoseconds(d, scale)
+	if err != nil {
+		return nil, err
+	}
+	return time.Date(1, 1, 1, 0, 0, 0, 0, time.UTC).Add(ns), nil
+}
+
+// decodeDateTime2 decodes a datetime2 value: a time value followed by a date value.
+func decodeDateTime2(d []byte, scale byte) (driver.Value, error) {
+	if len(d) < 6 {
+		return nil, ErrInvalidData
+	}
+	ns, err := timeToNanoseconds(d[:len(d)-3], scale)
+	if err != nil {
+		return nil, err
+	}
+	return dateFromDays(d[len(d)-3:]).Add(ns), nil
+}
+
+// decodeDateTimeOffset decodes a datetimeoffset value: a datetime2 value in UTC, followed by the SHORT offset in minutes.
+func decodeDateTimeOffset(d []byte, scale byte) (driver.Value, error) {
+	if len(d) < 8 {
+		return nil, ErrInvalidData
+	}
+	utc, err := decodeDateTime2(d[:len(d)-2], scale)
+	if err != nil {
+		return nil, err
+	}
+	offset := int16(binary.LittleEndian.Uint16(d[len(d)-2:]))
+	return utc.(time.Time).In(time.FixedZone("", int(offset)*60)), nil
+}
+
+func dateFromDays(d []byte) time.Time {
+	days := int(d[0]) | int(d[1])<<8 | int(d[2])<<16
+	return time.Date(1, 1, 1+days, 0, 0, 0, 0, time.UTC)
+}
+
+// timeToNanoseconds converts the 3 to 5-byte time representation, which counts in units of 10^-scale seconds since midnight.
+func timeToNanoseconds(d []byte, scale byte) (time.Duration, error) {
+	if len(d) < 3 || len(d) > 5 || scale > 7 {
+		return 0, ErrInvalidData
+	}
+	var v int64
+	for i := len(d) - 1; i >= 0; i-- {
+		v = v<<8 | int64(d[i])
+	}
+	for i := scale; i < 9; i++ {
+		v *= 10
+	}
+	return time.Duration(v), nil
+}
+
+// The functions below are the counterparts of the decoders above, they convert Go values to the raw bytes of a column type.
+
+// encodeValue converts a (non-NULL) value to the raw bytes of the nullable or variable-length type described by info.
+// Values are converted where this can be done without surprises, e.g. an int64 can be sent as a decimal and a string as a uniqueidentifier.
+func encodeValue(info columnInfo, v driver.Value) ([]byte, error) {
+	switch info.columnType {
+	case INTNTYPE:
+		return encodeInt(v, info.size)
+	case BITNTYPE:
+		switch val := v.(type) {
+		case bool:
+			if val {
+				return []byte{1}, nil
+			}
+			return []byte{0}, nil
+		case int64:
+			// Any non-zero value is true, as in SQL Server
+			if val != 0 {
+				return []byte{1}, nil
+			}
+			return []byte{0}, nil
+		}
+	case FLTNTYPE:
+		var f float64
+		switch val := v.(type) {
+		case float64:
+			f = val
+		case int64:
+			f = float64(val)
+		default:
+			return nil, errCannotConvert(v, info)
+		}
+		if info.size == 4 {
+			d := make([]byte, 4)
+			binary.LittleEndian.PutUint32(d, math.Float32bits(float32(f)))
+			return d, nil
+		}
+		d := make([]byte, 8)
+		binary.LittleEndian.PutUint64(d, math.Float64bits(f))
+		return d, nil
+	case DECIMALNTYPE, NUMERICNTYPE:
+		return encodeDecimal(v, info)
+	case MONEYNTYPE:
+		money, err := encodeDecimal(v, columnInfo{columnType: DECIMALNTYPE, size: 9, scale: 4})
+		if err != nil {
+			return nil, err
+		}
+		magnitude := binary.LittleEndian.Uint64(money[1:])
+		if magnitude > math.MaxInt64 {
+			return nil, fmt.Errorf("%v is out of range for money", v)
+		}
+		units := int64(magnitude)
+		if money[0] == 0 {
+			units = -units
+		}
+		d := make([]byte, info.size)
+		if info.size == 4 {
+			// Make sure the value fits in smallmoney:
+			if units < math.MinInt32 || units > math.MaxInt32 {
+				return nil, fmt.Errorf("%v is out of range for smallmoney", v)
+			}
+			binary.LittleEndian.PutUint32(d, uint32(int32(units)))
+		} else {
+			binary.LittleEndian.PutUint32(d[0:4], uint32(units>>32))
+			binary.LittleEndian.PutUint32(d[4:8], uint32(units))
+		}
+		return d, nil
+	case DATETIMNTYPE, DATENTYPE, TIMENTYPE, DATETIME2NTYPE, DATETIMEOFFSETNTYPE:
+		t, ok := v.(time.Time)
+		if !ok {
+			return nil, errCannotConvert(v, info)
+		}
+		return encodeDateTimeType(t, info), nil
+	case GUIDTYPE:
+		return encodeGUID(v)
+	case BIGVARCHRTYPE, BIGCHARTYPE:
+		switch val := v.(type) {
+		case string:
+			return encodeCharData(val, info.collation), nil
+		case []byte:
+			return val, nil
+		}
+	case NVARCHARTYPE, NCHARTYPE:
+		switch val := v.(type) {
+		case string:
+			return utf16c.Encode(val), nil
+		case []byte:
+			return utf16c.Encode(string(val)), nil
+		}
+	case XMLTYPE:
+		switch val := v.(type) {
+		case XML:
+			return utf16c.Encode(string(val)), nil
+		case string:
+			return utf16c.Encode(val), nil
+		}
+	case BIGVARBINTYPE, BIGBINARYTYPE:
+		switch val := v.(type) {
+		case []byte:
+			return val, nil
+		case string:
+			return []byte(val), nil
+		}
+	}
+	return nil, errCannotConvert(v, info)
+}
+
+func errCannotConvert(v driver.Value, info columnInfo) error {
+	return fmt.Errorf("%v (%T) can't be converted to %v", v, v, info.typeName())
+}
+
+func encodeInt(v driver.Value, size int) ([]byte, error) {
+	var i int64
+	switch val := v.(type) {
+	case int64:
+		i = val
+	case bool:
+		if val {
+			i = 1
+		}
+	case string:
+		var err error
+		if i, err = strconv.ParseInt(val, 10, 64); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("%v (%T) can't be converted to an integer", v, v)
+	}
+
+	d := make([]byte, 8)
+	binary.LittleEndian.PutUint64(d, uint64(i))
+	// Make sure the value fits:
+	if (size == 1 && (i < 0 || i > math.MaxUint8)) ||
+		(size == 2 && (i < math.MinInt16 || i > math.MaxInt16)) ||
+		(size == 4 && (i < math.MinInt32 || i > math.MaxInt32)) {
+		return nil, fmt.Errorf("%v does not fit in %v bytes", i, size)
+	}
+	return d[:size], nil
+}
+
+// encodeDecimal encodes a decimal or numeric value: a sign byte followed by the little-endian integer of the value multiplied by 10^scale.
+func encodeDecimal(v driver.Value, info columnInfo) ([]byte, error) {
+	var s string
+	switch val := v.(type) {
+	case int64:
+		s = strconv.FormatInt(val, 10)
+	case float64:
+		s = strconv.FormatFloat(val, 'f', int(info.scale), 64)
+	case string:
+		s = val
+	case []byte:
+		s = string(val)
+	default:
+		return nil, errCannotConvert(v, info)
+	}
+
+	r, ok := new(big.Rat).SetString(s)
+	if !ok {
+		return nil, errCannotConvert(v, info)
+	}
+	r.Mul(r, new(big.Rat).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(info.scale)), nil)))
+	if !r.IsInt() {
+		return nil, fmt.Errorf("%v has more than %v decimals", s, info.scale)
+	}
+
+	magnitude := new(big.Int).Abs(r.Num()).Bytes() // Big-endian
+	if len(magnitude) > info.size-1 {
+		return nil, fmt.Errorf("%v does not fit in %v", s, info.typeName())
+	}
+	d := make([]byte, info.size)
+	if r.Sign() >= 0 {
+		d[0] = 1
+	}
+	for i, b := range magnitude {
+		d[len(magnitude)-i] = b
+	}
+	return d, nil
+}
+
+// encodeDateTimeType encodes t as datetime, smalldatetime, date, time, datetime2 or datetimeoffset.
+// All but datetimeoffset store the local time of t, without its zone.
+func encodeDateTimeType(t time.Time, info columnInfo) []byte {
+	if info.columnType == DATETIMEOFFSETNTYPE {
+		_, offset := t.Zone()
+		d := encodeDateTime2(t.UTC(), info.scale)
+		return append(d, byte(offset/60), byte((offset/60)>>8))
+	}
+
+	switch info.columnType {
+	case DATETIMNTYPE:
+		days := daysSince(t, 1900)
+		midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+		sinceMidnight := t.Sub(midnight)
+		d := make([]byte, info.size)
+		if info.size == 4 {
+			binary.LittleEndian.PutUint16(d[0:2], uint16(days))
+			binary.LittleEndian.PutUint16(d[2:4], uint16(sinceMidnight/time.Minute))
+		} else {
+			binary.LittleEndian.PutUint32(d[0:4], uint32(int32(days)))
+			binary.LittleEndian.PutUint32(d[4:8], uint32(sinceMidnight*3/10000000))
+		}
+		return d
+	case DATENTYPE:
+		return encodeDate(t)
+	case TIMENTYPE:
+		return encodeTime(t, info.scale)
+	}
+	return encodeDateTime2(t, info.scale)
+}
+
+func encodeDateTime2(t time.Time, scale byte) []byte {
+	return append(encodeTime(t, scale), encodeDate(t)...)
+}
+
+// encodeDate encodes the date of t as the 3-byte number of days since 0001-01-01.
+func encodeDate(t time.Time) []byte {
+	days := daysSince(t, 1)
+	return []byte{byte(days), byte(days >> 8), byte(days >> 16)}
+}
+
+// encodeTime encodes the time of day of t in units of 10^-scale seconds, using 3 to 5 bytes depending on the scale.
+func encodeTime(t time.Time, scale byte) []byte {
+	v := int64(t.Hour()*3600+t.Minute()*60+t.Second())*1000000000 + int64(t.Nanosecond())
+	for i := scale; i < 9; i++ {
+		v /= 10
+	}
+	length := 5
+	if scale <= 2 {
+		length = 3
+	} else if scale <= 4 {
+		length = 4
+	}
+	d := make([]byte, length)
+	for i := range d {
+		d[i] = byte(v >> uint(8*i))
+	}
+	return d
+}
+
+// daysSince returns the number of days between January 1st of the given year and the date of t.
+func daysSince(t time.Time, year int) int64 {
+	epoch := time.Date(year, 1, 1, 0, 0, 0, 0, time.UTC).Unix() / 86400
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC).Unix()/86400 - epoch
+}
+
+// encodeGUID accepts a uniqueidentifier as its 16 raw bytes, or as a string such as "6F9619FF-8B86-D011-B42D-00C04FC964FF".
+// The first three groups of the string representation are stored little-endian.
+func encodeGUID(v driver.Value) ([]byte, error) {
+	switch val := v.(type) {
+	case []byte:
+		if len(val) == 16 {
+			return val, nil
+		}
+	case string:
+		h, err := hex.DecodeString(strings.Replace(strings.Trim(val, "{}"), "-", "", -1))
+		if err != nil || len(h) != 16 {
+			break
+		}
+		return []byte{h[3], h[2], h[1], h[0], h[5], h[4], h[7], h[6],
+			h[8], h[9], h[10], h[11], h[12], h[13], h[14], h[15]}, nil
+	}
+	return nil, fmt.Errorf("%v (%T) can't be converted to a uniqueidentifier", v, v)
+}
@@ -0,0 +1,54 @@
+package gotds
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDecodeDecimalAndMoney(t *testing.T) {
+	v, err := decodeDecimal([]byte{0x00, 0x05, 0x00, 0x00, 0x00}, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(v.([]byte)) != "-0.005" {
+		t.Fatal("Did not receive expected decimal value, got: ", string(v.([]byte)))
+	}
+
+	// -1.2345 as smallmoney:
+	v, err = decodeMoney([]byte{0xc7, 0xcf, 0xff, 0xff})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(v.([]byte)) != "-1.2345" {
+		t.Fatal("Did not receive expected money value, got: ", string(v.([]byte)))
+	}
+}
+
+func TestDecodeDateTime2AndOffset(t *testing.T) {
+	// 2014-03-01 12:34:56.1234567
+	raw := []byte{0x87, 0xee, 0x97, 0x76, 0x69, 0x3c, 0x38, 0x0b}
+	v, err := decodeDateTime2(raw, 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := time.Date(2014, 3, 1, 12, 34, 56, 123456700, time.UTC)
+	if !v.(time.Time).Equal(expected) {
+		t.Fatal("Did not receive expected datetime2 value, got: ", v)
+	}
+
+	// Same moment, at +01:00:
+	v, err = decodeDateTimeOffset(append(raw, 0x3c, 0x00), 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !v.(time.Time).Equal(expected) {
+		t.Fatal("Did not receive expected datetimeoffset value, got: ", v)
+	}
+	if _, offset := v.(time.Time).Zone(); offset != 3600 {
+		t.Fatal("Did not receive expected datetimeoffset offset, got: ", offset)
+	}
+
+	if _, err = decodeDateTime2(raw[:4], 7); err == nil {
+		t.Fatal("Expected an error for a truncated datetime2")
+	}
+}
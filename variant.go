@@ -0,0 +1,78 @@
+package gotds
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"encoding/binary"
+	"errors"
+)
+
+var errInvalidVariant = errors.New("Invalid sql_variant value")
+
+// readVariant reads a sql_variant value from a ROW token.
+// A LONG length of 0 denotes NULL.
+func readVariant(buf *bytes.Buffer) (driver.Value, error) {
+	var length uint32
+	if err := binary.Read(buf, binary.LittleEndian, &length); err != nil {
+		return nil, err
+	}
+	if length == 0 {
+		return nil, nil
+	}
+	d, err := readBytes(buf, int(length))
+	if err != nil {
+		return nil, err
+	}
+	return parseVariant(d)
+}
+
+// parseVariant decodes the contents of a sql_variant.
+// These start with the base type and the number of property bytes that follow, the properties make up the rest of the TYPE_INFO of the base type.
+// Whatever remains is the actual value, which is converted just like it would be in a column of the base type.
+func parseVariant(d []byte) (driver.Value, error) {
+	if len(d) < 2 {
+		return nil, errInvalidVariant
+	}
+	info := columnInfo{columnType: columnType(d[0])}
+	propBytes := int(d[1])
+	d = d[2:]
+	if len(d) < propBytes {
+		return nil, errInvalidVariant
+	}
+	props, data := d[:propBytes], d[propBytes:]
+
+	var expectedProps int
+	switch info.columnType {
+	case INT1TYPE, BITTYPE, INT2TYPE, INT4TYPE, INT8TYPE, DATETIM4TYPE, DATETIMETYPE,
+		FLT4TYPE, FLT8TYPE, MONEY4TYPE, MONEYTYPE, GUIDTYPE, DATENTYPE:
+		expectedProps = 0
+	case TIMENTYPE, DATETIME2NTYPE, DATETIMEOFFSETNTYPE:
+		expectedProps = 1
+		if len(props) == expectedProps {
+			info.scale = props[0]
+		}
+	case DECIMALNTYPE, NUMERICNTYPE:
+		expectedProps = 2
+		if len(props) == expectedProps {
+			info.precision, info.scale = props[0], props[1]
+		}
+	case BIGVARBINTYPE, BIGBINARYTYPE:
+		// Only the maximum length, which we don't need
+		expectedProps = 2
+	case BIGVARCHRTYPE, BIGCHARTYPE, NVARCHARTYPE, NCHARTYPE:
+		// Collation followed by the maximum length
+		expectedProps = 7
+		if len(props) == expectedProps {
+			info.collation = props[:5]
+		}
+	default:
+		errLog.Printf("Invalid base type for sql_variant: %v \n", info.columnType)
+		return nil, errInvalidVariant
+	}
+	if len(props) != expectedProps {
+		return nil, errInvalidVariant
+	}
+
+	info.size = len(data)
+	return convertValue(data, info)
+}
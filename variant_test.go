@@ -0,0 +1,85 @@
+package gotds
+
+import (
+	"database/sql/driver"
+	"testing"
+	"time"
+)
+
+func TestParseVariant(t *testing.T) {
+	// int 1234:
+	v, err := parseVariant([]byte{0x38, 0x00, 0xd2, 0x04, 0x00, 0x00})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.(int64) != 1234 {
+		t.Fatal("Did not receive expected int value, got: ", v)
+	}
+
+	// datetime 2014-03-01 12:00:00:
+	v, err = parseVariant([]byte{0x3d, 0x00, 0xe1, 0xa2, 0x00, 0x00, 0x00, 0xc1, 0xc5, 0x00})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !v.(time.Time).Equal(time.Date(2014, 3, 1, 12, 0, 0, 0, time.UTC)) {
+		t.Fatal("Did not receive expected datetime value, got: ", v)
+	}
+
+	// nvarchar(10) N'hi':
+	v, err = parseVariant([]byte{0xe7, 0x07, 0x09, 0x04, 0xd0, 0x00, 0x34, 0x14, 0x00, 'h', 0x00, 'i', 0x00})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.(string) != "hi" {
+		t.Fatal("Did not receive expected nvarchar value, got: ", v)
+	}
+
+	// decimal(5,2) 123.45:
+	v, err = parseVariant([]byte{0x6a, 0x02, 0x05, 0x02, 0x01, 0x39, 0x30, 0x00, 0x00})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(v.([]byte)) != "123.45" {
+		t.Fatal("Did not receive expected decimal value, got: ", v)
+	}
+
+	// Property bytes don't match the base type:
+	if _, err = parseVariant([]byte{0x38, 0x01, 0x00, 0xd2, 0x04, 0x00, 0x00}); err == nil {
+		t.Fatal("Expected an error for an int variant with properties")
+	}
+}
+
+func TestParseVariantResult(t *testing.T) {
+	c := Conn{tdsVersion: TDS72}
+	// Original query: "SELECT CAST(1234 AS sql_variant), CAST(NULL AS sql_variant)"
+	raw := []byte{0x81, 0x02, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x09, 0x00, 0x62, 0x10, 0x1f, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x09, 0x00, 0x62, 0x10, 0x1f, 0x00, 0x00, 0x00,
+		0xd1,
+		0x06, 0x00, 0x00, 0x00, 0x38, 0x00, 0xd2, 0x04, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00}
+	result, err := c.parseResult(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	values := make([]driver.Value, 2)
+	err = result.Next(values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if values[0].(int64) != 1234 {
+		t.Fatal("Did not receive expected variant value, got: ", values[0])
+	}
+	if values[1] != nil {
+		t.Fatal("Expected NULL for variant value, got: ", values[1])
+	}
+}
+
+func FuzzParseVariant(f *testing.F) {
+	f.Add([]byte{0x38, 0x00, 0xd2, 0x04, 0x00, 0x00})
+	f.Add([]byte{0xe7, 0x07, 0x09, 0x04, 0xd0, 0x00, 0x34, 0x08, 0x00, 'a', 0x00})
+	f.Fuzz(func(t *testing.T, d []byte) {
+		parseVariant(d)
+	})
+}
@@ -0,0 +1,100 @@
+package gotds
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"encoding/xml"
+	"fmt"
+
+	utf16c "github.com/Grovespaz/go-tds/utf16"
+)
+
+// XML holds the value of an xml column or parameter.
+// It can be used as a destination in Scan, after which Unmarshal can be used to decode the document into a struct:
+//
+//	var doc XML
+//	err := row.Scan(&doc)
+//	...
+//	err = doc.Unmarshal(&myStruct)
+//
+// When passed as a query parameter, the value is sent to the server as xml instead of as a string.
+type XML string
+
+// MarshalXML encodes v using encoding/xml, so that it can be passed as an xml parameter.
+func MarshalXML(v interface{}) (XML, error) {
+	b, err := xml.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return XML(b), nil
+}
+
+// Unmarshal decodes the XML document into v using encoding/xml.
+func (x XML) Unmarshal(v interface{}) error {
+	return xml.Unmarshal([]byte(x), v)
+}
+
+// Scan implements the sql.Scanner interface. NULL is scanned as an empty document.
+func (x *XML) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case nil:
+		*x = ""
+	case string:
+		*x = XML(v)
+	case []byte:
+		*x = XML(v)
+	default:
+		return fmt.Errorf("cannot scan %T into XML", src)
+	}
+	return nil
+}
+
+// xmlSchemaInfo is the optional schema collection an xml column is bound to, as sent in its TYPE_INFO.
+type xmlSchemaInfo struct {
+	dbName           string
+	owningSchema     string
+	schemaCollection string
+}
+
+// readXMLSchemaInfo reads the part of the TYPE_INFO of an xml column that follows the type itself.
+// It starts with a byte indicating whether a schema collection is present, nil is returned if there is none.
+func readXMLSchemaInfo(buf *bytes.Buffer) (*xmlSchemaInfo, error) {
+	schemaPresent, err := buf.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	if schemaPresent == 0 {
+		return nil, nil
+	}
+
+	var info xmlSchemaInfo
+	if info.dbName, err = readB_VarChar(buf); err != nil {
+		return nil, err
+	}
+	if info.owningSchema, err = readB_VarChar(buf); err != nil {
+		return nil, err
+	}
+	if info.schemaCollection, err = readUS_VarChar(buf); err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+// decodeXML decodes an xml value, which the server sends as UTF-16 (optionally starting with a byte order mark).
+func decodeXML(d []byte) string {
+	if len(d) >= 2 && d[0] == 0xFF && d[1] == 0xFE {
+		d = d[2:]
+	}
+	return utf16c.Decode(d)
+}
+
+// xmlLiteral returns the T-SQL literal for an xml parameter.
+func xmlLiteral(x XML) string {
+	return "CAST(N'" + escapeString(string(x)) + "' AS xml)"
+}
+
+// Value implements the driver.Valuer interface.
+// This is only used when XML is converted as a plain string, our own Conn passes XML values on as-is (see CheckNamedValue).
+func (x XML) Value() (driver.Value, error) {
+	return string(x), nil
+}
@@ -0,0 +1,80 @@
+package gotds
+
+import (
+	"database/sql/driver"
+	"testing"
+
+	utf16c "github.com/Grovespaz/go-tds/utf16"
+)
+
+type testXMLDoc struct {
+	Name  string `xml:"name"`
+	Count int    `xml:"count"`
+}
+
+func TestParseXMLResult(t *testing.T) {
+	c := Conn{tdsVersion: TDS72}
+	doc := utf16c.Encode("<doc><name>go-tds</name><count>3</count></doc>")
+	// Original query: "SELECT doc FROM docs", doc being bound to the schema collection [test].[dbo].[docSchema]
+	raw := []byte{0x81, 0x01, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x01, 0x00, 0xf1, 0x01,
+		0x04, 't', 0x00, 'e', 0x00, 's', 0x00, 't', 0x00,
+		0x03, 'd', 0x00, 'b', 0x00, 'o', 0x00,
+		0x09, 0x00, 'd', 0x00, 'o', 0x00, 'c', 0x00, 'S', 0x00, 'c', 0x00, 'h', 0x00, 'e', 0x00, 'm', 0x00, 'a', 0x00,
+		0x03, 'd', 0x00, 'o', 0x00, 'c', 0x00,
+		0xd1,
+		0xfe, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff,
+		byte(len(doc)), 0x00, 0x00, 0x00}
+	raw = append(raw, doc...)
+	raw = append(raw, 0x00, 0x00, 0x00, 0x00)
+
+	result, err := c.parseResult(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	schema := result.columnTypes[0].xmlSchema
+	if schema == nil || schema.dbName != "test" || schema.owningSchema != "dbo" || schema.schemaCollection != "docSchema" {
+		t.Fatal("Did not parse the xml schema collection, got: ", schema)
+	}
+
+	values := make([]driver.Value, 1)
+	if err = result.Next(values); err != nil {
+		t.Fatal(err)
+	}
+
+	var x XML
+	if err = x.Scan(values[0]); err != nil {
+		t.Fatal(err)
+	}
+	var parsed testXMLDoc
+	if err = x.Unmarshal(&parsed); err != nil {
+		t.Fatal(err)
+	}
+	if parsed.Name != "go-tds" || parsed.Count != 3 {
+		t.Fatal("Did not unmarshal expected xml document, got: ", parsed)
+	}
+}
+
+func TestXMLParameter(t *testing.T) {
+	x, err := MarshalXML(testXMLDoc{Name: "go-tds", Count: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if x != "<testXMLDoc><name>go-tds</name><count>1</count></testXMLDoc>" {
+		t.Fatal("Did not marshal expected xml document, got: ", x)
+	}
+
+	var c Conn
+	if err = c.CheckNamedValue(&driver.NamedValue{Value: x}); err != nil {
+		t.Fatal("XML parameters should be passed on as-is, got: ", err)
+	}
+
+	query, err := escapeParameters("INSERT INTO docs VALUES (?)", []driver.Value{XML(`<doc name="it's"/>`)}, '?')
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `INSERT INTO docs VALUES (CAST(N'<doc name="it''s"/>' AS xml))`
+	if query != expected {
+		t.Fatal("Did not receive expected query, got: ", query)
+	}
+}